@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/archive"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/genesis"
+)
+
+var (
+	exportFile string
+	exportFrom uint64
+	exportTo   uint64
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports the range of blocks together with the genesis into an archive",
+	Run:   exportRun,
+}
+
+func init() {
+	exportCmd.Flags().StringVarP(
+		&exportFile,
+		"file", "f",
+		"",
+		"The path to the archive to create.",
+	)
+	exportCmd.Flags().Uint64Var(
+		&exportFrom,
+		"from",
+		1,
+		"The height of the first block to export.",
+	)
+	exportCmd.Flags().Uint64Var(
+		&exportTo,
+		"to",
+		0,
+		"The height of the last block to export, the last block of the chain by default.",
+	)
+	rootCmd.AddCommand(exportCmd)
+}
+
+func exportRun(_ *cobra.Command, _ []string) {
+	if err := export(); err != nil {
+		fmt.Println(fmt.Errorf("export err: %w", err))
+		os.Exit(1)
+	}
+}
+
+func export() error {
+	if exportFile == "" {
+		return errors.New("file cannot be empty")
+	}
+
+	gen, err := genesis.LoadFile(genesisPath)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	to := exportTo
+	if to == 0 {
+		if to, err = store.Head(); err != nil {
+			return err
+		}
+	}
+
+	// Archive is written next to its final location and renamed once it is complete.
+	tmp := exportFile + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp)
+	}()
+
+	manifest, err := archive.Export(f, gen, store, exportFrom, to)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, exportFile); err != nil {
+		return err
+	}
+
+	fmt.Printf("exported blocks from: %d to: %d into: %s\n", manifest.From, manifest.To, exportFile)
+	return nil
+}
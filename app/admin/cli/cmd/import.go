@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/goccy/go-json"
+	"github.com/spf13/cobra"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/archive"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/genesis"
+)
+
+var (
+	importFile       string
+	snapshotInterval uint64
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Imports the archive, validating every block before it is stored",
+	Run:   importRun,
+}
+
+func init() {
+	importCmd.Flags().StringVarP(
+		&importFile,
+		"file", "f",
+		"",
+		"The path to the archive to import.",
+	)
+	importCmd.Flags().Uint64Var(
+		&snapshotInterval,
+		"snapshot-interval",
+		100,
+		"Every how many blocks the state snapshot is persisted.",
+	)
+	rootCmd.AddCommand(importCmd)
+}
+
+func importRun(_ *cobra.Command, _ []string) {
+	if err := importArchive(); err != nil {
+		fmt.Println(fmt.Errorf("import err: %w", err))
+		os.Exit(1)
+	}
+}
+
+func importArchive() error {
+	if importFile == "" {
+		return errors.New("file cannot be empty")
+	}
+
+	f, err := os.Open(importFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	rd, err := archive.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rd.Close()
+	}()
+
+	if err = ensureGenesis(rd.Genesis()); err != nil {
+		return err
+	}
+
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+
+	db, err := database.New(database.Config{
+		Genesis:          rd.Genesis(),
+		Storage:          store,
+		SnapshotInterval: snapshotInterval,
+	})
+	if err != nil {
+		_ = store.Close()
+		return err
+	}
+	defer db.Close()
+
+	applied, err := archive.Import(rd, db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("imported %d blocks, last height: %d\n", applied, db.LastBlock().Height())
+	return nil
+}
+
+// ensureGenesis writes the genesis of the archive when the node does not have one yet,
+// otherwise it ensures the archive has been built on top of the same genesis.
+func ensureGenesis(gen genesis.Genesis) error {
+	local, err := genesis.LoadFile(genesisPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return genesis.SaveFile(genesisPath, gen)
+	}
+	if err != nil {
+		return err
+	}
+
+	localBs, err := json.Marshal(local)
+	if err != nil {
+		return err
+	}
+	bs, err := json.Marshal(gen)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(localBs, bs) {
+		return fmt.Errorf("archive genesis does not match the genesis file: %s", genesisPath)
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/genesis"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/storage"
+)
+
+var (
+	storageEngine string
+	dataPath      string
+	segmentSize   int64
+	genesisPath   string
+)
+
+const (
+	defaultDataPath    = "data/miner"
+	defaultSegmentSize = 64 << 20
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVarP(&storageEngine,
+		"storage", "s",
+		storage.Disk,
+		"The storage engine of the node: disk, segment or memory.",
+	)
+	rootCmd.PersistentFlags().StringVarP(&dataPath,
+		"data-path", "d",
+		defaultDataPath,
+		"The path to the node data.",
+	)
+	rootCmd.PersistentFlags().Int64Var(&segmentSize,
+		"segment-size",
+		defaultSegmentSize,
+		"The size cap of a single segment file.",
+	)
+	rootCmd.PersistentFlags().StringVarP(&genesisPath,
+		"genesis-path", "g",
+		genesis.DefaultPath,
+		"The path to the genesis file.",
+	)
+}
+
+var rootCmd = &cobra.Command{
+	Use:   "app",
+	Short: "FitbitAdmin",
+}
+
+func Execute() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func openStorage() (database.Storage, error) {
+	return storage.New(storage.Config{
+		Engine:      storageEngine,
+		DataPath:    dataPath,
+		SegmentSize: segmentSize,
+	})
+}
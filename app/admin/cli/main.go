@@ -0,0 +1,7 @@
+package main
+
+import "github.com/tchorzewski1991/fitbit/app/admin/cli/cmd"
+
+func main() {
+	cmd.Execute()
+}
@@ -1,6 +1,7 @@
 package private
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -44,6 +45,16 @@ func (h Handlers) UncommittedTx(c *gin.Context) {
 		txs = append(txs, dbTx)
 	}
 
+	if acceptsBinary(c) {
+		bs, err := database.MarshalTxsBinary(txs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, web.Error(fmt.Errorf("failed to encode txs: %w", err)))
+			return
+		}
+		c.Data(http.StatusOK, database.BinaryMediaType, bs)
+		return
+	}
+
 	c.JSON(http.StatusOK, txs)
 }
 
@@ -88,7 +99,12 @@ func (h Handlers) BlocksByHeight(c *gin.Context) {
 		to = parsedTo
 	}
 
+	// Peers asking for the pruned blocks are expected to fall back to other nodes.
 	blocks, err := h.State.QueryBlocksByHeight(from, to)
+	if errors.Is(err, database.ErrBlockPruned) {
+		c.JSON(http.StatusGone, web.Error(fmt.Errorf("failed to query blocks by height: %w", err)))
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, web.Error(fmt.Errorf("failed to query blocks by height: %w", err)))
 		return
@@ -100,6 +116,16 @@ func (h Handlers) BlocksByHeight(c *gin.Context) {
 		result = append(result, block.ToBlockData())
 	}
 
+	if acceptsBinary(c) {
+		bs, err := database.MarshalBlocksBinary(result)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, web.Error(fmt.Errorf("failed to encode blocks: %w", err)))
+			return
+		}
+		c.Data(http.StatusOK, database.BinaryMediaType, bs)
+		return
+	}
+
 	c.JSON(http.StatusOK, result)
 }
 
@@ -122,9 +148,9 @@ func (h Handlers) SubmitPeer(c *gin.Context) {
 func (h Handlers) SubmitBlock(c *gin.Context) {
 
 	var blockData database.BlockData
-	err := c.ShouldBindJSON(&blockData)
+	err := bindBody(c, &blockData)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, web.Error(fmt.Errorf("failed to decode block data: %w", err)))
+		c.JSON(bindStatus(err), web.Error(fmt.Errorf("failed to decode block data: %w", err)))
 		return
 	}
 
@@ -146,9 +172,9 @@ func (h Handlers) SubmitBlock(c *gin.Context) {
 func (h Handlers) SubmitTx(c *gin.Context) {
 
 	var tx database.BlockTx
-	err := c.ShouldBindJSON(&tx)
+	err := bindBody(c, &tx)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, web.Error(fmt.Errorf("failed to decode tx data: %w", err)))
+		c.JSON(bindStatus(err), web.Error(fmt.Errorf("failed to decode tx data: %w", err)))
 		return
 	}
 
@@ -159,3 +185,62 @@ func (h Handlers) SubmitTx(c *gin.Context) {
 
 	c.JSON(http.StatusOK, web.Success())
 }
+
+// PeerConn handler upgrades the request of the peer to the long-lived connection
+// carrying blocks, transactions, status and peers. Peers which fail to connect fall
+// back to the other private routes.
+func (h Handlers) PeerConn(c *gin.Context) {
+	err := h.State.AcceptPeerConn(c.Writer, c.Request)
+	if errors.Is(err, state.ErrTransportDisabled) {
+		c.JSON(http.StatusNotFound, web.Error(err))
+		return
+	}
+
+	// Failed upgrade has been already reported to the peer.
+	if err != nil {
+		h.Log.Infow("peer connection failed", "error", err)
+	}
+}
+
+// binaryUnmarshaler is implemented by the values with the binary encoding.
+type binaryUnmarshaler interface {
+	UnmarshalBinary(data []byte) error
+}
+
+// errUnsupportedMediaType is returned when the request body is neither JSON nor
+// encoded with the binary codec.
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// bindBody decodes the request body with the binary codec when the peer sends
+// the binary media type, and with JSON otherwise.
+func bindBody(c *gin.Context, value binaryUnmarshaler) error {
+	switch c.ContentType() {
+	case database.BinaryMediaType:
+	case "", gin.MIMEJSON:
+		return c.ShouldBindJSON(value)
+	default:
+		return fmt.Errorf("%w: %s", errUnsupportedMediaType, c.ContentType())
+	}
+
+	bs, err := c.GetRawData()
+	if err != nil {
+		return err
+	}
+	return value.UnmarshalBinary(bs)
+}
+
+// bindStatus returns the status of the request which body failed to bind. Bodies
+// this node cannot decode at all are reported with 415, so peers know to send them
+// with JSON, while the invalid data is reported with 400.
+func bindStatus(err error) int {
+	if errors.Is(err, errUnsupportedMediaType) || errors.Is(err, database.ErrUnsupportedCodec) {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
+
+// acceptsBinary checks whether the peer asked for the response encoded with the binary codec.
+// JSON is offered first, so it stays the default for the clients without the Accept header.
+func acceptsBinary(c *gin.Context) bool {
+	return c.NegotiateFormat(gin.MIMEJSON, database.BinaryMediaType) == database.BinaryMediaType
+}
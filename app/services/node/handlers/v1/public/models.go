@@ -1,7 +1,11 @@
 package public
 
 import (
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/mempool"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/state"
+	"github.com/tchorzewski1991/fitbit/core/web"
 )
 
 // account represents the details of the account which
@@ -22,6 +26,38 @@ func toAccount(h Handlers, dbAccount database.Account) account {
 	}
 }
 
+// accountNonce represents the next nonce the account can use
+// which will be serialized and moved over the wire.
+type accountNonce struct {
+	ID    string `json:"id"`
+	Nonce uint64 `json:"nonce"`
+}
+
+// accountProof represents the details of the account together with the proof
+// of its inclusion in the state root, which will be serialized and moved over the wire.
+type accountProof struct {
+	Account   account  `json:"account"`
+	Height    uint64   `json:"height"`
+	StateRoot string   `json:"state_root"`
+	Bitmap    string   `json:"bitmap"`
+	Siblings  []string `json:"siblings"`
+}
+
+func toAccountProof(h Handlers, dbProof database.AccountProof) accountProof {
+	siblings := make([]string, 0, len(dbProof.Proof.Siblings))
+	for _, sibling := range dbProof.Proof.Siblings {
+		siblings = append(siblings, hexutil.Encode(sibling))
+	}
+
+	return accountProof{
+		Account:   toAccount(h, dbProof.Account),
+		Height:    dbProof.Height,
+		StateRoot: dbProof.StateRoot,
+		Bitmap:    hexutil.Encode(dbProof.Proof.Bitmap),
+		Siblings:  siblings,
+	}
+}
+
 // uncommitedTx represents the details of the transaction which
 // will be serialized and moved over the wire.
 type uncommitedTx struct {
@@ -57,3 +93,234 @@ func toUncommittedTx(h Handlers, dbTx database.BlockTx) uncommitedTx {
 		Timestamp: dbTx.Timestamp,
 	}
 }
+
+// tipEstimate represents the suggested tip of the transaction
+// which will be serialized and moved over the wire.
+type tipEstimate struct {
+	Tip        uint64 `json:"tip"`
+	BlockTip   uint64 `json:"block_tip"`
+	MempoolTip uint64 `json:"mempool_tip"`
+	Blocks     int    `json:"blocks"`
+	Pending    int    `json:"pending"`
+}
+
+func toTipEstimate(estimate state.TipEstimate) tipEstimate {
+	return tipEstimate{
+		Tip:        estimate.Tip,
+		BlockTip:   estimate.BlockTip,
+		MempoolTip: estimate.MempoolTip,
+		Blocks:     estimate.Blocks,
+		Pending:    estimate.Pending,
+	}
+}
+
+// simulation represents the outcome of the simulated transaction
+// which will be serialized and moved over the wire.
+type simulation struct {
+	Status   string          `json:"status"`
+	Error    string          `json:"error,omitempty"`
+	GasUsed  uint64          `json:"gas_used"`
+	GasFee   uint64          `json:"gas_fee"`
+	Tip      uint64          `json:"tip"`
+	Accounts []accountChange `json:"accounts"`
+}
+
+// accountChange represents the account before and after the simulated transaction.
+type accountChange struct {
+	Before account `json:"before"`
+	After  account `json:"after"`
+}
+
+func toSimulation(h Handlers, sim state.Simulation) simulation {
+	changes := make([]accountChange, 0, len(sim.Accounts))
+	for _, change := range sim.Accounts {
+		changes = append(changes, accountChange{
+			Before: toAccount(h, change.Before),
+			After:  toAccount(h, change.After),
+		})
+	}
+
+	return simulation{
+		Status:   sim.Status,
+		Error:    sim.Error,
+		GasUsed:  sim.GasUsed,
+		GasFee:   sim.GasFee,
+		Tip:      sim.Tip,
+		Accounts: changes,
+	}
+}
+
+// submittedTx represents the outcome of the transaction submission
+// which will be serialized and moved over the wire.
+type submittedTx struct {
+	Message string `json:"message"`
+	Outcome string `json:"outcome"`
+	Hash    string `json:"hash,omitempty"`
+}
+
+func toSubmittedTx(submission state.Submission) submittedTx {
+	return submittedTx{
+		Message: web.Success().Message,
+		Outcome: submission.Outcome.String(),
+		Hash:    submission.Hash,
+	}
+}
+
+// txStatus represents the lifecycle status of the transaction
+// which will be serialized and moved over the wire.
+type txStatus struct {
+	Hash          string `json:"hash"`
+	Status        string `json:"status"`
+	BlockHeight   uint64 `json:"block_height,omitempty"`
+	BlockHash     string `json:"block_hash,omitempty"`
+	Confirmations uint64 `json:"confirmations,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+func toTxStatus(status state.TxStatus) txStatus {
+	return txStatus{
+		Hash:          status.Hash,
+		Status:        status.Status,
+		BlockHeight:   status.BlockHeight,
+		BlockHash:     status.BlockHash,
+		Confirmations: status.Confirmations,
+		Reason:        status.Reason,
+		Error:         status.Error,
+	}
+}
+
+// evictedTx represents the details of the transaction evicted from the mempool
+// which will be serialized and moved over the wire.
+type evictedTx struct {
+	Hash      string `json:"hash"`
+	Reason    string `json:"reason"`
+	Error     string `json:"error,omitempty"`
+	EvictedAt int64  `json:"evicted_at"`
+	uncommitedTx
+}
+
+func toEvictedTx(h Handlers, eviction mempool.Eviction) evictedTx {
+	return evictedTx{
+		Hash:         eviction.Hash,
+		Reason:       string(eviction.Reason),
+		Error:        eviction.Error,
+		EvictedAt:    eviction.EvictedAt.UnixNano(),
+		uncommitedTx: toUncommittedTx(h, eviction.Tx),
+	}
+}
+
+// committedTx represents the details of the transaction included in the block
+// which will be serialized and moved over the wire.
+type committedTx struct {
+	Hash        string `json:"hash"`
+	BlockHeight uint64 `json:"block_height"`
+	BlockHash   string `json:"block_hash"`
+	Index       int    `json:"index"`
+	uncommitedTx
+}
+
+func toCommittedTx(h Handlers, dbTx database.BlockTx, dbReceipt database.Receipt) committedTx {
+	return committedTx{
+		Hash:         dbReceipt.TxHash,
+		BlockHeight:  dbReceipt.BlockHeight,
+		BlockHash:    dbReceipt.BlockHash,
+		Index:        dbReceipt.Index,
+		uncommitedTx: toUncommittedTx(h, dbTx),
+	}
+}
+
+// receipt represents the outcome of the committed transaction
+// which will be serialized and moved over the wire.
+type receipt struct {
+	TxHash      string `json:"tx_hash"`
+	BlockHeight uint64 `json:"block_height"`
+	BlockHash   string `json:"block_hash"`
+	Index       int    `json:"index"`
+	GasUsed     uint64 `json:"gas_used"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+func toReceipt(dbReceipt database.Receipt) receipt {
+	return receipt{
+		TxHash:      dbReceipt.TxHash,
+		BlockHeight: dbReceipt.BlockHeight,
+		BlockHash:   dbReceipt.BlockHash,
+		Index:       dbReceipt.Index,
+		GasUsed:     dbReceipt.GasUsed,
+		Status:      dbReceipt.Status,
+		Error:       dbReceipt.Error,
+	}
+}
+
+// accountTx represents the change of the account balance made by the committed
+// transaction which will be serialized and moved over the wire.
+type accountTx struct {
+	BlockHeight      uint64             `json:"block_height"`
+	Index            int                `json:"index"`
+	TxHash           string             `json:"tx_hash,omitempty"`
+	Direction        string             `json:"direction"`
+	Counterparty     database.AccountID `json:"counterparty,omitempty"`
+	CounterpartyName string             `json:"counterparty_name,omitempty"`
+	Amount           uint64             `json:"amount"`
+	Cursor           string             `json:"cursor"`
+}
+
+// accountTxPage represents a single page of the account history.
+type accountTxPage struct {
+	Txs        []accountTx `json:"txs"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+func toAccountTxPage(h Handlers, dbTxs []database.AccountTx, next string) accountTxPage {
+	txs := make([]accountTx, 0, len(dbTxs))
+	for _, dbTx := range dbTxs {
+		var name string
+		if dbTx.Counterparty != "" {
+			name = h.NameService.FindName(dbTx.Counterparty)
+		}
+		txs = append(txs, accountTx{
+			BlockHeight:      dbTx.BlockHeight,
+			Index:            dbTx.Index,
+			TxHash:           dbTx.TxHash,
+			Direction:        dbTx.Direction,
+			Counterparty:     dbTx.Counterparty,
+			CounterpartyName: name,
+			Amount:           dbTx.Amount,
+			Cursor:           dbTx.Cursor(),
+		})
+	}
+
+	return accountTxPage{
+		Txs:        txs,
+		NextCursor: next,
+	}
+}
+
+// block represents the details of the block which
+// will be serialized and moved over the wire.
+type block struct {
+	Hash   string               `json:"hash"`
+	Header database.BlockHeader `json:"header"`
+	Txs    []uncommitedTx       `json:"txs"`
+}
+
+func toBlock(h Handlers, dbBlock database.Block) block {
+	txs := make([]uncommitedTx, 0)
+	for _, dbTx := range dbBlock.Tree.Values() {
+		txs = append(txs, toUncommittedTx(h, dbTx))
+	}
+
+	return block{
+		Hash:   dbBlock.Hash(),
+		Header: dbBlock.Header,
+		Txs:    txs,
+	}
+}
+
+// blockPage represents a single page of blocks.
+type blockPage struct {
+	Blocks   []block `json:"blocks"`
+	NextFrom uint64  `json:"next_from,omitempty"`
+}
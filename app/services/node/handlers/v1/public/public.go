@@ -1,17 +1,26 @@
 package public
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/mempool"
 	"github.com/tchorzewski1991/fitbit/core/blockchain/state"
 	"github.com/tchorzewski1991/fitbit/core/nameservice"
 	"github.com/tchorzewski1991/fitbit/core/web"
 	"go.uber.org/zap"
 )
 
+// Page limits applied to paginated endpoints.
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
 type Handlers struct {
 	Log         *zap.SugaredLogger
 	State       *state.State
@@ -33,10 +42,26 @@ func (h Handlers) Genesis(c *gin.Context) {
 }
 
 // Accounts handler provides info about all account balances.
+// Optional height query param provides balances at given block height.
 func (h Handlers) Accounts(c *gin.Context) {
+	height, ok, err := heightQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.Error(err))
+		return
+	}
+
+	dbAccounts := h.State.Accounts()
+	if ok {
+		dbAccounts, err = h.State.AccountsAt(height)
+		if err != nil {
+			c.JSON(http.StatusNotFound, web.Error(err))
+			return
+		}
+	}
+
 	accounts := make([]account, 0)
 
-	for _, dbAccount := range h.State.Accounts() {
+	for _, dbAccount := range dbAccounts {
 		accounts = append(accounts, toAccount(h, dbAccount))
 	}
 
@@ -44,6 +69,7 @@ func (h Handlers) Accounts(c *gin.Context) {
 }
 
 // Account handler provides info about specific account balance.
+// Optional height query param provides balance at given block height.
 func (h Handlers) Account(c *gin.Context) {
 	accountID, err := database.ToAccountID(c.Param("address"))
 	if err != nil {
@@ -51,7 +77,18 @@ func (h Handlers) Account(c *gin.Context) {
 		return
 	}
 
-	dbAccount, err := h.State.Account(accountID)
+	height, ok, err := heightQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.Error(err))
+		return
+	}
+
+	var dbAccount database.Account
+	if ok {
+		dbAccount, err = h.State.AccountAt(accountID, height)
+	} else {
+		dbAccount, err = h.State.Account(accountID)
+	}
 	if err != nil {
 		c.JSON(http.StatusNotFound, web.Error(err))
 		return
@@ -60,6 +97,90 @@ func (h Handlers) Account(c *gin.Context) {
 	c.JSON(http.StatusOK, toAccount(h, dbAccount))
 }
 
+// AccountProof handler provides info about specific account balance together with
+// the proof of its inclusion in the state root of the last block.
+func (h Handlers) AccountProof(c *gin.Context) {
+	accountID, err := database.ToAccountID(c.Param("address"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.Error(err))
+		return
+	}
+
+	dbProof, err := h.State.AccountProof(accountID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, web.Error(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, toAccountProof(h, dbProof))
+}
+
+// AccountNonce handler provides the next nonce specific account can use, which accounts
+// for the uncommitted transactions of the account.
+func (h Handlers) AccountNonce(c *gin.Context) {
+	accountID, err := database.ToAccountID(c.Param("address"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.Error(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, accountNonce{
+		ID:    string(accountID),
+		Nonce: h.State.NextNonce(accountID),
+	})
+}
+
+// AccountTxs handler provides the history of committed transactions touching specific account.
+// Results are paginated with the cursor and limit query params and filtered with the direction one.
+func (h Handlers) AccountTxs(c *gin.Context) {
+	accountID, err := database.ToAccountID(c.Param("address"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.Error(err))
+		return
+	}
+
+	limit, err := limitQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.Error(err))
+		return
+	}
+
+	dbTxs, next, err := h.State.AccountTxs(accountID, database.AccountTxQuery{
+		Cursor:    c.Query("cursor"),
+		Limit:     limit,
+		Direction: c.Query("direction"),
+	})
+	if errors.Is(err, database.ErrBlockPruned) {
+		c.JSON(http.StatusGone, web.Error(err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.Error(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, toAccountTxPage(h, dbTxs, next))
+}
+
+// TipEstimate handler provides the tip estimate derived from the recent blocks and the mempool.
+func (h Handlers) TipEstimate(c *gin.Context) {
+	c.JSON(http.StatusOK, toTipEstimate(h.State.EstimateTip()))
+}
+
+// SimulateWalletTx handler applies the transaction to a copy of the current state,
+// including the uncommitted transactions, and provides the outcome without storing it.
+func (h Handlers) SimulateWalletTx(c *gin.Context) {
+
+	var tx database.SignedTx
+	err := c.ShouldBindJSON(&tx)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.Error(fmt.Errorf("failed to decode tx: %w", err)))
+		return
+	}
+
+	c.JSON(http.StatusOK, toSimulation(h, h.State.SimulateTx(tx)))
+}
+
 // SubmitWalletTx handler adds new transaction to the mempool.
 func (h Handlers) SubmitWalletTx(c *gin.Context) {
 
@@ -70,13 +191,17 @@ func (h Handlers) SubmitWalletTx(c *gin.Context) {
 		return
 	}
 
-	err = h.State.UpsertWalletTx(tx)
+	submission, err := h.State.UpsertWalletTx(tx)
+	if errors.Is(err, mempool.ErrUnderpriced) {
+		c.JSON(http.StatusConflict, web.Error(fmt.Errorf("failed to upsert tx: %w", err)))
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, web.Error(fmt.Errorf("failed to upsert tx: %w", err)))
 		return
 	}
 
-	c.JSON(http.StatusOK, web.Success())
+	c.JSON(http.StatusOK, toSubmittedTx(submission))
 }
 
 // UncommittedWalletTx handler provides info about all uncommited transactions.
@@ -106,3 +231,162 @@ func (h Handlers) UncommittedWalletAccountTx(c *gin.Context) {
 
 	c.JSON(http.StatusOK, txs)
 }
+
+// EvictedWalletTx handler provides info about the most recent transactions evicted from the mempool.
+func (h Handlers) EvictedWalletTx(c *gin.Context) {
+	txs := make([]evictedTx, 0)
+
+	for _, eviction := range h.State.EvictedTx() {
+		txs = append(txs, toEvictedTx(h, eviction))
+	}
+
+	c.JSON(http.StatusOK, txs)
+}
+
+// Blocks handler provides the list of blocks starting with the height given by the from query param.
+// Results are paginated with the from and limit query params.
+func (h Handlers) Blocks(c *gin.Context) {
+	from := uint64(1)
+	if value, ok := c.GetQuery("from"); ok {
+		parsedFrom, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || parsedFrom == 0 {
+			c.JSON(http.StatusBadRequest, web.Error(fmt.Errorf("invalid from: %s", value)))
+			return
+		}
+		from = parsedFrom
+	}
+
+	limit, err := limitQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.Error(err))
+		return
+	}
+
+	page := blockPage{Blocks: make([]block, 0)}
+
+	lastHeight := h.State.LastBlock().Height()
+	if from > lastHeight {
+		c.JSON(http.StatusOK, page)
+		return
+	}
+
+	to := from + uint64(limit) - 1
+	if to >= lastHeight {
+		to = lastHeight
+	} else {
+		page.NextFrom = to + 1
+	}
+
+	dbBlocks, err := h.State.QueryBlocksByHeight(from, to)
+	if errors.Is(err, database.ErrBlockPruned) {
+		c.JSON(http.StatusGone, web.Error(fmt.Errorf("failed to query blocks by height: %w", err)))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, web.Error(fmt.Errorf("failed to query blocks by height: %w", err)))
+		return
+	}
+
+	for _, dbBlock := range dbBlocks {
+		page.Blocks = append(page.Blocks, toBlock(h, dbBlock))
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// BlockByHash handler provides info about the block by given hash.
+func (h Handlers) BlockByHash(c *gin.Context) {
+	dbBlock, err := h.State.QueryBlockByHash(c.Param("hash"))
+	if errors.Is(err, database.ErrBlockPruned) {
+		c.JSON(http.StatusGone, web.Error(err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, web.Error(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, toBlock(h, dbBlock))
+}
+
+// Tx handler provides info about committed transaction by given hash.
+func (h Handlers) Tx(c *gin.Context) {
+	dbTx, dbReceipt, err := h.State.Tx(c.Param("hash"))
+	if errors.Is(err, database.ErrBlockPruned) {
+		c.JSON(http.StatusGone, web.Error(err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, web.Error(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, toCommittedTx(h, dbTx, dbReceipt))
+}
+
+// Receipt handler provides the receipt of committed transaction by given hash.
+func (h Handlers) Receipt(c *gin.Context) {
+	dbReceipt, err := h.State.Receipt(c.Param("hash"))
+	if errors.Is(err, database.ErrBlockPruned) {
+		c.JSON(http.StatusGone, web.Error(err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, web.Error(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, toReceipt(dbReceipt))
+}
+
+// TxStatus handler provides the lifecycle status of the transaction by given hash.
+func (h Handlers) TxStatus(c *gin.Context) {
+	status, err := h.State.TxStatus(c.Param("hash"))
+	if errors.Is(err, database.ErrTxNotFound) {
+		c.JSON(http.StatusNotFound, web.Error(err))
+		return
+	}
+	if errors.Is(err, database.ErrBlockPruned) {
+		c.JSON(http.StatusGone, web.Error(err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, web.Error(fmt.Errorf("failed to query tx status: %w", err)))
+		return
+	}
+
+	c.JSON(http.StatusOK, toTxStatus(status))
+}
+
+// heightQuery parses the optional height query param. It reports whether the param is present.
+func heightQuery(c *gin.Context) (uint64, bool, error) {
+	value, ok := c.GetQuery("height")
+	if !ok {
+		return 0, false, nil
+	}
+
+	height, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid height: %s", value)
+	}
+
+	return height, true, nil
+}
+
+// limitQuery parses the optional limit query param, ensuring it stays within the page limits.
+func limitQuery(c *gin.Context) (int, error) {
+	value, ok := c.GetQuery("limit")
+	if !ok {
+		return defaultPageLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("invalid limit: %s", value)
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+
+	return limit, nil
+}
@@ -30,9 +30,20 @@ func PublicHandlers(mux *gin.Engine, cfg Config) {
 	v1.GET("/genesis", h.Genesis)
 	v1.GET("/accounts", h.Accounts)
 	v1.GET("/accounts/:address", h.Account)
+	v1.GET("/accounts/:address/proof", h.AccountProof)
+	v1.GET("/accounts/:address/nonce", h.AccountNonce)
+	v1.GET("/accounts/:address/txs", h.AccountTxs)
+	v1.GET("/blocks", h.Blocks)
+	v1.GET("/blocks/:hash", h.BlockByHash)
 	v1.GET("/tx/uncommitted", h.UncommittedWalletTx)
+	v1.GET("/tx/tip", h.TipEstimate)
+	v1.GET("/tx/uncommitted/evicted", h.EvictedWalletTx)
 	v1.GET("/tx/uncommitted/:address", h.UncommittedWalletAccountTx)
+	v1.GET("/tx/:hash", h.Tx)
+	v1.GET("/tx/:hash/receipt", h.Receipt)
+	v1.GET("/tx/:hash/status", h.TxStatus)
 	v1.POST("/tx/submit", h.SubmitWalletTx)
+	v1.POST("/tx/simulate", h.SimulateWalletTx)
 }
 
 // PrivateHandlers registers all v1 private routes.
@@ -49,4 +60,5 @@ func PrivateHandlers(mux *gin.Engine, cfg Config) {
 	v1.POST("/node/block", h.SubmitBlock)
 	v1.POST("/node/peer", h.SubmitPeer)
 	v1.POST("/node/tx", h.SubmitTx)
+	v1.GET("/node/ws", h.PeerConn)
 }
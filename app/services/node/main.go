@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path"
 	"syscall"
 	"time"
 
@@ -17,7 +18,7 @@ import (
 	"github.com/tchorzewski1991/fitbit/core/blockchain/genesis"
 	"github.com/tchorzewski1991/fitbit/core/blockchain/network"
 	"github.com/tchorzewski1991/fitbit/core/blockchain/state"
-	"github.com/tchorzewski1991/fitbit/core/blockchain/storage/disk"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/storage"
 	"github.com/tchorzewski1991/fitbit/core/blockchain/worker"
 	"github.com/tchorzewski1991/fitbit/core/logger"
 	"github.com/tchorzewski1991/fitbit/core/nameservice"
@@ -63,10 +64,22 @@ func run(log *zap.SugaredLogger) error {
 			ShutdownTimeout time.Duration `conf:"default:5s"`
 		}
 		State struct {
-			AccountsPath string   `conf:"default:data/accounts"`
-			DataPath     string   `conf:"default:data/miner"`
-			Beneficiary  string   `conf:"default:miner"`
-			OriginPeers  []string `conf:"default:0.0.0.0:4000"`
+			AccountsPath        string        `conf:"default:data/accounts"`
+			DataPath            string        `conf:"default:data/miner"`
+			Storage             string        `conf:"default:disk"`
+			SegmentSize         int64         `conf:"default:67108864"`
+			Beneficiary         string        `conf:"default:miner"`
+			OriginPeers         []string      `conf:"default:0.0.0.0:4000"`
+			SnapshotInterval    uint64        `conf:"default:100"`
+			FullReplay          bool          `conf:"default:false"`
+			Repair              bool          `conf:"default:false"`
+			PruneDepth          uint64        `conf:"default:0"`
+			PriceBump           uint64        `conf:"default:10"`
+			MempoolCapacity     int           `conf:"default:4096"`
+			MempoolAccountLimit int           `conf:"default:64"`
+			MempoolTTL          time.Duration `conf:"default:3h"`
+			MempoolJournal      string        `conf:"help:defaults to the data path with the .mempool.journal suffix"`
+			PeerTransport       bool          `conf:"default:true"`
 		}
 	}{
 		Version: conf.Version{
@@ -124,9 +137,22 @@ func run(log *zap.SugaredLogger) error {
 		return fmt.Errorf("loading genesis file err: %w", err)
 	}
 
-	storage, err := disk.New(cfg.State.DataPath)
+	// Prepare storage engine selected by the configuration.
+	store, err := storage.New(storage.Config{
+		Engine:      cfg.State.Storage,
+		DataPath:    cfg.State.DataPath,
+		SegmentSize: cfg.State.SegmentSize,
+	})
 	if err != nil {
-		return fmt.Errorf("loading disk storage err: %w", err)
+		return fmt.Errorf("loading %s storage err: %w", cfg.State.Storage, err)
+	}
+
+	// Keep the mempool journal next to the data path by default, so nodes running
+	// with different data paths never share it. It is kept outside of the data path,
+	// as the storage removes the whole data path whenever it is reset.
+	mempoolJournal := cfg.State.MempoolJournal
+	if mempoolJournal == "" {
+		mempoolJournal = path.Clean(cfg.State.DataPath) + ".mempool.journal"
 	}
 
 	eventHandler := func(s string, args ...any) {
@@ -138,9 +164,22 @@ func run(log *zap.SugaredLogger) error {
 		BeneficiaryID: beneficiaryID,
 		Host:          cfg.Node.PrivateHost,
 		Genesis:       gen,
-		Storage:       storage,
+		Storage:       store,
 		EventHandler:  eventHandler,
 		KnownPeers:    knownPeers,
+
+		SnapshotInterval: cfg.State.SnapshotInterval,
+		FullReplay:       cfg.State.FullReplay,
+		Repair:           cfg.State.Repair,
+		PruneDepth:       cfg.State.PruneDepth,
+		PriceBump:        cfg.State.PriceBump,
+
+		MempoolCapacity:     cfg.State.MempoolCapacity,
+		MempoolAccountLimit: cfg.State.MempoolAccountLimit,
+		MempoolTTL:          cfg.State.MempoolTTL,
+		MempoolJournal:      mempoolJournal,
+
+		PeerTransport: cfg.State.PeerTransport,
 	})
 	if err != nil {
 		return fmt.Errorf("loading state err: %w", err)
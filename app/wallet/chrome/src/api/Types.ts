@@ -2,6 +2,8 @@ export type Genesis = {
     chain_id: number;
     tx_per_block: number;
     difficulty: number;
+    retarget_window: number;
+    block_time: number;
     mining_reward: number;
     gas_price: number;
     balances: {
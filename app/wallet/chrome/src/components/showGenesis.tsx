@@ -28,6 +28,8 @@ export default function ShowGenesis(){
                 <p>Chain ID:      {genesis.chain_id}</p>
                 <p>Tx per block:  {genesis.tx_per_block}</p>
                 <p>Difficulty:    {genesis.difficulty}</p>
+                <p>Retarget window: {genesis.retarget_window}</p>
+                <p>Block time:    {genesis.block_time}</p>
                 <p>Mining reward: {genesis.mining_reward}</p>
                 <p>Gas price:     {genesis.gas_price}</p>
                 <p>Balances: </p> {balances}
@@ -45,7 +45,7 @@ func init() {
 		&nonce,
 		"nonce", "n",
 		0,
-		"Transaction id to send.",
+		"Transaction id to send. The next nonce of the account is used when omitted.",
 	)
 	sendCmd.Flags().Uint64VarP(
 		&value,
@@ -57,7 +57,7 @@ func init() {
 		&tip,
 		"tip", "c",
 		0,
-		"Transaction tip to add.",
+		"Transaction tip to add. The tip estimate of the node is used when omitted.",
 	)
 	sendCmd.Flags().BytesHexVarP(
 		&data,
@@ -68,7 +68,7 @@ func init() {
 	rootCmd.AddCommand(sendCmd)
 }
 
-func sendRun(_ *cobra.Command, _ []string) {
+func sendRun(cmd *cobra.Command, _ []string) {
 	accountLocation, err := generateAccountLocation()
 	if err != nil {
 		fmt.Println(fmt.Errorf("generate account location err: %w", err))
@@ -93,6 +93,28 @@ func sendRun(_ *cobra.Command, _ []string) {
 		os.Exit(1)
 	}
 
+	// Ask the node for the nonce and the tip, unless they are set explicitly.
+	if !cmd.Flags().Changed("nonce") {
+		var resp struct {
+			Nonce uint64 `json:"nonce"`
+		}
+		if err = getJSON(fmt.Sprintf("%s/v1/accounts/%s/nonce", url, fromAddress), &resp); err != nil {
+			fmt.Println(fmt.Errorf("get next nonce err: %w", err))
+			os.Exit(1)
+		}
+		nonce = resp.Nonce
+	}
+	if !cmd.Flags().Changed("tip") {
+		var resp struct {
+			Tip uint64 `json:"tip"`
+		}
+		if err = getJSON(fmt.Sprintf("%s/v1/tx/tip", url), &resp); err != nil {
+			fmt.Println(fmt.Errorf("get tip estimate err: %w", err))
+			os.Exit(1)
+		}
+		tip = resp.Tip
+	}
+
 	const chainID = 1
 	tx := database.Tx{
 		ChainID: chainID,
@@ -141,3 +163,25 @@ func sendRun(_ *cobra.Command, _ []string) {
 	msg := fmt.Sprintf("request success | status: %d | body: %s", resp.StatusCode, string(body))
 	fmt.Println(msg)
 }
+
+// getJSON sends the GET request to the node and decodes the JSON response into value.
+func getJSON(url string, value any) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return fmt.Errorf("get request err: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response body err: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("request failed | status: %d | body: %s", resp.StatusCode, string(body))
+	}
+
+	return json.Unmarshal(body, value)
+}
@@ -0,0 +1,277 @@
+// Package archive exports a range of blocks together with the genesis into a single
+// gzip compressed tar archive and imports them back into the database.
+//
+// Archive entries are written in the following order:
+//   - manifest.json describing the content of the archive
+//   - genesis.json the blocks have been built on top of
+//   - blocks/<height>.blk for every block, encoded with the database binary codec
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/genesis"
+)
+
+// Version is the version of the archive layout produced by Export.
+const Version = 1
+
+const (
+	manifestEntry = "manifest.json"
+	genesisEntry  = "genesis.json"
+	blockEntry    = "blocks/%d.blk"
+)
+
+// maxEntrySize bounds the size declared by the tar header of the archive entry. Every
+// entry is read into memory as a whole, so the archive coming from any source cannot
+// make the import allocate more than that for a single block.
+const maxEntrySize = 1 << 28
+
+// Manifest describes the content of the archive.
+type Manifest struct {
+	Version   int       `json:"version"`
+	ChainID   uint16    `json:"chain_id"`
+	From      uint64    `json:"from"`
+	To        uint64    `json:"to"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Export streams the blocks with heights from the given range together with the genesis
+// into the archive written to w. Range has to be within the blocks kept by the storage.
+func Export(w io.Writer, gen genesis.Genesis, storage database.Storage, from, to uint64) (Manifest, error) {
+	head, err := storage.Head()
+	if err != nil {
+		return Manifest{}, fmt.Errorf("read head err: %w", err)
+	}
+	if from == 0 || from > to || to > head {
+		return Manifest{}, fmt.Errorf("invalid range from: %d to: %d, last height: %d", from, to, head)
+	}
+
+	manifest := Manifest{
+		Version:   Version,
+		ChainID:   gen.ChainID,
+		From:      from,
+		To:        to,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	if err = writeJSON(tw, manifestEntry, manifest); err != nil {
+		return Manifest{}, err
+	}
+	if err = writeJSON(tw, genesisEntry, gen); err != nil {
+		return Manifest{}, err
+	}
+
+	err = storage.Range(from, to, func(height uint64, data database.BlockData) error {
+		if data.Pruned {
+			return fmt.Errorf("block: %d: %w", height, database.ErrBlockPruned)
+		}
+		bs, err := data.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		return writeEntry(tw, fmt.Sprintf(blockEntry, height), bs, manifest.CreatedAt)
+	})
+	if err != nil {
+		return Manifest{}, fmt.Errorf("export blocks err: %w", err)
+	}
+
+	if err = tw.Close(); err != nil {
+		return Manifest{}, err
+	}
+	if err = gw.Close(); err != nil {
+		return Manifest{}, err
+	}
+
+	return manifest, nil
+}
+
+// Reader reads the archive written by Export. Manifest and genesis are available
+// right away, while blocks are streamed one by one.
+type Reader struct {
+	gr       *gzip.Reader
+	tr       *tar.Reader
+	manifest Manifest
+	genesis  genesis.Genesis
+	next     uint64
+}
+
+// NewReader reads the manifest and the genesis of the archive read from r.
+func NewReader(r io.Reader) (*Reader, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("open archive err: %w", err)
+	}
+
+	rd := Reader{
+		gr: gr,
+		tr: tar.NewReader(gr),
+	}
+
+	if err = rd.readJSON(manifestEntry, &rd.manifest); err != nil {
+		return nil, err
+	}
+	if rd.manifest.Version != Version {
+		return nil, fmt.Errorf("unsupported archive version: %d", rd.manifest.Version)
+	}
+	if err = rd.readJSON(genesisEntry, &rd.genesis); err != nil {
+		return nil, err
+	}
+	if rd.genesis.ChainID != rd.manifest.ChainID {
+		return nil, fmt.Errorf("genesis chain ID: %d does not match the manifest", rd.genesis.ChainID)
+	}
+
+	rd.next = rd.manifest.From
+
+	return &rd, nil
+}
+
+// Manifest returns the manifest of the archive.
+func (rd *Reader) Manifest() Manifest {
+	return rd.manifest
+}
+
+// Genesis returns the genesis of the archive.
+func (rd *Reader) Genesis() genesis.Genesis {
+	return rd.genesis
+}
+
+// Next returns the next block of the archive. It returns io.EOF when all the blocks
+// described by the manifest have been read.
+func (rd *Reader) Next() (database.BlockData, error) {
+	if rd.next > rd.manifest.To {
+		return database.BlockData{}, io.EOF
+	}
+
+	bs, err := rd.readEntry(fmt.Sprintf(blockEntry, rd.next))
+	if err != nil {
+		return database.BlockData{}, err
+	}
+
+	var data database.BlockData
+	if err = data.UnmarshalBinary(bs); err != nil {
+		return database.BlockData{}, fmt.Errorf("decode block: %d err: %w", rd.next, err)
+	}
+	if data.Header.Height != rd.next {
+		return database.BlockData{}, fmt.Errorf("block: %d has unexpected height: %d", rd.next, data.Header.Height)
+	}
+	rd.next++
+
+	return data, nil
+}
+
+// Close releases the resources of the archive reader.
+func (rd *Reader) Close() error {
+	return rd.gr.Close()
+}
+
+// Import re-validates every block of the archive through the database and applies
+// it on top of the chain. Blocks the chain already has are skipped as long as they
+// match the archive. It returns the number of applied blocks.
+func Import(rd *Reader, db *database.Database) (uint64, error) {
+	lastHeight := db.LastBlock().Height()
+	if rd.manifest.From > lastHeight+1 {
+		return 0, fmt.Errorf("archive starts at height: %d, chain last height: %d", rd.manifest.From, lastHeight)
+	}
+
+	var applied uint64
+	for {
+		data, err := rd.Next()
+		if errors.Is(err, io.EOF) {
+			return applied, nil
+		}
+		if err != nil {
+			return applied, err
+		}
+
+		block, err := data.ToBlock()
+		if err != nil {
+			return applied, fmt.Errorf("prepare block: %d err: %w", data.Header.Height, err)
+		}
+
+		// Ensure the block the chain already has is the same one.
+		if block.Height() <= lastHeight {
+			known, err := db.ReadBlock(block.Height())
+			if err != nil {
+				return applied, err
+			}
+			if known.Hash() != block.Hash() {
+				return applied, fmt.Errorf("block: %d does not match the chain", block.Height())
+			}
+			continue
+		}
+
+		if err = db.ApplyBlock(block); err != nil {
+			return applied, fmt.Errorf("apply block: %d err: %w", block.Height(), err)
+		}
+		applied++
+	}
+}
+
+// private API
+
+func (rd *Reader) readJSON(name string, value any) error {
+	bs, err := rd.readEntry(name)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(bs, value); err != nil {
+		return fmt.Errorf("decode %s err: %w", name, err)
+	}
+	return nil
+}
+
+// readEntry reads the next entry of the archive and ensures it is the expected one.
+func (rd *Reader) readEntry(name string) ([]byte, error) {
+	hdr, err := rd.tr.Next()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("archive entry: %s is missing", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read archive err: %w", err)
+	}
+	if hdr.Name != name {
+		return nil, fmt.Errorf("unexpected archive entry: %s, expected: %s", hdr.Name, name)
+	}
+	if hdr.Size > maxEntrySize {
+		return nil, fmt.Errorf("archive entry: %s is too large", name)
+	}
+
+	bs, err := io.ReadAll(io.LimitReader(rd.tr, hdr.Size))
+	if err != nil {
+		return nil, fmt.Errorf("read archive entry: %s err: %w", name, err)
+	}
+	return bs, nil
+}
+
+func writeJSON(tw *tar.Writer, name string, value any) error {
+	bs, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeEntry(tw, name, bs, time.Now().UTC())
+}
+
+func writeEntry(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(&hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
@@ -0,0 +1,170 @@
+package archive_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/archive"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/genesis"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/storage/memory"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/testdata"
+)
+
+func TestArchive_ExportAndImport(t *testing.T) {
+	db, storage := mockDatabase(t)
+	for i := 0; i < 3; i++ {
+		err := db.ApplyBlock(mockBlock(t, db))
+		assert.Nil(t, err)
+	}
+
+	// Export range which exceeds the chain and assert err
+	var buf bytes.Buffer
+	_, err := archive.Export(&buf, mockGenesis(), storage, 1, 4)
+	assert.EqualError(t, err, "invalid range from: 1 to: 4, last height: 3")
+
+	// Export the whole chain
+	buf.Reset()
+	manifest, err := archive.Export(&buf, mockGenesis(), storage, 1, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), manifest.From)
+	assert.Equal(t, uint64(3), manifest.To)
+
+	// Read the manifest and the genesis of the archive
+	rd, err := archive.NewReader(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, manifest.To, rd.Manifest().To)
+	assert.Equal(t, mockGenesis().Balances, rd.Genesis().Balances)
+
+	// Import the archive into the empty database
+	imported, _ := mockDatabase(t)
+	applied, err := archive.Import(rd, imported)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), applied)
+	assert.Equal(t, db.LastBlock().Hash(), imported.LastBlock().Hash())
+	assert.Equal(t, db.StateRoot(), imported.StateRoot())
+
+	// Import the archive once again, known blocks are skipped
+	rd, err = archive.NewReader(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	applied, err = archive.Import(rd, imported)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), applied)
+}
+
+func TestArchive_ImportRange(t *testing.T) {
+	db, storage := mockDatabase(t)
+	for i := 0; i < 3; i++ {
+		err := db.ApplyBlock(mockBlock(t, db))
+		assert.Nil(t, err)
+	}
+
+	var buf bytes.Buffer
+	_, err := archive.Export(&buf, mockGenesis(), storage, 2, 3)
+	assert.Nil(t, err)
+
+	// Import archive which does not follow the chain and assert err
+	imported, _ := mockDatabase(t)
+	rd, err := archive.NewReader(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	_, err = archive.Import(rd, imported)
+	assert.EqualError(t, err, "archive starts at height: 2, chain last height: 0")
+
+	// Import archive on top of a different chain and assert err
+	err = imported.ApplyBlock(mockBlock(t, imported))
+	assert.Nil(t, err)
+	err = imported.ApplyBlock(mockBlock(t, imported))
+	assert.Nil(t, err)
+	rd, err = archive.NewReader(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	_, err = archive.Import(rd, imported)
+	assert.EqualError(t, err, "block: 2 does not match the chain")
+}
+
+func TestArchive_Invalid(t *testing.T) {
+	// Read data which is not an archive and assert err
+	_, err := archive.NewReader(bytes.NewReader([]byte("not an archive")))
+	assert.NotNil(t, err)
+
+	// Read truncated archive and assert err
+	db, storage := mockDatabase(t)
+	err = db.ApplyBlock(mockBlock(t, db))
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	_, err = archive.Export(&buf, mockGenesis(), storage, 1, 1)
+	assert.Nil(t, err)
+
+	rd, err := archive.NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-30]))
+	if err == nil {
+		_, err = rd.Next()
+	}
+	assert.NotNil(t, err)
+}
+
+// Helper functions
+
+func mockDatabase(t *testing.T) (*database.Database, database.Storage) {
+	storage, err := memory.New()
+	assert.Nil(t, err)
+
+	db, err := database.New(database.Config{Genesis: mockGenesis(), Storage: storage})
+	assert.Nil(t, err)
+
+	return db, storage
+}
+
+func mockBlock(t *testing.T, db *database.Database) database.Block {
+	const beneficiaryID = database.AccountID("0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0")
+
+	target, err := db.NextTarget()
+	assert.Nil(t, err)
+
+	// Every block carries a single transaction with the following nonce
+	tx := database.Tx{
+		ChainID: 1,
+		Nonce:   db.LastBlock().Height() + 1,
+		From:    database.AccountID("0x0ee5ba68586c85880B0900D0dEe0eEcBB37010e0"),
+		To:      beneficiaryID,
+		Value:   10,
+		Tip:     1,
+	}
+	signedTx, err := tx.Sign(testdata.LoadPrivateKey(t))
+	assert.Nil(t, err)
+	blockTx := database.NewBlockTx(signedTx, 1, 1)
+
+	accounts := db.Accounts()
+	err = accounts.ApplyTransaction(beneficiaryID, blockTx)
+	assert.Nil(t, err)
+	accounts.ApplyMiningReward(beneficiaryID, mockGenesis().MiningReward)
+
+	block, err := database.POW(context.Background(), database.POWArgs{
+		BeneficiaryID: beneficiaryID,
+		Target:        target,
+		Reward:        mockGenesis().MiningReward,
+		PrevBlock:     db.LastBlock(),
+		StateRoot:     db.CalcStateRoot(accounts),
+		Txs:           []database.BlockTx{blockTx},
+		Ev:            func(s string, args ...any) {},
+	})
+	assert.Nil(t, err)
+	return block
+}
+
+func mockGenesis() genesis.Genesis {
+	return genesis.Genesis{
+		Date:         time.Time{},
+		ChainID:      1,
+		TxPerBlock:   10,
+		Difficulty:   1,
+		MiningReward: 1,
+		GasPrice:     1,
+		Balances: map[string]uint64{
+			"0x0ee5ba68586c85880B0900D0dEe0eEcBB37010e0": 100,
+			"0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0": 200,
+		},
+	}
+}
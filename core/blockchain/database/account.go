@@ -3,6 +3,7 @@ package database
 import (
 	"crypto/ecdsa"
 	"errors"
+	"fmt"
 
 	"github.com/ethereum/go-ethereum/crypto"
 )
@@ -19,6 +20,73 @@ type Account struct {
 	Balance uint64
 }
 
+// Copy returns a copy of the accounts.
+func (accounts Accounts) Copy() Accounts {
+	cp := make(Accounts, len(accounts))
+	for id, account := range accounts {
+		cp[id] = account
+	}
+	return cp
+}
+
+// ApplyTransaction moves the value of given transaction between two parties and pays
+// the tip and the gas fee to the beneficiary. Accounts are left untouched when the
+// transaction has a wrong nonce or the sender cannot cover all the costs.
+func (accounts Accounts) ApplyTransaction(beneficiaryID AccountID, tx BlockTx) error {
+	from := accounts.account(tx.From)
+
+	// Calculate the total cost of the transaction, ensuring it does not overflow.
+	fee, err := tx.Fee()
+	if err != nil {
+		return err
+	}
+	cost, err := tx.Cost()
+	if err != nil {
+		return err
+	}
+
+	// Perform necessary accounting checks.
+	if tx.Nonce != (from.Nonce + 1) {
+		return fmt.Errorf("tx invalid, wrong nonce, got: %d, expected: %d", tx.Nonce, from.Nonce+1)
+	}
+	if from.Balance < cost {
+		return fmt.Errorf("tx invalid, insufficient funds, got: %d, expected: %d", from.Balance, cost)
+	}
+
+	// Charge the sender and update its nonce for the next transaction check.
+	from.Balance -= cost
+	from.Nonce = tx.Nonce
+	accounts[tx.From] = from
+
+	// Update balance of the receiver.
+	to := accounts.account(tx.To)
+	to.Balance += tx.Value
+	accounts[tx.To] = to
+
+	// Update beneficiary account with the tip and the gas fee.
+	beneficiary := accounts.account(beneficiaryID)
+	beneficiary.Balance += fee
+	accounts[beneficiaryID] = beneficiary
+
+	return nil
+}
+
+// ApplyMiningReward updates beneficiary account balance with the mining reward.
+func (accounts Accounts) ApplyMiningReward(beneficiaryID AccountID, reward uint64) {
+	beneficiary := accounts.account(beneficiaryID)
+	beneficiary.Balance += reward
+	accounts[beneficiaryID] = beneficiary
+}
+
+// account returns the account by given AccountID or an empty account if it does not exist yet.
+func (accounts Accounts) account(accountID AccountID) Account {
+	account, ok := accounts[accountID]
+	if !ok {
+		account = Account{ID: accountID}
+	}
+	return account
+}
+
 // ToAccountID constructs a new AccountID.
 // This function takes hex encoded string and verifies whether its
 // underlying value conforms to the AccountID format requirements.
@@ -84,18 +152,3 @@ func hasProperChars(hexID string) bool {
 func isHexChar(b byte) bool {
 	return ('0' <= b && b <= '9') || ('a' <= b && b <= 'f') || ('A' <= b && b <= 'F')
 }
-
-// byAccountID allows for sorting a collection of Account(s) by their IDs.
-type byAccountID []Account
-
-func (s byAccountID) Len() int {
-	return len(s)
-}
-
-func (s byAccountID) Swap(i, j int) {
-	s[i], s[j] = s[j], s[i]
-}
-
-func (s byAccountID) Less(i, j int) bool {
-	return s[i].ID < s[j].ID
-}
@@ -47,3 +47,30 @@ func TestAccountID_Verify(t *testing.T) {
 	err = accountID.Verify()
 	assert.EqualError(t, err, "invalid account ID format: 0x prefix not found")
 }
+
+func TestAccounts_ApplyTransaction(t *testing.T) {
+	from := database.AccountID("0x0ee5ba68586c85880B0900D0dEe0eEcBB37010e0")
+	to := database.AccountID("0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0")
+
+	accounts := database.Accounts{
+		from: database.Account{ID: from, Balance: 100},
+	}
+
+	tx := database.BlockTx{
+		SignedTx: database.SignedTx{Tx: database.Tx{Nonce: 1, From: from, To: to, Value: 50, Tip: 5}},
+		GasPrice: 2,
+		GasUnits: 1,
+	}
+
+	// Sender is the beneficiary, so it gets back the tip and the gas fee
+	err := accounts.ApplyTransaction(from, tx)
+	assert.Nil(t, err)
+	assert.Equal(t, database.Account{ID: from, Nonce: 1, Balance: 50}, accounts[from])
+	assert.Equal(t, database.Account{ID: to, Nonce: 0, Balance: 50}, accounts[to])
+
+	// Replaying the same transaction fails and leaves accounts untouched
+	err = accounts.ApplyTransaction(to, tx)
+	assert.EqualError(t, err, "tx invalid, wrong nonce, got: 1, expected: 2")
+	assert.Equal(t, database.Account{ID: from, Nonce: 1, Balance: 50}, accounts[from])
+	assert.Equal(t, database.Account{ID: to, Nonce: 0, Balance: 50}, accounts[to])
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Set of directions describing how the committed transaction changed the account balance.
+const (
+	DirectionSent     = "sent"
+	DirectionReceived = "received"
+	DirectionFee      = "fee"
+	DirectionReward   = "reward"
+)
+
+// AccountTx represents the change of the account balance made by the committed
+// transaction or by the mining reward, in which case the Index is -1 and the
+// TxHash is empty. The Amount of the fee is the gas fee and the tip earned as
+// the beneficiary of the block.
+type AccountTx struct {
+	BlockHeight  uint64
+	Index        int
+	TxHash       string
+	Direction    string
+	Counterparty AccountID
+	Amount       uint64
+
+	// seq numbers the entries of the same account within a single block.
+	seq int
+}
+
+// Cursor returns the position of the entry in the history of the account.
+func (tx AccountTx) Cursor() string {
+	return fmt.Sprintf("%d-%d", tx.BlockHeight, tx.seq)
+}
+
+// AccountTxQuery represents a set of arguments necessary to query the history of the account.
+type AccountTxQuery struct {
+	// Cursor returned with the previous page, empty cursor starts with the newest entry.
+	Cursor string
+	// Limit defines the maximum number of entries returned.
+	Limit int
+	// Direction filters entries by their direction, empty direction matches all of them.
+	Direction string
+}
+
+// AccountTxs returns the history of the account starting with the newest entries.
+// The cursor of the next page is returned as well, it is empty for the last page.
+func (db *Database) AccountTxs(accountID AccountID, query AccountTxQuery) ([]AccountTx, string, error) {
+	if query.Limit <= 0 {
+		return nil, "", errors.New("limit must be positive")
+	}
+	switch query.Direction {
+	case "", DirectionSent, DirectionReceived, DirectionFee, DirectionReward:
+	default:
+		return nil, "", fmt.Errorf("unknown direction: %s", query.Direction)
+	}
+
+	var height uint64
+	var seq int
+	if query.Cursor != "" {
+		if _, err := fmt.Sscanf(query.Cursor, "%d-%d", &height, &seq); err != nil {
+			return nil, "", fmt.Errorf("invalid cursor: %s", query.Cursor)
+		}
+	}
+
+	if err := db.indexBlocks(); err != nil {
+		return nil, "", err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	entries := db.index.accounts[accountID]
+	txs := make([]AccountTx, 0, query.Limit)
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+
+		// Skip the entries which are not older than the cursor.
+		if query.Cursor != "" && (entry.BlockHeight > height || (entry.BlockHeight == height && entry.seq >= seq)) {
+			continue
+		}
+		if query.Direction != "" && entry.Direction != query.Direction {
+			continue
+		}
+
+		// One more matching entry means there is the next page.
+		if len(txs) == query.Limit {
+			return txs, txs[len(txs)-1].Cursor(), nil
+		}
+		txs = append(txs, entry)
+	}
+
+	// Older entries of the account might have been in the pruned blocks. The page
+	// collected so far is returned first, so the next one reports them as pruned.
+	if db.index.pruned > 0 {
+		if len(txs) == 0 {
+			return nil, "", fmt.Errorf("account: %s history up to block: %d: %w", accountID, db.index.pruned, ErrBlockPruned)
+		}
+		return txs, txs[len(txs)-1].Cursor(), nil
+	}
+
+	return txs, "", nil
+}
@@ -0,0 +1,70 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+)
+
+func TestDatabase_AccountTxs(t *testing.T) {
+	const (
+		senderID      = database.AccountID("0x0ee5ba68586c85880B0900D0dEe0eEcBB37010e0")
+		beneficiaryID = database.AccountID("0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0")
+	)
+
+	storage := mockStorage(t)
+
+	db, err := database.New(database.Config{Genesis: mockGenesis(), Storage: storage, SnapshotInterval: 2})
+	assert.Nil(t, err)
+
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		err = db.ApplyBlock(mockBlock(t, db, mockBlockTx(t, nonce)))
+		assert.Nil(t, err)
+	}
+
+	// Ensure sender history starts with the newest transaction
+	txs, next, err := db.AccountTxs(senderID, database.AccountTxQuery{Limit: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(txs))
+	assert.Equal(t, uint64(3), txs[0].BlockHeight)
+	assert.Equal(t, database.DirectionSent, txs[0].Direction)
+	assert.Equal(t, uint64(10), txs[0].Amount)
+	assert.NotEmpty(t, next)
+
+	// Ensure the cursor continues with the older transactions
+	txs, next, err = db.AccountTxs(senderID, database.AccountTxQuery{Limit: 2, Cursor: next})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(txs))
+	assert.Equal(t, uint64(1), txs[0].BlockHeight)
+	assert.Empty(t, next)
+
+	// Ensure beneficiary history covers received value, fees and rewards of every block
+	txs, _, err = db.AccountTxs(beneficiaryID, database.AccountTxQuery{Limit: 100})
+	assert.Nil(t, err)
+	assert.Equal(t, 9, len(txs))
+	assert.Equal(t, database.DirectionReward, txs[0].Direction)
+	assert.Equal(t, database.DirectionFee, txs[1].Direction)
+	assert.Equal(t, uint64(2), txs[1].Amount)
+	assert.Equal(t, database.DirectionReceived, txs[2].Direction)
+
+	// Ensure direction filters the history
+	txs, _, err = db.AccountTxs(beneficiaryID, database.AccountTxQuery{Limit: 100, Direction: database.DirectionReward})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(txs))
+
+	// Ensure invalid queries are rejected
+	_, _, err = db.AccountTxs(senderID, database.AccountTxQuery{Limit: 1, Direction: "unknown"})
+	assert.EqualError(t, err, "unknown direction: unknown")
+	_, _, err = db.AccountTxs(senderID, database.AccountTxQuery{Limit: 1, Cursor: "abc"})
+	assert.EqualError(t, err, "invalid cursor: abc")
+
+	// Ensure database restored from the snapshot keeps the history of all blocks
+	restored, err := database.New(database.Config{Genesis: mockGenesis(), Storage: storage})
+	assert.Nil(t, err)
+
+	restoredTxs, _, err := restored.AccountTxs(beneficiaryID, database.AccountTxQuery{Limit: 100})
+	assert.Nil(t, err)
+	assert.Equal(t, txs[0].Cursor(), restoredTxs[0].Cursor())
+	assert.Equal(t, 9, len(restoredTxs))
+}
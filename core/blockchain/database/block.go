@@ -3,16 +3,19 @@ package database
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"math"
 	"math/big"
-	"strings"
 	"time"
 
 	"github.com/ardanlabs/blockchain/foundation/blockchain/merkle"
 	"github.com/tchorzewski1991/fitbit/core/blockchain/signature"
 )
 
+// maxFutureBlockTime defines how far in the future the block timestamp can be.
+const maxFutureBlockTime = 2 * time.Hour
+
 // Block orchestrates a batch of transactions together.
 type Block struct {
 	Header BlockHeader
@@ -25,7 +28,7 @@ type BlockHeader struct {
 	PrevHash      string    `json:"prev_hash"`
 	Timestamp     uint64    `json:"timestamp"`
 	BeneficiaryID AccountID `json:"beneficiary"`
-	Difficulty    uint16    `json:"difficulty"`
+	Target        *big.Int  `json:"target"`
 	Reward        uint64    `json:"reward"`
 	StateRoot     string    `json:"state_root"`
 	TxRoot        string    `json:"tx_root"`
@@ -33,16 +36,19 @@ type BlockHeader struct {
 }
 
 // BlockData represents what can be serialized to disk or over the network.
+// Pruned block keeps only its header, as transactions have been dropped.
 type BlockData struct {
 	Hash   string      `json:"hash"`
 	Header BlockHeader `json:"header"`
 	Txs    []BlockTx   `json:"txs"`
+	Pruned bool        `json:"pruned,omitempty"`
 }
 
 // POWArgs represents a set of arguments necessary to run Proof of Work.
+// The state root is expected to be the accounts state after applying the transactions.
 type POWArgs struct {
 	BeneficiaryID AccountID
-	Difficulty    uint16
+	Target        *big.Int
 	Reward        uint64
 	PrevBlock     Block
 	StateRoot     string
@@ -78,7 +84,7 @@ func POW(ctx context.Context, args POWArgs) (Block, error) {
 			PrevHash:      prevBlockHash,
 			Timestamp:     uint64(time.Now().UTC().Unix()),
 			BeneficiaryID: args.BeneficiaryID,
-			Difficulty:    args.Difficulty,
+			Target:        args.Target,
 			Reward:        args.Reward,
 			StateRoot:     args.StateRoot,
 			TxRoot:        tree.RootHex(),
@@ -118,28 +124,89 @@ func (b Block) ToBlockData() BlockData {
 	}
 }
 
+// Work returns the expected number of hashes needed to solve the Block,
+// which is 2^256 / (target + 1).
+func (b Block) Work() *big.Int {
+	if b.Header.Target == nil {
+		return new(big.Int)
+	}
+	denominator := new(big.Int).Add(b.Header.Target, big.NewInt(1))
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
+
+// ValidateArgs represents a set of arguments necessary to validate a Block.
+// The state root is expected to be the result of executing the Block on top of
+// the accounts state of the previous block.
+type ValidateArgs struct {
+	PrevBlock  Block
+	StateRoot  string
+	Target     *big.Int
+	TxPerBlock uint16
+}
+
 // Validate verifies through the core set of check whether given Block is valid.
-func (b Block) Validate(prevBlock Block, prevStateRoot string) error {
+// The target is expected to be the one calculated for the successor of the previous block.
+func (b Block) Validate(args ValidateArgs) error {
+	if err := b.ValidateHeader(args.PrevBlock); err != nil {
+		return err
+	}
+
+	// Verify if block target is the one expected at this height.
+	if b.Header.Target.Cmp(args.Target) != 0 {
+		return fmt.Errorf("target check failed: target: %x | expected target: %x", b.Header.Target, args.Target)
+	}
+
+	// Verify if block does not contain more transactions than allowed.
+	txCount := len(b.Tree.Values())
+	if txCount > int(args.TxPerBlock) {
+		return fmt.Errorf("tx count check failed: tx count: %d | tx per block: %d", txCount, args.TxPerBlock)
+	}
+
+	// Verify if block state root commits to the result of the block execution.
+	stateRoot := b.Header.StateRoot
+	if stateRoot != args.StateRoot {
+		return fmt.Errorf("state root check failed: state root: %s | post state root: %s", stateRoot, args.StateRoot)
+	}
 
-	// Verify if we do not have a fork.
+	return nil
+}
+
+// ValidateHeader verifies whether given Block is properly linked to its parent block.
+// Unlike Validate it does not need the accounts state, so it can be used for blocks
+// which do not extend the tip of the main chain.
+func (b Block) ValidateHeader(prevBlock Block) error {
+
+	// Verify if block is the direct successor of its parent.
 	height := b.Header.Height
 	prevHeight := prevBlock.Header.Height
-	if (height - prevHeight) != 1 {
-		return fmt.Errorf("fork check failed: height: %d | prev height: %d", height, prevHeight)
+	if height != prevHeight+1 {
+		return fmt.Errorf("height check failed: height: %d | prev height: %d", height, prevHeight)
 	}
 
-	// Verify if difficulty is the same or greater than parent block difficulty.
-	difficulty := b.Header.Difficulty
-	prevDifficulty := prevBlock.Header.Difficulty
-	if difficulty < prevDifficulty {
-		return fmt.Errorf("difficulty check failed: difficulty: %d | prev difficulty: %d", difficulty, prevDifficulty)
+	// Verify if block is not older than its parent and not from the future.
+	timestamp := b.Header.Timestamp
+	prevTimestamp := prevBlock.Header.Timestamp
+	if timestamp < prevTimestamp || timestamp > uint64(time.Now().Add(maxFutureBlockTime).UTC().Unix()) {
+		return fmt.Errorf("timestamp check failed: timestamp: %d | prev timestamp: %d", timestamp, prevTimestamp)
+	}
+
+	// Verify if target does not change more than a single retarget allows.
+	target := b.Header.Target
+	if target == nil || target.Sign() <= 0 {
+		return errors.New("target check failed: target is not set")
+	}
+	if prevTarget := prevBlock.Header.Target; prevTarget != nil {
+		lowest := new(big.Int).Div(prevTarget, big.NewInt(maxAdjustment))
+		highest := new(big.Int).Mul(prevTarget, big.NewInt(maxAdjustment))
+		if target.Cmp(lowest) < 0 || target.Cmp(highest) > 0 {
+			return fmt.Errorf("target check failed: target: %x | prev target: %x", target, prevTarget)
+		}
 	}
 
 	// Verify if hash has been actually solved.
 	hash := b.Hash()
-	refHash := buildReferenceHash(difficulty)
-	if !isSolved(difficulty, refHash, hash) {
-		return fmt.Errorf("hash solved check failed: hash: %s | ref hash: %s", hash, refHash)
+	if !isSolved(hash, target) {
+		return fmt.Errorf("hash solved check failed: hash: %s | target: %x", hash, target)
 	}
 
 	// Verify if previous block hash is the same as previous block hash of the validated block.
@@ -149,12 +216,6 @@ func (b Block) Validate(prevBlock Block, prevStateRoot string) error {
 		return fmt.Errorf("prev hash check failed: prev hash: %s | prev block hash: %s", prevHash, prevBlockHash)
 	}
 
-	// Verify if previous block state root is the same as state root of the validated block.
-	stateRoot := b.Header.StateRoot
-	if stateRoot != prevStateRoot {
-		return fmt.Errorf("state root check failed: state root: %s | prev state root: %s", stateRoot, prevStateRoot)
-	}
-
 	// Verify if merkle root does not match transactions.
 	txRoot := b.Header.TxRoot
 	treeRoot := b.Tree.RootHex()
@@ -167,6 +228,10 @@ func (b Block) Validate(prevBlock Block, prevStateRoot string) error {
 
 // ToBlock constructs a new Block out of BlockData.
 func (bd BlockData) ToBlock() (Block, error) {
+	// Pruned block cannot be restored without its transactions.
+	if bd.Pruned {
+		return Block{}, fmt.Errorf("block: %d: %w", bd.Header.Height, ErrBlockPruned)
+	}
 	// Generate new merkle tree out of block data transactions.
 	tree, err := merkle.NewTree(bd.Txs)
 	if err != nil {
@@ -188,8 +253,7 @@ func performPOW(ctx context.Context, block *Block, ev func(s string, args ...any
 	}
 	block.Header.Nonce = nonce.Uint64()
 
-	difficulty := block.Header.Difficulty
-	refHash := buildReferenceHash(difficulty)
+	target := block.Header.Target
 
 	var attempts int
 
@@ -210,7 +274,7 @@ func performPOW(ctx context.Context, block *Block, ev func(s string, args ...any
 		hash := block.Hash()
 
 		// Verify whether we solve the cryptographic puzzle.
-		if !isSolved(difficulty, refHash, hash) {
+		if !isSolved(hash, target) {
 			block.Header.Nonce++
 			continue
 		}
@@ -229,18 +293,29 @@ func performPOW(ctx context.Context, block *Block, ev func(s string, args ...any
 	}
 }
 
-func buildReferenceHash(difficulty uint16) string {
-	b := strings.Builder{}
-	b.WriteString("0x")
+// TxError describes the transaction which makes the block invalid.
+type TxError struct {
+	Height  uint64
+	Hash    string
+	TxIndex int
+	Err     error
+}
 
-	for difficulty > 0 {
-		b.WriteString("0")
-		difficulty--
+func newTxError(block Block, txIndex int, err error) *TxError {
+	return &TxError{
+		Height:  block.Height(),
+		Hash:    block.Hash(),
+		TxIndex: txIndex,
+		Err:     err,
 	}
+}
 
-	return b.String()
+// Error implements the error interface.
+func (e *TxError) Error() string {
+	return fmt.Sprintf("block: %d hash: %s tx: %d is invalid: %s", e.Height, e.Hash, e.TxIndex, e.Err)
 }
 
-func isSolved(difficulty uint16, refHash, hash string) bool {
-	return refHash[2:2+difficulty] == hash[2:2+difficulty]
+// Unwrap returns the reason why the transaction is invalid.
+func (e *TxError) Unwrap() error {
+	return e.Err
 }
@@ -0,0 +1,312 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// CodecVersion is the version of the binary encoding produced by MarshalBinary.
+// Every encoded value starts with the version byte, so the encoding can evolve
+// without breaking the data which has been stored or sent already.
+const CodecVersion byte = 1
+
+// BinaryMediaType is the media type of the binary encoding used by peers.
+const BinaryMediaType = "application/x-fitbit-rlp"
+
+// ErrUnsupportedCodec is returned when the data is encoded with the codec version
+// which is not known to this node.
+var ErrUnsupportedCodec = errors.New("unsupported codec version")
+
+// Hashes and signatures are calculated over the JSON representation of blocks and
+// transactions. The binary encoding keeps every value exactly, including the difference
+// between nil and empty values, so decoded data hashes to the same value as the original.
+
+// wireTx represents the binary encoding of the SignedTx.
+type wireTx struct {
+	ChainID uint16
+	Nonce   uint64
+	From    AccountID
+	To      AccountID
+	Value   uint64
+	Tip     uint64
+	Data    [][]byte
+	R       []*big.Int
+	S       []*big.Int
+	V       []*big.Int
+}
+
+// wireBlockTx represents the binary encoding of the BlockTx.
+type wireBlockTx struct {
+	Tx        wireTx
+	Timestamp uint64
+	GasPrice  uint64
+	GasUnits  uint64
+}
+
+// wireHeader represents the binary encoding of the BlockHeader.
+type wireHeader struct {
+	Height        uint64
+	PrevHash      string
+	Timestamp     uint64
+	BeneficiaryID AccountID
+	Target        []*big.Int
+	Reward        uint64
+	StateRoot     string
+	TxRoot        string
+	Nonce         uint64
+}
+
+// wireBlock represents the binary encoding of the BlockData.
+// Fields added after the first version are optional, so the data encoded
+// before they have been introduced can be still decoded.
+type wireBlock struct {
+	Hash   string
+	Header wireHeader
+	Txs    []wireBlockTx
+	Pruned bool `rlp:"optional"`
+}
+
+// MarshalBinary encodes the SignedTx with the versioned binary encoding.
+func (tx SignedTx) MarshalBinary() ([]byte, error) {
+	return encodeBinary(toWireTx(tx))
+}
+
+// UnmarshalBinary decodes the SignedTx encoded with MarshalBinary.
+func (tx *SignedTx) UnmarshalBinary(data []byte) error {
+	var w wireTx
+	if err := decodeBinary(data, &w); err != nil {
+		return err
+	}
+	*tx = fromWireTx(w)
+	return nil
+}
+
+// MarshalBinary encodes the BlockTx with the versioned binary encoding.
+func (tx BlockTx) MarshalBinary() ([]byte, error) {
+	return encodeBinary(toWireBlockTx(tx))
+}
+
+// UnmarshalBinary decodes the BlockTx encoded with MarshalBinary.
+func (tx *BlockTx) UnmarshalBinary(data []byte) error {
+	var w wireBlockTx
+	if err := decodeBinary(data, &w); err != nil {
+		return err
+	}
+	*tx = fromWireBlockTx(w)
+	return nil
+}
+
+// MarshalBinary encodes the BlockData with the versioned binary encoding.
+func (bd BlockData) MarshalBinary() ([]byte, error) {
+	w := wireBlock{
+		Hash: bd.Hash,
+		Header: wireHeader{
+			Height:        bd.Header.Height,
+			PrevHash:      bd.Header.PrevHash,
+			Timestamp:     bd.Header.Timestamp,
+			BeneficiaryID: bd.Header.BeneficiaryID,
+			Target:        toWireBig(bd.Header.Target),
+			Reward:        bd.Header.Reward,
+			StateRoot:     bd.Header.StateRoot,
+			TxRoot:        bd.Header.TxRoot,
+			Nonce:         bd.Header.Nonce,
+		},
+		Txs:    make([]wireBlockTx, len(bd.Txs)),
+		Pruned: bd.Pruned,
+	}
+	for i, tx := range bd.Txs {
+		w.Txs[i] = toWireBlockTx(tx)
+	}
+	return encodeBinary(w)
+}
+
+// UnmarshalBinary decodes the BlockData encoded with MarshalBinary.
+func (bd *BlockData) UnmarshalBinary(data []byte) error {
+	var w wireBlock
+	if err := decodeBinary(data, &w); err != nil {
+		return err
+	}
+
+	*bd = BlockData{
+		Hash: w.Hash,
+		Header: BlockHeader{
+			Height:        w.Header.Height,
+			PrevHash:      w.Header.PrevHash,
+			Timestamp:     w.Header.Timestamp,
+			BeneficiaryID: w.Header.BeneficiaryID,
+			Target:        fromWireBig(w.Header.Target),
+			Reward:        w.Header.Reward,
+			StateRoot:     w.Header.StateRoot,
+			TxRoot:        w.Header.TxRoot,
+			Nonce:         w.Header.Nonce,
+		},
+		Txs:    make([]BlockTx, len(w.Txs)),
+		Pruned: w.Pruned,
+	}
+	for i, tx := range w.Txs {
+		bd.Txs[i] = fromWireBlockTx(tx)
+	}
+
+	// Pruned block carries no txs at all.
+	if bd.Pruned {
+		bd.Txs = nil
+	}
+	return nil
+}
+
+// MarshalBlocksBinary encodes the list of BlockData with the versioned binary encoding.
+func MarshalBlocksBinary(blocks []BlockData) ([]byte, error) {
+	return marshalList(blocks)
+}
+
+// UnmarshalBlocksBinary decodes the list of BlockData encoded with MarshalBlocksBinary.
+func UnmarshalBlocksBinary(data []byte) ([]BlockData, error) {
+	return unmarshalList[BlockData](data)
+}
+
+// MarshalTxsBinary encodes the list of BlockTx with the versioned binary encoding.
+func MarshalTxsBinary(txs []BlockTx) ([]byte, error) {
+	return marshalList(txs)
+}
+
+// UnmarshalTxsBinary decodes the list of BlockTx encoded with MarshalTxsBinary.
+func UnmarshalTxsBinary(data []byte) ([]BlockTx, error) {
+	return unmarshalList[BlockTx](data)
+}
+
+// binaryMarshaler is implemented by the values with the binary encoding.
+type binaryMarshaler interface {
+	MarshalBinary() ([]byte, error)
+}
+
+// binaryUnmarshaler is implemented by the pointers to the values with the binary encoding.
+type binaryUnmarshaler[T any] interface {
+	*T
+	UnmarshalBinary(data []byte) error
+}
+
+// marshalList encodes every value separately, so each of them carries its own version.
+func marshalList[T binaryMarshaler](values []T) ([]byte, error) {
+	items := make([][]byte, len(values))
+	for i, value := range values {
+		bs, err := value.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		items[i] = bs
+	}
+	return encodeBinary(items)
+}
+
+func unmarshalList[T any, PT binaryUnmarshaler[T]](data []byte) ([]T, error) {
+	var items [][]byte
+	if err := decodeBinary(data, &items); err != nil {
+		return nil, err
+	}
+
+	values := make([]T, len(items))
+	for i, item := range items {
+		if err := PT(&values[i]).UnmarshalBinary(item); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// encodeBinary prefixes the RLP encoding of the value with the codec version.
+func encodeBinary(value any) ([]byte, error) {
+	bs, err := rlp.EncodeToBytes(value)
+	if err != nil {
+		return nil, fmt.Errorf("binary encode err: %w", err)
+	}
+	return append([]byte{CodecVersion}, bs...), nil
+}
+
+// decodeBinary verifies the codec version and decodes the RLP encoded value.
+func decodeBinary(data []byte, value any) error {
+	if len(data) == 0 {
+		return errors.New("binary decode err: data is empty")
+	}
+	if data[0] != CodecVersion {
+		return fmt.Errorf("binary decode err: %w: %d", ErrUnsupportedCodec, data[0])
+	}
+	if err := rlp.DecodeBytes(data[1:], value); err != nil {
+		return fmt.Errorf("binary decode err: %w", err)
+	}
+	return nil
+}
+
+func toWireTx(tx SignedTx) wireTx {
+	w := wireTx{
+		ChainID: tx.ChainID,
+		Nonce:   tx.Nonce,
+		From:    tx.From,
+		To:      tx.To,
+		Value:   tx.Value,
+		Tip:     tx.Tip,
+		R:       toWireBig(tx.R),
+		S:       toWireBig(tx.S),
+		V:       toWireBig(tx.V),
+	}
+	if tx.Data != nil {
+		w.Data = [][]byte{tx.Data}
+	}
+	return w
+}
+
+func fromWireTx(w wireTx) SignedTx {
+	tx := SignedTx{
+		Tx: Tx{
+			ChainID: w.ChainID,
+			Nonce:   w.Nonce,
+			From:    w.From,
+			To:      w.To,
+			Value:   w.Value,
+			Tip:     w.Tip,
+		},
+		R: fromWireBig(w.R),
+		S: fromWireBig(w.S),
+		V: fromWireBig(w.V),
+	}
+	if len(w.Data) > 0 {
+		tx.Data = append([]byte{}, w.Data[0]...)
+	}
+	return tx
+}
+
+func toWireBlockTx(tx BlockTx) wireBlockTx {
+	return wireBlockTx{
+		Tx:        toWireTx(tx.SignedTx),
+		Timestamp: tx.Timestamp,
+		GasPrice:  tx.GasPrice,
+		GasUnits:  tx.GasUnits,
+	}
+}
+
+func fromWireBlockTx(w wireBlockTx) BlockTx {
+	return BlockTx{
+		SignedTx:  fromWireTx(w.Tx),
+		Timestamp: w.Timestamp,
+		GasPrice:  w.GasPrice,
+		GasUnits:  w.GasUnits,
+	}
+}
+
+// toWireBig keeps the nil value distinguishable from zero by encoding
+// the optional value as a list of zero or one element.
+func toWireBig(v *big.Int) []*big.Int {
+	if v == nil {
+		return nil
+	}
+	return []*big.Int{v}
+}
+
+func fromWireBig(w []*big.Int) *big.Int {
+	if len(w) == 0 {
+		return nil
+	}
+	return w[0]
+}
@@ -0,0 +1,129 @@
+package database_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/signature"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/testdata"
+)
+
+func TestCodec_BlockTx(t *testing.T) {
+	priv := testdata.LoadPrivateKey(t)
+	params := defaultTxParams(t)
+
+	for _, data := range [][]byte{nil, {}, []byte("data")} {
+		tx := buildTx(&params)
+		tx.Data = data
+		signedTx, err := tx.Sign(priv)
+		assert.Nil(t, err)
+		blockTx := database.NewBlockTx(signedTx, 1, 2)
+
+		// Encode and decode the tx
+		bs, err := blockTx.MarshalBinary()
+		assert.Nil(t, err)
+		var decoded database.BlockTx
+		err = decoded.UnmarshalBinary(bs)
+		assert.Nil(t, err)
+
+		// Ensure the decoded tx is the same, including its hash and signature
+		assert.Equal(t, blockTx, decoded)
+		assert.Equal(t, blockTx.HexHash(), decoded.HexHash())
+		assert.Nil(t, decoded.Verify(defaultChainID))
+	}
+}
+
+func TestCodec_SignedTx(t *testing.T) {
+	priv := testdata.LoadPrivateKey(t)
+	params := defaultTxParams(t)
+
+	signedTx, err := buildTx(&params).Sign(priv)
+	assert.Nil(t, err)
+
+	bs, err := signedTx.MarshalBinary()
+	assert.Nil(t, err)
+	var decoded database.SignedTx
+	err = decoded.UnmarshalBinary(bs)
+	assert.Nil(t, err)
+	assert.Equal(t, signedTx, decoded)
+	assert.Equal(t, signature.Hash(signedTx), signature.Hash(decoded))
+}
+
+func TestCodec_BlockData(t *testing.T) {
+	priv := testdata.LoadPrivateKey(t)
+	params := defaultTxParams(t)
+
+	signedTx, err := buildTx(&params).Sign(priv)
+	assert.Nil(t, err)
+
+	blockData := database.BlockData{
+		Hash: "0xabc",
+		Header: database.BlockHeader{
+			Height:        1,
+			PrevHash:      signature.ZeroHash,
+			Timestamp:     100,
+			BeneficiaryID: params.from,
+			Target:        big.NewInt(1000),
+			Reward:        10,
+			StateRoot:     "0x01",
+			TxRoot:        "0x02",
+			Nonce:         5,
+		},
+		Txs: []database.BlockTx{database.NewBlockTx(signedTx, 1, 2)},
+	}
+
+	// Encode and decode the block together with its txs
+	bs, err := blockData.MarshalBinary()
+	assert.Nil(t, err)
+	var decoded database.BlockData
+	err = decoded.UnmarshalBinary(bs)
+	assert.Nil(t, err)
+	assert.Equal(t, blockData, decoded)
+	assert.Equal(t, signature.Hash(blockData.Header), signature.Hash(decoded.Header))
+
+	// Ensure nil target is kept as nil rather than zero
+	blockData.Header.Target = nil
+	bs, err = blockData.MarshalBinary()
+	assert.Nil(t, err)
+	err = decoded.UnmarshalBinary(bs)
+	assert.Nil(t, err)
+	assert.Nil(t, decoded.Header.Target)
+
+	// Ensure the pruned block is decoded without txs
+	pruned := database.BlockData{Hash: blockData.Hash, Header: blockData.Header, Pruned: true}
+	bs, err = pruned.MarshalBinary()
+	assert.Nil(t, err)
+	decoded = database.BlockData{}
+	err = decoded.UnmarshalBinary(bs)
+	assert.Nil(t, err)
+	assert.Equal(t, pruned, decoded)
+
+	// Encode and decode the list of blocks
+	bs, err = database.MarshalBlocksBinary([]database.BlockData{blockData, blockData})
+	assert.Nil(t, err)
+	blocks, err := database.UnmarshalBlocksBinary(bs)
+	assert.Nil(t, err)
+	assert.Equal(t, []database.BlockData{blockData, blockData}, blocks)
+}
+
+func TestCodec_Invalid(t *testing.T) {
+	var blockData database.BlockData
+
+	// Empty data cannot be decoded
+	err := blockData.UnmarshalBinary(nil)
+	assert.EqualError(t, err, "binary decode err: data is empty")
+
+	// Unknown codec version cannot be decoded
+	bs, err := database.BlockData{}.MarshalBinary()
+	assert.Nil(t, err)
+	bs[0] = database.CodecVersion + 1
+	err = blockData.UnmarshalBinary(bs)
+	assert.EqualError(t, err, "binary decode err: unsupported codec version: 2")
+
+	// Truncated data cannot be decoded
+	bs[0] = database.CodecVersion
+	err = blockData.UnmarshalBinary(bs[:len(bs)-1])
+	assert.NotNil(t, err)
+}
@@ -3,71 +3,124 @@ package database
 import (
 	"errors"
 	"fmt"
-	"sort"
 	"sync"
 
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/tchorzewski1991/fitbit/core/blockchain/genesis"
-	"github.com/tchorzewski1991/fitbit/core/blockchain/signature"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/smt"
 )
 
+// Storage keeps the blocks of the chain by their heights, starting with 1.
 type Storage interface {
 	Write(height uint64, data BlockData) error
 	Read(height uint64) (*BlockData, error)
+	ReadByHash(hash string) (*BlockData, error)
+
+	// Head returns the height of the last stored block, zero for the empty storage.
+	Head() (uint64, error)
+
+	// Range passes the stored blocks with heights from the given range to the function
+	// in ascending order. It stops on the first error returned by the function.
+	Range(from, to uint64, fn func(height uint64, data BlockData) error) error
+
+	// TruncateFrom removes the block with given height and all the following blocks.
+	TruncateFrom(height uint64) error
+
 	Reset() error
 	Close() error
 }
 
-type Database struct {
-	mu        sync.RWMutex
-	genesis   genesis.Genesis
-	accounts  Accounts
-	storage   Storage
-	lastBlock Block
+// Config keeps track over all dependencies necessary for
+// proper Database initialization.
+type Config struct {
+	Genesis genesis.Genesis
+	Storage Storage
+
+	// EventHandler is called for the failures which are not reported to the caller.
+	EventHandler func(s string, args ...any)
+
+	// SnapshotInterval defines every how many blocks the state snapshot is
+	// persisted. Zero disables snapshots. Snapshots are persisted only when
+	// the Storage implements SnapshotStorage.
+	SnapshotInterval uint64
+
+	// FullReplay ignores persisted snapshots and replays the whole chain.
+	FullReplay bool
+
+	// Repair truncates the chain to the last valid block when a corrupted
+	// or invalid block is found, instead of refusing to start.
+	Repair bool
+
+	// PruneDepth defines how many of the newest blocks keep their transactions.
+	// Older blocks keep only their headers. Zero disables pruning. Pruning needs
+	// the Storage to implement PrunableStorage and snapshots to be enabled.
+	PruneDepth uint64
 }
 
-// New constructs a new Database.
-func New(genesis genesis.Genesis, storage Storage) (*Database, error) {
-	db := Database{
-		genesis:  genesis,
-		accounts: make(Accounts),
-		storage:  storage,
-	}
+// CorruptedBlockError is returned by the Storage when the stored block
+// cannot be decoded or does not match its checksum.
+type CorruptedBlockError struct {
+	Height uint64
+	Err    error
+}
 
-	if err := db.loadAccounts(); err != nil {
-		return nil, err
-	}
+func (e *CorruptedBlockError) Error() string {
+	return fmt.Sprintf("block: %d is corrupted: %s", e.Height, e.Err)
+}
 
-	var stepErr error
+func (e *CorruptedBlockError) Unwrap() error {
+	return e.Err
+}
 
-	for height := uint64(1); stepErr == nil; height++ {
+type Database struct {
+	mu               sync.RWMutex
+	genesis          genesis.Genesis
+	accounts         Accounts
+	tree             *smt.Tree
+	history          *history
+	index            *txIndex
+	unindexed        uint64
+	storage          Storage
+	lastBlock        Block
+	snapshotInterval uint64
+	pruneDepth       uint64
+	ev               func(s string, args ...any)
+}
 
-		// Load block by given height.
-		block, err := db.ReadBlock(height)
-		if err != nil {
-			break
-		}
+// New constructs a new Database. The state is restored from the newest valid
+// snapshot and only the blocks following the snapshot are replayed.
+func New(cfg Config) (*Database, error) {
+	if cfg.EventHandler == nil {
+		// Set no-op event handler if event handler has not been set.
+		cfg.EventHandler = func(s string, args ...any) {}
+	}
 
-		// Validate block according to the previous block and accounts state.
-		err = block.Validate(db.lastBlock, db.StateRoot())
-		if err != nil {
-			stepErr = err
-			break
-		}
+	db := Database{
+		genesis:          cfg.Genesis,
+		accounts:         make(Accounts),
+		tree:             smt.New(),
+		index:            newTxIndex(),
+		storage:          cfg.Storage,
+		snapshotInterval: cfg.SnapshotInterval,
+		pruneDepth:       cfg.PruneDepth,
+		ev:               cfg.EventHandler,
+	}
 
-		// Apply all block transactions.
-		for _, tx := range block.Tree.Values() {
-			err = db.ApplyTransaction(block, tx)
-			if err != nil {
-				continue
-			}
+	if cfg.PruneDepth > 0 {
+		if _, ok := cfg.Storage.(PrunableStorage); !ok {
+			return nil, errors.New("storage does not support pruning")
 		}
+		if _, ok := cfg.Storage.(SnapshotStorage); !ok || cfg.SnapshotInterval == 0 {
+			return nil, errors.New("pruning requires snapshots to be enabled")
+		}
+	}
 
-		db.ApplyMiningReward(block)
-
-		db.lastBlock = block
+	if err := db.loadAccounts(); err != nil {
+		return nil, err
 	}
-	if stepErr != nil {
-		return nil, stepErr
+
+	if err := db.load(!cfg.FullReplay, cfg.Repair); err != nil {
+		return nil, err
 	}
 
 	return &db, nil
@@ -78,12 +131,7 @@ func (db *Database) Accounts() Accounts {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	accounts := make(Accounts)
-	for id, account := range db.accounts {
-		accounts[id] = account
-	}
-
-	return accounts
+	return db.accounts.Copy()
 }
 
 // Account returns the copy of an account by given AccountID.
@@ -104,7 +152,12 @@ func (db *Database) Remove(accountID AccountID) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	if account, ok := db.accounts[accountID]; ok {
+		db.history.record(db.lastBlock.Height(), accountID, account, true)
+	}
+
 	delete(db.accounts, accountID)
+	db.tree.Update(accountKey(accountID), nil)
 	return nil
 }
 
@@ -118,16 +171,7 @@ func (db *Database) Reset() error {
 		return err
 	}
 
-	// Ensure db accounts are reset and loaded one more time
-	db.accounts = make(map[AccountID]Account)
-	if err := db.loadAccounts(); err != nil {
-		return err
-	}
-
-	// Ensure last block is reset to initial value
-	db.lastBlock = Block{}
-
-	return nil
+	return db.resetState()
 }
 
 // WriteBlock writes a new Block to the underlying Storage.
@@ -148,6 +192,15 @@ func (db *Database) ReadBlock(height uint64) (Block, error) {
 	return data.ToBlock()
 }
 
+// ReadBlockByHash reads Block from the underlying Storage by given hash.
+func (db *Database) ReadBlockByHash(hash string) (Block, error) {
+	data, err := db.storage.ReadByHash(hash)
+	if err != nil {
+		return Block{}, fmt.Errorf("read block err: %w", err)
+	}
+	return data.ToBlock()
+}
+
 // LastBlock returns the copy of last stored Block.
 func (db *Database) LastBlock() Block {
 	db.mu.RLock()
@@ -156,129 +209,356 @@ func (db *Database) LastBlock() Block {
 	return db.lastBlock
 }
 
-// UpdateLastBlock updates last stored Block.
-func (db *Database) UpdateLastBlock(block Block) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+// ApplyBlock validates given Block against the current tip of the chain, writes it
+// to the underlying Storage and applies all its changes to the accounts. Block is
+// applied as a whole or not at all, so a single invalid transaction rejects it.
+func (db *Database) ApplyBlock(block Block) error {
+	accounts, tree, err := db.validateBlock(block)
+	if err != nil {
+		return err
+	}
 
-	db.lastBlock = block
+	if err = db.WriteBlock(block); err != nil {
+		return err
+	}
+
+	db.commitBlock(block, accounts, tree)
+
+	// Block is already applied, while a missing snapshot only makes the next
+	// startup slower, so the failure is not reported to the caller.
+	// The same applies to pruning, which is retried with the next block.
+	if err = db.writeSnapshot(); err != nil {
+		db.ev("[DB][ApplyBlock][Writing snapshot at: %d failed: %s]", block.Height(), err)
+	}
+	if err = db.prune(); err != nil {
+		db.ev("[DB][ApplyBlock][Pruning at: %d failed: %s]", block.Height(), err)
+	}
+
+	return nil
 }
 
-// ApplyMiningReward updates beneficiary account balance with mining reward defined in genesis file.
-func (db *Database) ApplyMiningReward(block Block) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+// ReadBlocks reads Blocks from the underlying Storage by given height range.
+func (db *Database) ReadBlocks(from, to uint64) ([]Block, error) {
+	if lastHeight := db.LastBlock().Height(); to > lastHeight {
+		return nil, fmt.Errorf("cannot read blocks up to height: %d, last height: %d", to, lastHeight)
+	}
 
-	beneficiary := db.accounts[block.Header.BeneficiaryID]
-	beneficiary.Balance += block.Header.Reward
+	var blocks []Block
+	err := db.storage.Range(from, to, func(_ uint64, data BlockData) error {
+		block, err := data.ToBlock()
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, block)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read blocks err: %w", err)
+	}
 
-	db.accounts[block.Header.BeneficiaryID] = beneficiary
+	return blocks, nil
 }
 
-func (db *Database) ApplyTransaction(block Block, tx BlockTx) error {
+// Rollback reverts the database to the state it had right after the block with
+// given height has been applied. Following blocks are removed from the Storage, while
+// accounts are reverted using their history, so the remaining blocks are neither
+// replayed nor touched. The detached blocks are returned in ascending order.
+func (db *Database) Rollback(height uint64) ([]Block, error) {
+	lastHeight := db.LastBlock().Height()
+	if height > lastHeight {
+		return nil, fmt.Errorf("cannot rollback to height: %d, last height: %d", height, lastHeight)
+	}
+	if err := db.checkPrunedHeight(height); err != nil {
+		return nil, fmt.Errorf("cannot rollback to height: %d: %w", height, err)
+	}
+
+	// Ensure the history reaches the height, so the accounts can be reverted.
+	if err := db.rebuildHistory(height); err != nil {
+		return nil, fmt.Errorf("cannot rollback to height: %d: %w", height, err)
+	}
+
+	// Load the detached blocks before they are removed from the storage.
+	blocks, err := db.ReadBlocks(height+1, lastHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	// Block at the height becomes the last one, the empty block stands for the genesis.
+	var block Block
+	if height > 0 {
+		if block, err = db.ReadBlock(height); err != nil {
+			return nil, err
+		}
+	}
+
+	// Storage is truncated first, so the crash in between leaves the chain ending at
+	// the height, which the next startup restores from the snapshots below it.
+	if err = db.truncate(height + 1); err != nil {
+		return nil, err
+	}
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	from, ok := db.accounts[tx.From]
+	db.revertState(block)
+
+	return blocks, nil
+}
+
+// StateRoot returns the root hash of the state tree built from the known database accounts.
+func (db *Database) StateRoot() string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return hexutil.Encode(db.tree.Root())
+}
+
+// CalcStateRoot returns the state root the database would have with given accounts.
+// Only the accounts which differ from the known ones are rehashed.
+func (db *Database) CalcStateRoot(accounts Accounts) string {
+	return hexutil.Encode(db.stateTree(accounts).Root())
+}
+
+// AccountProof returns the account by given AccountID together with the proof
+// of its inclusion in the state root of the last block.
+func (db *Database) AccountProof(accountID AccountID) (AccountProof, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	account, ok := db.accounts[accountID]
 	if !ok {
-		from = Account{ID: tx.From, Balance: 0}
+		return AccountProof{}, errors.New("account not found")
 	}
 
-	to, ok := db.accounts[tx.To]
-	if !ok {
-		to = Account{ID: tx.To, Balance: 0}
+	return AccountProof{
+		Account:   account,
+		Height:    db.lastBlock.Height(),
+		StateRoot: hexutil.Encode(db.tree.Root()),
+		Proof:     db.tree.Prove(accountKey(accountID)),
+	}, nil
+}
+
+// Close closes underlying Storage engine.
+func (db *Database) Close() {
+	_ = db.storage.Close()
+}
+
+// private API
+
+// validateBlock verifies given Block against the last block and the expected target.
+// Block is executed on a copy of the accounts to ensure its header commits to the
+// result of the execution. Accounts and the state tree after the execution are returned.
+func (db *Database) validateBlock(block Block) (Accounts, *smt.Tree, error) {
+	prevBlock := db.LastBlock()
+
+	// Cheap header checks go first, so we do not execute blocks which are not even solved.
+	if err := block.ValidateHeader(prevBlock); err != nil {
+		return nil, nil, err
 	}
 
-	beneficiary, ok := db.accounts[block.Header.BeneficiaryID]
-	if !ok {
-		beneficiary = Account{ID: tx.To, Balance: 0}
+	target, err := db.NextTarget()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accounts, err := db.executeBlock(block)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Calculate current gas fee based on given transaction.
-	gasFee := tx.GasPrice * tx.GasUnits
+	tree := db.stateTree(accounts)
 
-	// Adjust gas fee according to the balance of 'from' account.
-	if gasFee > from.Balance {
-		gasFee = from.Balance
+	err = block.Validate(ValidateArgs{
+		PrevBlock:  prevBlock,
+		StateRoot:  hexutil.Encode(tree.Root()),
+		Target:     target,
+		TxPerBlock: db.genesis.TxPerBlock,
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Update beneficiary account with the gas fee.
-	from.Balance -= gasFee
-	beneficiary.Balance += gasFee
+	return accounts, tree, nil
+}
 
-	// Apply new beneficiary account balance to database.
-	db.accounts[tx.From] = from
-	db.accounts[block.Header.BeneficiaryID] = beneficiary
+// executeBlock applies all transactions of given Block together with the mining
+// reward to a copy of the accounts. It returns *TxError for the first transaction
+// which turns out to be invalid.
+func (db *Database) executeBlock(block Block) (Accounts, error) {
+	accounts := db.Accounts()
 
-	// Perform necessary accounting checks.
-	{
-		if tx.Nonce != (from.Nonce + 1) {
-			return fmt.Errorf("tx invalid, wrong nonce, got: %d, expected: %d", tx.Nonce, from.Nonce+1)
+	for idx, tx := range block.Tree.Values() {
+		if err := tx.Verify(db.genesis.ChainID); err != nil {
+			return nil, newTxError(block, idx, err)
 		}
-
-		if from.Balance == 0 || from.Balance < (tx.Value+tx.Tip) {
-			return fmt.Errorf("tx invalid, insufficient funds, got: %d, expected: %d", from.Balance, tx.Value+tx.Tip)
+		if err := accounts.ApplyTransaction(block.Header.BeneficiaryID, tx); err != nil {
+			return nil, newTxError(block, idx, err)
 		}
 	}
 
-	// Update balances between two parties.
-	from.Balance -= tx.Value
-	to.Balance += tx.Value
+	accounts.ApplyMiningReward(block.Header.BeneficiaryID, block.Header.Reward)
 
-	// Update beneficiary account with the tip.
-	from.Balance -= tx.Tip
-	beneficiary.Balance += tx.Tip
+	return accounts, nil
+}
 
-	// Update from nonce for the next transaction check.
-	from.Nonce = tx.Nonce
+// commitBlock replaces accounts and the state tree with the result of the block
+// execution and makes given Block the last one.
+func (db *Database) commitBlock(block Block, accounts Accounts, tree *smt.Tree) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 
-	// Apply final account balances to database.
-	db.accounts[tx.From] = from
-	db.accounts[tx.To] = to
-	db.accounts[block.Header.BeneficiaryID] = beneficiary
+	// Tree is always forked from the database tree, so it cannot fail.
+	_ = tree.Commit()
 
-	return nil
+	db.history.recordChanges(block.Height(), db.accounts, accounts)
+	db.index.add(block)
+
+	db.accounts = accounts
+	db.lastBlock = block
 }
 
-// StateRoot returns a hash based on the known database accounts.
-func (db *Database) StateRoot() string {
+// stateTree forks the state tree and updates it with the accounts which differ
+// from the known ones. The state tree of the database is left untouched.
+func (db *Database) stateTree(accounts Accounts) *smt.Tree {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	// We don't have many accounts yet, but when the collection will grow
-	// we have the possibility to bypass unnecessary allocations by
-	// setting slice capacity upfront.
-	accounts := make([]Account, 0, len(db.accounts))
+	tree := db.tree.Fork()
 
-	for _, account := range db.accounts {
-		accounts = append(accounts, account)
+	for accountID, account := range accounts {
+		if known, ok := db.accounts[accountID]; ok && known == account {
+			continue
+		}
+		tree.Update(accountKey(accountID), encodeAccount(account))
 	}
 
-	// Sorting accounts by their ID is mandatory as the order in which we get
-	// accounts from the map cannot be determined upfront. Order matters because
-	// by changing order hash will be changed as well.
-	sort.Sort(byAccountID(accounts))
+	for accountID := range db.accounts {
+		if _, ok := accounts[accountID]; !ok {
+			tree.Update(accountKey(accountID), nil)
+		}
+	}
 
-	return signature.Hash(accounts)
+	return tree
 }
 
-// Close closes underlying Storage engine.
-func (db *Database) Close() {
-	_ = db.storage.Close()
+// load restores the state starting with the newest valid snapshot, if allowed, and
+// replays the following blocks up to the head of the Storage. With repair enabled,
+// the chain is truncated to the last valid block instead of reporting the error.
+func (db *Database) load(useSnapshot bool, repair bool) error {
+	if useSnapshot && db.loadSnapshot() {
+		db.mu.Lock()
+		db.unindexed = db.lastBlock.Height()
+		db.mu.Unlock()
+	}
+
+	head, err := db.storage.Head()
+	if err != nil {
+		return fmt.Errorf("read head err: %w", err)
+	}
+
+	err = db.storage.Range(db.LastBlock().Height()+1, head, func(height uint64, data BlockData) error {
+		block, err := data.ToBlock()
+		if errors.Is(err, ErrBlockPruned) {
+			return err
+		}
+		if err != nil {
+			return &CorruptedBlockError{Height: height, Err: err}
+		}
+
+		// Validate block according to the previous block and accounts state.
+		accounts, tree, err := db.validateBlock(block)
+		if err != nil {
+			return fmt.Errorf("block: %d is invalid: %w", height, err)
+		}
+
+		db.commitBlock(block, accounts, tree)
+		return nil
+	})
+	if err == nil {
+		return nil
+	}
+
+	// Pruned blocks are expected, so they are never truncated by the repair.
+	if errors.Is(err, ErrBlockPruned) {
+		return fmt.Errorf("%w, state has to be restored from a snapshot", err)
+	}
+	if !repair {
+		return fmt.Errorf("%w: repair is required", err)
+	}
+	if err = db.truncate(db.LastBlock().Height() + 1); err != nil {
+		return fmt.Errorf("repair err: %w", err)
+	}
+
+	return nil
 }
 
-// private API
+// truncate removes the block with given height and all the following blocks from
+// the Storage together with the snapshots taken for them.
+func (db *Database) truncate(height uint64) error {
+	if err := db.storage.TruncateFrom(height); err != nil {
+		return err
+	}
+	return db.removeSnapshotsFrom(height)
+}
+
+// revertState reverts accounts and all the state derived from blocks to the state
+// right after given Block has been applied. The history has to reach the block height.
+// It has to be called while holding the lock.
+func (db *Database) revertState(block Block) {
+	height := block.Height()
+
+	db.accounts = db.history.accounts(height)
+	db.history.truncate(height)
+	db.index.truncate(height)
+	if db.unindexed > height {
+		db.unindexed = height
+	}
+
+	db.tree = smt.New()
+	for accountID, account := range db.accounts {
+		db.tree.Update(accountKey(accountID), encodeAccount(account))
+	}
+
+	db.lastBlock = block
+}
+
+// resetState resets accounts and all the state derived from blocks to the genesis state.
+// It has to be called while holding the lock.
+func (db *Database) resetState() error {
+	db.accounts = make(map[AccountID]Account)
+	db.tree = smt.New()
+	db.index = newTxIndex()
+	db.unindexed = 0
+	db.lastBlock = Block{}
+
+	return db.loadAccounts()
+}
 
 func (db *Database) loadAccounts() error {
+	accounts, err := db.genesisAccounts()
+	if err != nil {
+		return err
+	}
+	for accountID, account := range accounts {
+		db.accounts[accountID] = account
+		db.tree.Update(accountKey(accountID), encodeAccount(account))
+	}
+	db.history = newHistory(0, db.accounts)
+	return nil
+}
+
+// genesisAccounts returns the accounts with the balances defined by the genesis.
+func (db *Database) genesisAccounts() (Accounts, error) {
+	accounts := make(Accounts, len(db.genesis.Balances))
 	for account, balance := range db.genesis.Balances {
 		accountID, err := ToAccountID(account)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		db.accounts[accountID] = Account{
+		accounts[accountID] = Account{
 			ID:      accountID,
 			Balance: balance,
 		}
 	}
-	return nil
+	return accounts, nil
 }
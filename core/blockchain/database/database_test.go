@@ -1,6 +1,8 @@
 package database_test
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -8,10 +10,11 @@ import (
 	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
 	"github.com/tchorzewski1991/fitbit/core/blockchain/genesis"
 	"github.com/tchorzewski1991/fitbit/core/blockchain/storage/memory"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/testdata"
 )
 
 func TestDatabase_Reset(t *testing.T) {
-	db, err := database.New(mockGenesis(), mockStorage(t))
+	db, err := database.New(database.Config{Genesis: mockGenesis(), Storage: mockStorage(t)})
 	assert.Nil(t, err)
 
 	// Check how many accounts we have before remove
@@ -33,7 +36,7 @@ func TestDatabase_Reset(t *testing.T) {
 }
 
 func TestDatabase_Accounts(t *testing.T) {
-	db, err := database.New(mockGenesis(), mockStorage(t))
+	db, err := database.New(database.Config{Genesis: mockGenesis(), Storage: mockStorage(t)})
 	assert.Nil(t, err)
 
 	expectedAccounts := database.Accounts{
@@ -58,7 +61,7 @@ func TestDatabase_Accounts(t *testing.T) {
 }
 
 func TestDatabase_Account(t *testing.T) {
-	db, err := database.New(mockGenesis(), mockStorage(t))
+	db, err := database.New(database.Config{Genesis: mockGenesis(), Storage: mockStorage(t)})
 	assert.Nil(t, err)
 
 	// Query for not existing account and assert the error.
@@ -76,8 +79,270 @@ func TestDatabase_Account(t *testing.T) {
 	assert.Equal(t, expectedAccount, account)
 }
 
+func TestDatabase_Rollback(t *testing.T) {
+	db, err := database.New(database.Config{Genesis: mockGenesis(), Storage: mockStorage(t)})
+	assert.Nil(t, err)
+
+	// Apply three blocks, each with a single transaction and keep
+	// the state root of the block we are going to rollback to
+	var stateRoot string
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		block := mockBlock(t, db, mockBlockTx(t, nonce))
+		err = db.ApplyBlock(block)
+		assert.Nil(t, err)
+
+		if nonce == 1 {
+			stateRoot = db.StateRoot()
+		}
+	}
+	assert.Equal(t, uint64(3), db.LastBlock().Height())
+
+	// Rollback to the height which does not exist yet and assert err
+	_, err = db.Rollback(4)
+	assert.EqualError(t, err, "cannot rollback to height: 4, last height: 3")
+
+	// Rollback to the first block
+	detached, err := db.Rollback(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(detached))
+	assert.Equal(t, uint64(2), detached[0].Height())
+	assert.Equal(t, uint64(3), detached[1].Height())
+	assert.Equal(t, uint64(1), db.LastBlock().Height())
+	assert.Equal(t, stateRoot, db.StateRoot())
+
+	// Detached blocks are not readable from the storage anymore
+	_, err = db.ReadBlock(2)
+	assert.NotNil(t, err)
+
+	// Ensure the detached block can be applied one more time
+	err = db.ApplyBlock(detached[0])
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), db.LastBlock().Height())
+}
+
+func TestDatabase_RollbackCrash(t *testing.T) {
+	storage := mockMemory(t)
+
+	db, err := database.New(database.Config{Genesis: mockGenesis(), Storage: storage, SnapshotInterval: 2})
+	assert.Nil(t, err)
+
+	var accounts database.Accounts
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		err = db.ApplyBlock(mockBlock(t, db, mockBlockTx(t, nonce)))
+		assert.Nil(t, err)
+
+		if nonce == 1 {
+			accounts = db.Accounts()
+		}
+	}
+
+	// Simulate the crash right after the rollback truncated the storage, before
+	// the snapshot of the detached block has been removed
+	err = storage.TruncateFrom(2)
+	assert.Nil(t, err)
+
+	// Ensure the blocks below the rollback height are kept and restored on startup
+	restored, err := database.New(database.Config{Genesis: mockGenesis(), Storage: storage, SnapshotInterval: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), restored.LastBlock().Height())
+	assert.Equal(t, accounts, restored.Accounts())
+}
+
+func TestDatabase_ApplyBlockTxPerBlock(t *testing.T) {
+	gen := mockGenesis()
+	gen.TxPerBlock = 1
+
+	db, err := database.New(database.Config{Genesis: gen, Storage: mockStorage(t)})
+	assert.Nil(t, err)
+
+	// Block with more transactions than allowed is rejected
+	block := mockBlock(t, db, mockBlockTx(t, 1), mockBlockTx(t, 2))
+	err = db.ApplyBlock(block)
+	assert.EqualError(t, err, "tx count check failed: tx count: 2 | tx per block: 1")
+	assert.Equal(t, uint64(0), db.LastBlock().Height())
+}
+
+func TestDatabase_ApplyBlockInvalidTx(t *testing.T) {
+	db, err := database.New(database.Config{Genesis: mockGenesis(), Storage: mockStorage(t)})
+	assert.Nil(t, err)
+
+	accounts := db.Accounts()
+
+	// Block with the second transaction skipping a nonce is rejected as a whole
+	block := mockBlock(t, db, mockBlockTx(t, 1), mockBlockTx(t, 3))
+	err = db.ApplyBlock(block)
+
+	var txErr *database.TxError
+	assert.True(t, errors.As(err, &txErr))
+	assert.Equal(t, uint64(1), txErr.Height)
+	assert.Equal(t, block.Hash(), txErr.Hash)
+	assert.Equal(t, 1, txErr.TxIndex)
+	assert.EqualError(t, txErr.Err, "tx invalid, wrong nonce, got: 3, expected: 2")
+
+	// Ensure none of the transactions has been applied
+	assert.Equal(t, accounts, db.Accounts())
+	assert.Equal(t, uint64(0), db.LastBlock().Height())
+
+	// Block with the transaction the sender cannot afford is rejected as well
+	tx := mockBlockTx(t, 1)
+	tx.Value = 1000
+	signedTx, err := tx.Tx.Sign(testdata.LoadPrivateKey(t))
+	assert.Nil(t, err)
+	tx.SignedTx = signedTx
+
+	err = db.ApplyBlock(mockBlock(t, db, tx))
+	assert.ErrorContains(t, err, "tx invalid, insufficient funds, got: 100, expected: 1002")
+	assert.Equal(t, accounts, db.Accounts())
+}
+
+func TestDatabase_ApplyBlockStateRoot(t *testing.T) {
+	db, err := database.New(database.Config{Genesis: mockGenesis(), Storage: mockStorage(t)})
+	assert.Nil(t, err)
+
+	target, err := db.NextTarget()
+	assert.Nil(t, err)
+
+	// Block committing to the state before its execution is rejected
+	block, err := database.POW(context.Background(), database.POWArgs{
+		BeneficiaryID: "0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0",
+		Target:        target,
+		Reward:        mockGenesis().MiningReward,
+		PrevBlock:     db.LastBlock(),
+		StateRoot:     db.StateRoot(),
+		Txs:           []database.BlockTx{mockBlockTx(t, 1)},
+		Ev:            func(s string, args ...any) {},
+	})
+	assert.Nil(t, err)
+
+	err = db.ApplyBlock(block)
+	assert.ErrorContains(t, err, "state root check failed")
+	assert.Equal(t, uint64(0), db.LastBlock().Height())
+
+	// Block committing to the state after its execution is accepted
+	block = mockBlock(t, db, mockBlockTx(t, 1))
+	err = db.ApplyBlock(block)
+	assert.Nil(t, err)
+	assert.Equal(t, block.Header.StateRoot, db.StateRoot())
+}
+
+func TestDatabase_AccountProof(t *testing.T) {
+	db, err := database.New(database.Config{Genesis: mockGenesis(), Storage: mockStorage(t)})
+	assert.Nil(t, err)
+
+	err = db.ApplyBlock(mockBlock(t, db, mockBlockTx(t, 1)))
+	assert.Nil(t, err)
+
+	// Ensure the proof verifies the account against the state root of the last block
+	proof, err := db.AccountProof("0x0ee5ba68586c85880B0900D0dEe0eEcBB37010e0")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), proof.Height)
+	assert.Equal(t, db.LastBlock().Header.StateRoot, proof.StateRoot)
+	assert.Equal(t, uint64(1), proof.Account.Nonce)
+	assert.True(t, proof.Verify())
+
+	// Ensure the proof does not verify a modified account
+	proof.Account.Balance++
+	assert.False(t, proof.Verify())
+
+	// Ensure the proof cannot be built for unknown account
+	_, err = db.AccountProof("0x0000000000000000000000000000000000000001")
+	assert.EqualError(t, err, "account not found")
+}
+
+func TestDatabase_ReadBlockByHash(t *testing.T) {
+	db, err := database.New(database.Config{Genesis: mockGenesis(), Storage: mockStorage(t)})
+	assert.Nil(t, err)
+
+	block := mockBlock(t, db, mockBlockTx(t, 1))
+	err = db.ApplyBlock(block)
+	assert.Nil(t, err)
+
+	// Ensure the block is found by its hash
+	dbBlock, err := db.ReadBlockByHash(block.Hash())
+	assert.Nil(t, err)
+	assert.Equal(t, block.Hash(), dbBlock.Hash())
+
+	// Ensure unknown hash is reported
+	_, err = db.ReadBlockByHash("0x00")
+	assert.NotNil(t, err)
+}
+
+func TestDatabase_Repair(t *testing.T) {
+	storage := &corruptedStorage{Memory: mockMemory(t)}
+
+	db, err := database.New(database.Config{Genesis: mockGenesis(), Storage: storage})
+	assert.Nil(t, err)
+
+	var stateRoot string
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		err = db.ApplyBlock(mockBlock(t, db, mockBlockTx(t, nonce)))
+		assert.Nil(t, err)
+		if nonce == 1 {
+			stateRoot = db.StateRoot()
+		}
+	}
+
+	// Ensure the corrupted block is reported with its height
+	storage.height = 2
+	_, err = database.New(database.Config{Genesis: mockGenesis(), Storage: storage})
+	assert.ErrorContains(t, err, "block: 2 is corrupted")
+
+	// Ensure repair truncates the chain to the last valid block
+	repaired, err := database.New(database.Config{Genesis: mockGenesis(), Storage: storage, Repair: true})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), repaired.LastBlock().Height())
+	assert.Equal(t, stateRoot, repaired.StateRoot())
+
+	// Ensure the truncated chain is loaded without repair afterwards
+	storage.height = 0
+	db, err = database.New(database.Config{Genesis: mockGenesis(), Storage: storage})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), db.LastBlock().Height())
+}
+
 // Helper functions
 
+func mockBlockTx(t *testing.T, nonce uint64) database.BlockTx {
+	params := defaultTxParams(t)
+
+	tx := buildTx(&params)
+	tx.Nonce = nonce
+	tx.Value = 10
+	tx.Tip = 1
+
+	signedTx, err := tx.Sign(testdata.LoadPrivateKey(t))
+	assert.Nil(t, err)
+
+	return database.NewBlockTx(signedTx, 1, 1)
+}
+
+func mockBlock(t *testing.T, db *database.Database, txs ...database.BlockTx) database.Block {
+	const beneficiaryID = database.AccountID("0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0")
+
+	target, err := db.NextTarget()
+	assert.Nil(t, err)
+
+	// Calculate the state root after the block is applied, invalid
+	// transactions are kept on purpose to let tests verify rejections
+	accounts := db.Accounts()
+	for _, tx := range txs {
+		_ = accounts.ApplyTransaction(beneficiaryID, tx)
+	}
+	accounts.ApplyMiningReward(beneficiaryID, mockGenesis().MiningReward)
+
+	block, err := database.POW(context.Background(), database.POWArgs{
+		BeneficiaryID: beneficiaryID,
+		Target:        target,
+		Reward:        mockGenesis().MiningReward,
+		PrevBlock:     db.LastBlock(),
+		StateRoot:     db.CalcStateRoot(accounts),
+		Txs:           txs,
+		Ev:            func(s string, args ...any) {},
+	})
+	assert.Nil(t, err)
+	return block
+}
+
 func mockGenesis() genesis.Genesis {
 	return genesis.Genesis{
 		Date:         time.Time{},
@@ -94,7 +359,33 @@ func mockGenesis() genesis.Genesis {
 }
 
 func mockStorage(t *testing.T) database.Storage {
+	return mockMemory(t)
+}
+
+func mockMemory(t *testing.T) *memory.Memory {
 	storage, err := memory.New()
 	assert.Nil(t, err)
 	return storage
 }
+
+// corruptedStorage reports the block with given height as corrupted.
+type corruptedStorage struct {
+	*memory.Memory
+	height uint64
+}
+
+func (s *corruptedStorage) Read(height uint64) (*database.BlockData, error) {
+	if height == s.height {
+		return nil, &database.CorruptedBlockError{Height: height, Err: errors.New("checksum mismatch")}
+	}
+	return s.Memory.Read(height)
+}
+
+func (s *corruptedStorage) Range(from, to uint64, fn func(height uint64, data database.BlockData) error) error {
+	return s.Memory.Range(from, to, func(height uint64, data database.BlockData) error {
+		if height == s.height {
+			return &database.CorruptedBlockError{Height: height, Err: errors.New("checksum mismatch")}
+		}
+		return fn(height, data)
+	})
+}
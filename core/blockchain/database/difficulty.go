@@ -0,0 +1,94 @@
+package database
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/tchorzewski1991/fitbit/core/blockchain/genesis"
+)
+
+// maxAdjustment limits how much the target can change during a single retarget.
+const maxAdjustment = 4
+
+// maxTarget is the easiest target a block can ever have.
+var maxTarget = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// InitialTarget converts the difficulty, defined as the number of leading zeros
+// of the hex encoded hash, into the target the first block has to meet.
+func InitialTarget(difficulty uint16) (*big.Int, error) {
+	if difficulty > genesis.MaxDifficulty {
+		return nil, fmt.Errorf("difficulty: %d exceeds the maximum: %d", difficulty, genesis.MaxDifficulty)
+	}
+	if difficulty == 0 {
+		return new(big.Int).Set(maxTarget), nil
+	}
+	return new(big.Int).Lsh(big.NewInt(1), 256-4*uint(difficulty)), nil
+}
+
+// NextTarget returns the target the block following the last block has to meet.
+// The target is recalculated every genesis retarget window based on the time it
+// took to mine the blocks of the last window, otherwise the parent target is used.
+func (db *Database) NextTarget() (*big.Int, error) {
+	lastBlock := db.LastBlock()
+	if lastBlock.Height() == 0 {
+		return InitialTarget(db.genesis.Difficulty)
+	}
+
+	window := db.genesis.RetargetWindow
+	if window < 2 || lastBlock.Height()%window != 0 {
+		return lastBlock.Header.Target, nil
+	}
+
+	// Read the first block of the window we are closing.
+	// Header is enough, so the window can reach the pruned blocks.
+	first, err := db.readHeader(lastBlock.Height() - window + 1)
+	if err != nil {
+		return nil, fmt.Errorf("read retarget window err: %w", err)
+	}
+
+	actual := lastBlock.Header.Timestamp - first.Timestamp
+	expected := (window - 1) * db.genesis.BlockTime
+
+	return retarget(lastBlock.Header.Target, actual, expected), nil
+}
+
+// retarget scales given target by the ratio of the actual to the expected
+// time of the window. Blocks mined too fast make the target lower (harder),
+// while blocks mined too slow make it higher (easier).
+func retarget(target *big.Int, actual, expected uint64) *big.Int {
+	if expected == 0 {
+		return target
+	}
+
+	// Ensure a single window cannot change the target too drastically.
+	if actual < expected/maxAdjustment {
+		actual = expected / maxAdjustment
+	}
+	if actual > expected*maxAdjustment {
+		actual = expected * maxAdjustment
+	}
+
+	next := new(big.Int).Mul(target, new(big.Int).SetUint64(actual))
+	next.Div(next, new(big.Int).SetUint64(expected))
+
+	if next.Cmp(maxTarget) > 0 {
+		return new(big.Int).Set(maxTarget)
+	}
+	if next.Sign() == 0 {
+		return big.NewInt(1)
+	}
+
+	return next
+}
+
+// isSolved checks whether the 0x prefixed hex encoded hash is below the target.
+func isSolved(hash string, target *big.Int) bool {
+	if target == nil || len(hash) < 2 {
+		return false
+	}
+	value, ok := new(big.Int).SetString(hash[2:], 16)
+	if !ok {
+		return false
+	}
+	return value.Cmp(target) < 0
+}
@@ -0,0 +1,66 @@
+package database_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+)
+
+func TestInitialTarget(t *testing.T) {
+	// Ensure each leading zero makes the target 16 times lower
+	assert.Equal(t, new(big.Int).Lsh(big.NewInt(1), 252), initialTarget(t, 1))
+	assert.Equal(t, new(big.Int).Lsh(big.NewInt(1), 232), initialTarget(t, 6))
+	assert.Equal(t, big.NewInt(1), initialTarget(t, 64))
+
+	// Ensure difficulty exceeding the hash length is rejected
+	_, err := database.InitialTarget(65)
+	assert.NotNil(t, err)
+}
+
+func TestDatabase_NextTarget(t *testing.T) {
+	gen := mockGenesis()
+	gen.RetargetWindow = 2
+	gen.BlockTime = 60
+
+	db, err := database.New(database.Config{Genesis: gen, Storage: mockStorage(t)})
+	assert.Nil(t, err)
+
+	// The first block uses the target derived from the genesis difficulty
+	target, err := db.NextTarget()
+	assert.Nil(t, err)
+	assert.Equal(t, initialTarget(t, gen.Difficulty), target)
+
+	// Blocks within the retarget window keep the parent target
+	err = db.ApplyBlock(mockBlock(t, db, mockBlockTx(t, 1)))
+	assert.Nil(t, err)
+
+	target, err = db.NextTarget()
+	assert.Nil(t, err)
+	assert.Equal(t, initialTarget(t, gen.Difficulty), target)
+
+	// Closing the window much faster than expected makes the target
+	// lower, but not more than 4 times at once
+	err = db.ApplyBlock(mockBlock(t, db, mockBlockTx(t, 2)))
+	assert.Nil(t, err)
+
+	target, err = db.NextTarget()
+	assert.Nil(t, err)
+	expected := new(big.Int).Div(initialTarget(t, gen.Difficulty), big.NewInt(4))
+	assert.Equal(t, expected, target)
+
+	// Block which does not use the expected target is rejected
+	block := mockBlock(t, db, mockBlockTx(t, 3))
+	block.Header.Target = initialTarget(t, gen.Difficulty)
+	err = db.ApplyBlock(block)
+	assert.NotNil(t, err)
+}
+
+// Helper functions
+
+func initialTarget(t *testing.T, difficulty uint16) *big.Int {
+	target, err := database.InitialTarget(difficulty)
+	assert.Nil(t, err)
+	return target
+}
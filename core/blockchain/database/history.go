@@ -0,0 +1,258 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+)
+
+// history keeps every version of every account since the earliest recorded height,
+// so the accounts can be reconstructed as they were right after any block since then.
+// Database restored from a snapshot starts its history at the snapshot height, while
+// the older versions are rebuilt from the stored blocks on the first query needing them.
+type history struct {
+	earliest uint64
+	versions map[AccountID][]accountVersion
+}
+
+// accountVersion represents the account as it was since given height.
+type accountVersion struct {
+	height  uint64
+	account Account
+	removed bool
+}
+
+// newHistory constructs a new history starting with given accounts at given height.
+func newHistory(height uint64, accounts Accounts) *history {
+	h := history{
+		earliest: height,
+		versions: make(map[AccountID][]accountVersion, len(accounts)),
+	}
+	for accountID, account := range accounts {
+		h.record(height, accountID, account, false)
+	}
+	return &h
+}
+
+// record adds a new version of the account. Version recorded once again
+// for the same height replaces the previous one.
+func (h *history) record(height uint64, accountID AccountID, account Account, removed bool) {
+	version := accountVersion{height: height, account: account, removed: removed}
+
+	versions := h.versions[accountID]
+	if n := len(versions); n > 0 && versions[n-1].height == height {
+		versions[n-1] = version
+		return
+	}
+	h.versions[accountID] = append(versions, version)
+}
+
+// recordChanges adds a new version of every account which differs between
+// the previous and the next accounts.
+func (h *history) recordChanges(height uint64, prev, next Accounts) {
+	for accountID, account := range next {
+		if known, ok := prev[accountID]; ok && known == account {
+			continue
+		}
+		h.record(height, accountID, account, false)
+	}
+	for accountID, account := range prev {
+		if _, ok := next[accountID]; !ok {
+			h.record(height, accountID, account, true)
+		}
+	}
+}
+
+// account returns the version of the account valid at given height.
+func (h *history) account(accountID AccountID, height uint64) (Account, bool) {
+	versions := h.versions[accountID]
+
+	// Find the first version recorded after given height, the one before it is valid.
+	idx := sort.Search(len(versions), func(i int) bool {
+		return versions[i].height > height
+	})
+	if idx == 0 || versions[idx-1].removed {
+		return Account{}, false
+	}
+
+	return versions[idx-1].account, true
+}
+
+// accounts returns all accounts valid at given height.
+func (h *history) accounts(height uint64) Accounts {
+	accounts := make(Accounts)
+	for accountID := range h.versions {
+		if account, ok := h.account(accountID, height); ok {
+			accounts[accountID] = account
+		}
+	}
+	return accounts
+}
+
+// truncate drops all versions recorded after given height.
+func (h *history) truncate(height uint64) {
+	for accountID, versions := range h.versions {
+		idx := sort.Search(len(versions), func(i int) bool {
+			return versions[i].height > height
+		})
+		if idx == 0 {
+			delete(h.versions, accountID)
+			continue
+		}
+		h.versions[accountID] = versions[:idx]
+	}
+}
+
+// prepend moves the versions of the older history, recorded before the earliest
+// height of this one, in front of the current versions.
+func (h *history) prepend(older *history) {
+	for accountID, versions := range older.versions {
+		var kept []accountVersion
+		for _, version := range versions {
+			if version.height < h.earliest {
+				kept = append(kept, version)
+			}
+		}
+		h.versions[accountID] = append(kept, h.versions[accountID]...)
+	}
+	h.earliest = older.earliest
+}
+
+// AccountsAt returns the copy of all Accounts as they were right after the block
+// with given height has been applied.
+func (db *Database) AccountsAt(height uint64) (Accounts, error) {
+	if err := db.rebuildHistory(height); err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if err := db.checkHistoryHeight(height); err != nil {
+		return nil, err
+	}
+
+	return db.history.accounts(height), nil
+}
+
+// AccountAt returns the copy of an account by given AccountID as it was right after
+// the block with given height has been applied.
+func (db *Database) AccountAt(accountID AccountID, height uint64) (Account, error) {
+	if err := db.rebuildHistory(height); err != nil {
+		return Account{}, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if err := db.checkHistoryHeight(height); err != nil {
+		return Account{}, err
+	}
+
+	account, ok := db.history.account(accountID, height)
+	if !ok {
+		return Account{}, fmt.Errorf("account not found at height: %d", height)
+	}
+
+	return account, nil
+}
+
+// checkHistoryHeight ensures the accounts at given height can be reconstructed.
+// It expects the read lock to be held.
+func (db *Database) checkHistoryHeight(height uint64) error {
+	if lastHeight := db.lastBlock.Height(); height > lastHeight {
+		return fmt.Errorf("height: %d is above the last height: %d", height, lastHeight)
+	}
+	if height < db.history.earliest {
+		return fmt.Errorf("state at height: %d is not available, earliest height: %d", height, db.history.earliest)
+	}
+	return nil
+}
+
+// rebuildHistory extends the history back to given height by replaying the stored blocks,
+// starting with the newest valid snapshot at or below the height, or with the genesis.
+// It is done on the first query rather than on startup, so the snapshot keeps the startup
+// fast. Transactions of pruned blocks are not available, so they cannot be replayed.
+func (db *Database) rebuildHistory(height uint64) error {
+	db.mu.RLock()
+	earliest := db.history.earliest
+	lastHeight := db.lastBlock.Height()
+	db.mu.RUnlock()
+
+	if height >= earliest || height > lastHeight {
+		return nil
+	}
+
+	base, accounts, err := db.historyBase(height)
+	if err != nil {
+		return fmt.Errorf("rebuild history err: %w", err)
+	}
+
+	// Blocks are read without holding the lock, as it may take a while.
+	older := newHistory(base, accounts)
+	err = db.storage.Range(base+1, earliest, func(h uint64, data BlockData) error {
+		if data.Pruned {
+			return fmt.Errorf("state at height: %d is not available: block: %d: %w", height, h, ErrBlockPruned)
+		}
+
+		block, err := data.ToBlock()
+		if err != nil {
+			return &CorruptedBlockError{Height: h, Err: err}
+		}
+
+		// Stored blocks have been validated already, so only their changes are applied.
+		next := accounts.Copy()
+		for _, tx := range block.Tree.Values() {
+			if err = next.ApplyTransaction(block.Header.BeneficiaryID, tx); err != nil {
+				return fmt.Errorf("block: %d is invalid: %w", h, err)
+			}
+		}
+		next.ApplyMiningReward(block.Header.BeneficiaryID, block.Header.Reward)
+
+		older.recordChanges(h, accounts, next)
+		accounts = next
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("rebuild history err: %w", err)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Ensure the history has not been replaced in the meantime.
+	if db.history.earliest != earliest {
+		return nil
+	}
+	db.history.prepend(older)
+
+	return nil
+}
+
+// historyBase returns the height and the accounts the history is rebuilt from, which is
+// the newest valid snapshot at or below given height, or the genesis when there is none.
+func (db *Database) historyBase(height uint64) (uint64, Accounts, error) {
+	if storage, ok := db.storage.(SnapshotStorage); ok {
+		heights, err := storage.Snapshots()
+		if err != nil {
+			return 0, nil, fmt.Errorf("list snapshots err: %w", err)
+		}
+		for i := len(heights) - 1; i >= 0; i-- {
+			if heights[i] > height {
+				continue
+			}
+			snapshot, err := storage.ReadSnapshot(heights[i])
+			if err != nil {
+				continue
+			}
+			if _, accounts, _, err := db.verifySnapshot(*snapshot); err == nil {
+				return snapshot.Height, accounts, nil
+			}
+		}
+	}
+
+	accounts, err := db.genesisAccounts()
+	if err != nil {
+		return 0, nil, err
+	}
+	return 0, accounts, nil
+}
@@ -0,0 +1,110 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+)
+
+func TestDatabase_AccountsAt(t *testing.T) {
+	const accountID = database.AccountID("0x0ee5ba68586c85880B0900D0dEe0eEcBB37010e0")
+
+	storage := mockStorage(t)
+
+	db, err := database.New(database.Config{Genesis: mockGenesis(), Storage: storage, SnapshotInterval: 2})
+	assert.Nil(t, err)
+
+	genesisAccounts := db.Accounts()
+
+	var accounts []database.Accounts
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		err = db.ApplyBlock(mockBlock(t, db, mockBlockTx(t, nonce)))
+		assert.Nil(t, err)
+		accounts = append(accounts, db.Accounts())
+	}
+
+	// Ensure accounts at the genesis height are the genesis accounts
+	dbAccounts, err := db.AccountsAt(0)
+	assert.Nil(t, err)
+	assert.Equal(t, genesisAccounts, dbAccounts)
+
+	// Ensure accounts at every height are the accounts right after the block
+	for height := uint64(1); height <= 3; height++ {
+		dbAccounts, err = db.AccountsAt(height)
+		assert.Nil(t, err)
+		assert.Equal(t, accounts[height-1], dbAccounts)
+
+		account, err := db.AccountAt(accountID, height)
+		assert.Nil(t, err)
+		assert.Equal(t, height, account.Nonce)
+	}
+
+	// Ensure heights above the last block are rejected
+	_, err = db.AccountsAt(4)
+	assert.EqualError(t, err, "height: 4 is above the last height: 3")
+
+	// Ensure unknown account is reported
+	_, err = db.AccountAt("0x0000000000000000000000000000000000000001", 1)
+	assert.EqualError(t, err, "account not found at height: 1")
+
+	// Ensure database restored from the snapshot rebuilds the history before the snapshot
+	restored, err := database.New(database.Config{Genesis: mockGenesis(), Storage: storage})
+	assert.Nil(t, err)
+
+	for height := uint64(3); height >= 1; height-- {
+		dbAccounts, err = restored.AccountsAt(height)
+		assert.Nil(t, err)
+		assert.Equal(t, accounts[height-1], dbAccounts)
+	}
+
+	dbAccounts, err = restored.AccountsAt(0)
+	assert.Nil(t, err)
+	assert.Equal(t, genesisAccounts, dbAccounts)
+}
+
+func TestDatabase_AccountsAtAfterRollback(t *testing.T) {
+	storage := mockTrackingStorage(t)
+
+	db, err := database.New(database.Config{Genesis: mockGenesis(), Storage: storage, SnapshotInterval: 2})
+	assert.Nil(t, err)
+
+	var accounts []database.Accounts
+	var txHashes []string
+	for nonce := uint64(1); nonce <= 5; nonce++ {
+		tx := mockBlockTx(t, nonce)
+		txHashes = append(txHashes, tx.HexHash())
+		err = db.ApplyBlock(mockBlock(t, db, tx))
+		assert.Nil(t, err)
+		accounts = append(accounts, db.Accounts())
+	}
+
+	// Rollback below the newest snapshot without replaying the remaining blocks
+	storage.reads = nil
+	_, err = db.Rollback(3)
+	assert.Nil(t, err)
+	assert.NotContains(t, storage.reads, uint64(1))
+	assert.Equal(t, accounts[2], db.Accounts())
+
+	// Ensure the history before the rollback height is kept
+	for height := uint64(1); height <= 3; height++ {
+		dbAccounts, err := db.AccountsAt(height)
+		assert.Nil(t, err)
+		assert.Equal(t, accounts[height-1], dbAccounts)
+	}
+
+	// Ensure the history and receipts of the detached blocks are dropped
+	_, err = db.AccountsAt(4)
+	assert.EqualError(t, err, "height: 4 is above the last height: 3")
+	_, err = db.Receipt(txHashes[3])
+	assert.EqualError(t, err, "tx not found")
+	_, err = db.Receipt(txHashes[2])
+	assert.Nil(t, err)
+
+	// Ensure the detached block can be applied again on top of the reverted state
+	err = db.ApplyBlock(mockBlock(t, db, mockBlockTx(t, 4)))
+	assert.Nil(t, err)
+	account, err := db.AccountAt("0x0ee5ba68586c85880B0900D0dEe0eEcBB37010e0", 4)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), account.Nonce)
+}
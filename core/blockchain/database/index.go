@@ -0,0 +1,149 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+)
+
+// txIndex keeps the secondary indexes of all committed transactions: the receipts
+// by transaction hash and the history of transactions touching every account.
+// Transactions of the blocks up to the pruned height are missing from the indexes.
+type txIndex struct {
+	receipts map[string]Receipt
+	accounts map[AccountID][]AccountTx
+	pruned   uint64
+}
+
+// newTxIndex constructs a new, empty txIndex.
+func newTxIndex() *txIndex {
+	return &txIndex{
+		receipts: make(map[string]Receipt),
+		accounts: make(map[AccountID][]AccountTx),
+	}
+}
+
+// add indexes all transactions of given Block together with its mining reward.
+// Blocks are expected to be added in ascending order.
+func (x *txIndex) add(block Block) {
+	height := block.Height()
+	beneficiaryID := block.Header.BeneficiaryID
+
+	for idx, tx := range block.Tree.Values() {
+		hash := tx.HexHash()
+		x.receipts[hash] = Receipt{
+			TxHash:      hash,
+			BlockHeight: height,
+			BlockHash:   block.Hash(),
+			Index:       idx,
+			GasUsed:     tx.GasUnits,
+			Status:      ReceiptStatusSuccess,
+		}
+
+		entry := AccountTx{BlockHeight: height, Index: idx, TxHash: hash}
+		x.addEntry(tx.From, entry, DirectionSent, tx.To, tx.Value)
+		x.addEntry(tx.To, entry, DirectionReceived, tx.From, tx.Value)
+		x.addEntry(beneficiaryID, entry, DirectionFee, tx.From, tx.GasPrice*tx.GasUnits+tx.Tip)
+	}
+
+	reward := AccountTx{BlockHeight: height, Index: -1}
+	x.addEntry(beneficiaryID, reward, DirectionReward, "", block.Header.Reward)
+}
+
+// addEntry appends the entry to the history of given account. Entries of the
+// same account within a single block are numbered to build unique cursors.
+func (x *txIndex) addEntry(accountID AccountID, entry AccountTx, direction string, counterparty AccountID, amount uint64) {
+	entries := x.accounts[accountID]
+
+	if n := len(entries); n > 0 && entries[n-1].BlockHeight == entry.BlockHeight {
+		entry.seq = entries[n-1].seq + 1
+	}
+	entry.Direction = direction
+	entry.Counterparty = counterparty
+	entry.Amount = amount
+
+	x.accounts[accountID] = append(entries, entry)
+}
+
+// prepend moves all entries of the older index in front of the current ones.
+func (x *txIndex) prepend(older *txIndex) {
+	for hash, receipt := range older.receipts {
+		x.receipts[hash] = receipt
+	}
+	for accountID, entries := range older.accounts {
+		x.accounts[accountID] = append(entries, x.accounts[accountID]...)
+	}
+	if older.pruned > x.pruned {
+		x.pruned = older.pruned
+	}
+}
+
+// truncate drops all entries of the blocks following given height.
+func (x *txIndex) truncate(height uint64) {
+	for hash, receipt := range x.receipts {
+		if receipt.BlockHeight > height {
+			delete(x.receipts, hash)
+		}
+	}
+	for accountID, entries := range x.accounts {
+		idx := sort.Search(len(entries), func(i int) bool {
+			return entries[i].BlockHeight > height
+		})
+		if idx == 0 {
+			delete(x.accounts, accountID)
+			continue
+		}
+		x.accounts[accountID] = entries[:idx]
+	}
+	if x.pruned > height {
+		x.pruned = height
+	}
+}
+
+// private API
+
+// indexBlocks indexes the blocks which have not been replayed, because the database
+// has been restored from a snapshot. It is done on the first lookup rather than on
+// startup, so the snapshot keeps the startup fast.
+func (db *Database) indexBlocks() error {
+	db.mu.RLock()
+	unindexed := db.unindexed
+	db.mu.RUnlock()
+
+	if unindexed == 0 {
+		return nil
+	}
+
+	// Blocks are read without holding the lock, as it may take a while.
+	older := newTxIndex()
+	err := db.storage.Range(1, unindexed, func(height uint64, data BlockData) error {
+
+		// Transactions of pruned blocks are not available anymore, so lookups
+		// which might need them are reported as pruned.
+		if data.Pruned {
+			older.pruned = height
+			return nil
+		}
+
+		block, err := data.ToBlock()
+		if err != nil {
+			return err
+		}
+		older.add(block)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("index blocks err: %w", err)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Ensure the database has not been reset in the meantime.
+	if db.unindexed != unindexed {
+		return nil
+	}
+	db.index.prepend(older)
+	db.unindexed = 0
+
+	return nil
+}
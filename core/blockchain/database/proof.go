@@ -0,0 +1,40 @@
+package database
+
+import (
+	"encoding/json"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/smt"
+)
+
+// AccountProof represents the Account together with the proof of its
+// inclusion in the state root of the block with given height.
+type AccountProof struct {
+	Account   Account
+	Height    uint64
+	StateRoot string
+	Proof     smt.Proof
+}
+
+// Verify checks whether the account is included in the state root. It does not
+// require any access to the database, so it can be performed by any client.
+func (p AccountProof) Verify() bool {
+	root, err := hexutil.Decode(p.StateRoot)
+	if err != nil {
+		return false
+	}
+	return smt.Verify(root, accountKey(p.Account.ID), encodeAccount(p.Account), p.Proof)
+}
+
+// accountKey returns the position of the account in the state tree.
+func accountKey(accountID AccountID) smt.Key {
+	return smt.NewKey([]byte(accountID))
+}
+
+// encodeAccount returns the value stored for the account in the state tree.
+func encodeAccount(account Account) []byte {
+
+	// Marshaling struct of plain fields cannot fail.
+	data, _ := json.Marshal(account)
+	return data
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrBlockPruned is returned for the block which transactions have been pruned.
+// Such block can be served only by the nodes which keep the whole chain.
+var ErrBlockPruned = errors.New("block body is pruned")
+
+// PrunableStorage is implemented by the Storage which can drop transactions of the
+// old blocks, while keeping their headers.
+type PrunableStorage interface {
+
+	// Prune drops transactions of all blocks up to and including given height.
+	Prune(height uint64) error
+
+	// Pruned returns the height up to which transactions of blocks have been dropped.
+	Pruned() (uint64, error)
+}
+
+// private API
+
+// prune drops transactions of the blocks older than the prune depth. Blocks are pruned
+// only below the oldest snapshot, so the state can be always restored without them.
+func (db *Database) prune() error {
+	storage, ok := db.storage.(PrunableStorage)
+	if !ok || db.pruneDepth == 0 {
+		return nil
+	}
+
+	lastHeight := db.LastBlock().Height()
+	if lastHeight <= db.pruneDepth {
+		return nil
+	}
+	height := lastHeight - db.pruneDepth
+
+	oldest, ok, err := db.oldestSnapshot()
+	if err != nil || !ok {
+		return err
+	}
+	if height >= oldest {
+		height = oldest - 1
+	}
+
+	pruned, err := storage.Pruned()
+	if err != nil {
+		return fmt.Errorf("read pruned height err: %w", err)
+	}
+	if height <= pruned {
+		return nil
+	}
+
+	if err = storage.Prune(height); err != nil {
+		return fmt.Errorf("prune err: %w", err)
+	}
+
+	return nil
+}
+
+// checkPrunedHeight ensures the state at given height can be restored, which needs
+// a snapshot taken at the height or below it for the block which is not pruned.
+func (db *Database) checkPrunedHeight(height uint64) error {
+	storage, ok := db.storage.(PrunableStorage)
+	if !ok {
+		return nil
+	}
+
+	pruned, err := storage.Pruned()
+	if err != nil {
+		return fmt.Errorf("read pruned height err: %w", err)
+	}
+	if pruned == 0 {
+		return nil
+	}
+
+	if snapshots, ok := db.storage.(SnapshotStorage); ok {
+		heights, err := snapshots.Snapshots()
+		if err != nil {
+			return fmt.Errorf("list snapshots err: %w", err)
+		}
+		for _, h := range heights {
+			if h > pruned && h <= height {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("state at height: %d cannot be restored, blocks up to height: %d are pruned", height, pruned)
+}
+
+// readHeader reads the header of the block, which is available for pruned blocks as well.
+func (db *Database) readHeader(height uint64) (BlockHeader, error) {
+	data, err := db.storage.Read(height)
+	if err != nil {
+		return BlockHeader{}, fmt.Errorf("read block err: %w", err)
+	}
+	return data.Header, nil
+}
@@ -0,0 +1,85 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+)
+
+func TestDatabase_Prune(t *testing.T) {
+	storage := mockMemory(t)
+
+	// Pruning requires snapshots to be enabled
+	_, err := database.New(database.Config{Genesis: mockGenesis(), Storage: storage, PruneDepth: 1})
+	assert.EqualError(t, err, "pruning requires snapshots to be enabled")
+
+	db, err := database.New(database.Config{Genesis: mockGenesis(), Storage: storage, SnapshotInterval: 2, PruneDepth: 1})
+	assert.Nil(t, err)
+
+	var txHashes []string
+	for nonce := uint64(1); nonce <= 8; nonce++ {
+		tx := mockBlockTx(t, nonce)
+		txHashes = append(txHashes, tx.HexHash())
+		err = db.ApplyBlock(mockBlock(t, db, tx))
+		assert.Nil(t, err)
+	}
+
+	// Ensure blocks are pruned only below the oldest snapshot
+	heights, err := storage.Snapshots()
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{4, 6, 8}, heights)
+	pruned, err := storage.Pruned()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), pruned)
+
+	// Read pruned blocks and assert err
+	_, err = db.ReadBlocks(1, 8)
+	assert.ErrorIs(t, err, database.ErrBlockPruned)
+	_, err = db.ReadBlock(3)
+	assert.EqualError(t, err, "block: 3: block body is pruned")
+	blocks, err := db.ReadBlocks(4, 8)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(blocks))
+
+	// Ensure the database is restored from the snapshot
+	restored, err := database.New(database.Config{Genesis: mockGenesis(), Storage: storage, SnapshotInterval: 2, PruneDepth: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, db.StateRoot(), restored.StateRoot())
+
+	// Receipts are available only for the blocks which are not pruned
+	_, err = restored.Receipt(txHashes[5])
+	assert.Nil(t, err)
+	_, err = restored.Receipt(txHashes[1])
+	assert.ErrorIs(t, err, database.ErrBlockPruned)
+
+	// Account history ends with the pruned blocks
+	senderID := database.AccountID("0x0ee5ba68586c85880B0900D0dEe0eEcBB37010e0")
+	txs, next, err := restored.AccountTxs(senderID, database.AccountTxQuery{Limit: 10})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(txs))
+	assert.NotEmpty(t, next)
+	_, _, err = restored.AccountTxs(senderID, database.AccountTxQuery{Limit: 10, Cursor: next})
+	assert.ErrorIs(t, err, database.ErrBlockPruned)
+
+	// History is rebuilt only from the blocks which are not pruned
+	_, err = restored.AccountsAt(5)
+	assert.Nil(t, err)
+	_, err = restored.AccountsAt(3)
+	assert.ErrorIs(t, err, database.ErrBlockPruned)
+
+	// Full replay of the pruned chain is not possible
+	_, err = database.New(database.Config{Genesis: mockGenesis(), Storage: storage, FullReplay: true, Repair: true})
+	assert.ErrorIs(t, err, database.ErrBlockPruned)
+	head, err := storage.Head()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(8), head)
+
+	// Rollback below the oldest snapshot is not possible
+	_, err = restored.Rollback(3)
+	assert.EqualError(t, err, "cannot rollback to height: 3: state at height: 3 cannot be restored, blocks up to height: 3 are pruned")
+	detached, err := restored.Rollback(5)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(detached))
+	assert.Equal(t, uint64(5), restored.LastBlock().Height())
+}
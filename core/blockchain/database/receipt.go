@@ -0,0 +1,69 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Set of receipt statuses. Block with a single failing transaction is rejected
+// as a whole, so every transaction stored in the chain is successful, while the
+// failed status is reported only for transactions which have not been mined.
+const (
+	ReceiptStatusSuccess = "success"
+	ReceiptStatusFailed  = "failed"
+)
+
+// ErrTxNotFound is returned when the transaction has not been committed to any block.
+var ErrTxNotFound = errors.New("tx not found")
+
+// Receipt represents the outcome of the transaction included in the block.
+type Receipt struct {
+	TxHash      string `json:"tx_hash"`
+	BlockHeight uint64 `json:"block_height"`
+	BlockHash   string `json:"block_hash"`
+	Index       int    `json:"index"`
+	GasUsed     uint64 `json:"gas_used"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Receipt returns the receipt of the transaction with given hash.
+func (db *Database) Receipt(hash string) (Receipt, error) {
+	if err := db.indexBlocks(); err != nil {
+		return Receipt{}, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	receipt, ok := db.index.receipts[strings.ToLower(hash)]
+	if !ok && db.index.pruned > 0 {
+		return Receipt{}, fmt.Errorf("tx: %s not found, blocks up to: %d: %w", hash, db.index.pruned, ErrBlockPruned)
+	}
+	if !ok {
+		return Receipt{}, ErrTxNotFound
+	}
+
+	return receipt, nil
+}
+
+// Tx returns the transaction with given hash together with its receipt.
+func (db *Database) Tx(hash string) (BlockTx, Receipt, error) {
+	receipt, err := db.Receipt(hash)
+	if err != nil {
+		return BlockTx{}, Receipt{}, err
+	}
+
+	block, err := db.ReadBlock(receipt.BlockHeight)
+	if err != nil {
+		return BlockTx{}, Receipt{}, err
+	}
+
+	txs := block.Tree.Values()
+	if receipt.Index >= len(txs) {
+		return BlockTx{}, Receipt{}, fmt.Errorf("tx index: %d not found in block: %d", receipt.Index, receipt.BlockHeight)
+	}
+
+	return txs[receipt.Index], receipt, nil
+}
@@ -0,0 +1,49 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+)
+
+func TestDatabase_Receipt(t *testing.T) {
+	storage := mockStorage(t)
+
+	db, err := database.New(database.Config{Genesis: mockGenesis(), Storage: storage, SnapshotInterval: 2})
+	assert.Nil(t, err)
+
+	var txs []database.BlockTx
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		tx := mockBlockTx(t, nonce)
+		err = db.ApplyBlock(mockBlock(t, db, tx))
+		assert.Nil(t, err)
+		txs = append(txs, tx)
+	}
+
+	// Ensure every applied transaction has its receipt
+	for idx, tx := range txs {
+		receipt, err := db.Receipt(tx.HexHash())
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(idx+1), receipt.BlockHeight)
+		assert.Equal(t, 0, receipt.Index)
+		assert.Equal(t, tx.GasUnits, receipt.GasUsed)
+		assert.Equal(t, database.ReceiptStatusSuccess, receipt.Status)
+
+		dbTx, _, err := db.Tx(tx.HexHash())
+		assert.Nil(t, err)
+		assert.True(t, tx.Equals(dbTx))
+	}
+
+	// Ensure unknown transaction is reported
+	_, err = db.Receipt("0x00")
+	assert.EqualError(t, err, "tx not found")
+
+	// Ensure database restored from the snapshot keeps the receipts of all blocks
+	restored, err := database.New(database.Config{Genesis: mockGenesis(), Storage: storage})
+	assert.Nil(t, err)
+
+	receipt, err := restored.Receipt(txs[0].HexHash())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), receipt.BlockHeight)
+}
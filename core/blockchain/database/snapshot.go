@@ -0,0 +1,205 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/smt"
+)
+
+// maxSnapshots defines how many of the newest snapshots are kept in the storage.
+// Keeping more than one snapshot lets the database recover from a damaged one.
+const maxSnapshots = 3
+
+// SnapshotStorage is implemented by Storage engines which are able to persist
+// snapshots of the database state next to the blocks. Snapshots are removed
+// together with the blocks when the storage is reset.
+type SnapshotStorage interface {
+	WriteSnapshot(snapshot Snapshot) error
+	ReadSnapshot(height uint64) (*Snapshot, error)
+	RemoveSnapshot(height uint64) error
+	Snapshots() ([]uint64, error)
+}
+
+// Snapshot represents the state of the database right after the block
+// with given height and hash has been applied.
+type Snapshot struct {
+	Height    uint64    `json:"height"`
+	BlockHash string    `json:"block_hash"`
+	StateRoot string    `json:"state_root"`
+	Accounts  []Account `json:"accounts"`
+}
+
+// private API
+
+// writeSnapshot persists the current state of the database when the last block
+// closes the snapshot interval. Only the newest snapshots are kept.
+func (db *Database) writeSnapshot() error {
+	storage, ok := db.storage.(SnapshotStorage)
+	if !ok || db.snapshotInterval == 0 {
+		return nil
+	}
+
+	snapshot := db.snapshot()
+	if snapshot.Height == 0 || snapshot.Height%db.snapshotInterval != 0 {
+		return nil
+	}
+
+	if err := storage.WriteSnapshot(snapshot); err != nil {
+		return fmt.Errorf("write snapshot err: %w", err)
+	}
+
+	heights, err := storage.Snapshots()
+	if err != nil {
+		return fmt.Errorf("list snapshots err: %w", err)
+	}
+	for len(heights) > maxSnapshots {
+		if err = storage.RemoveSnapshot(heights[0]); err != nil {
+			return fmt.Errorf("remove snapshot err: %w", err)
+		}
+		heights = heights[1:]
+	}
+
+	return nil
+}
+
+// removeSnapshotsFrom removes the snapshots taken for the block with given height
+// and all the following blocks, as they do not match the chain anymore.
+func (db *Database) removeSnapshotsFrom(height uint64) error {
+	storage, ok := db.storage.(SnapshotStorage)
+	if !ok {
+		return nil
+	}
+
+	heights, err := storage.Snapshots()
+	if err != nil {
+		return fmt.Errorf("list snapshots err: %w", err)
+	}
+	for _, h := range heights {
+		if h < height {
+			continue
+		}
+		if err = storage.RemoveSnapshot(h); err != nil {
+			return fmt.Errorf("remove snapshot err: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// oldestSnapshot returns the height of the oldest snapshot kept in the storage.
+// It reports whether any snapshot is kept.
+func (db *Database) oldestSnapshot() (uint64, bool, error) {
+	storage, ok := db.storage.(SnapshotStorage)
+	if !ok {
+		return 0, false, nil
+	}
+
+	heights, err := storage.Snapshots()
+	if err != nil {
+		return 0, false, fmt.Errorf("list snapshots err: %w", err)
+	}
+	if len(heights) == 0 {
+		return 0, false, nil
+	}
+
+	return heights[0], true, nil
+}
+
+// snapshot captures the current state of the database.
+func (db *Database) snapshot() Snapshot {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	accounts := make([]Account, 0, len(db.accounts))
+	for _, account := range db.accounts {
+		accounts = append(accounts, account)
+	}
+
+	// Map iteration order is random, sorting keeps snapshot files comparable.
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].ID < accounts[j].ID
+	})
+
+	return Snapshot{
+		Height:    db.lastBlock.Height(),
+		BlockHash: db.lastBlock.Hash(),
+		StateRoot: hexutil.Encode(db.tree.Root()),
+		Accounts:  accounts,
+	}
+}
+
+// loadSnapshot restores the database from the newest valid snapshot. Snapshots
+// which do not match the stored blocks are skipped. It reports whether any
+// snapshot has been loaded.
+func (db *Database) loadSnapshot() bool {
+	storage, ok := db.storage.(SnapshotStorage)
+	if !ok {
+		return false
+	}
+
+	heights, err := storage.Snapshots()
+	if err != nil {
+		return false
+	}
+
+	for i := len(heights) - 1; i >= 0; i-- {
+		snapshot, err := storage.ReadSnapshot(heights[i])
+		if err != nil {
+			continue
+		}
+		if err = db.restoreSnapshot(*snapshot); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// restoreSnapshot verifies given Snapshot against the stored block and makes
+// it the current state of the database.
+func (db *Database) restoreSnapshot(snapshot Snapshot) error {
+	block, accounts, tree, err := db.verifySnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.accounts = accounts
+	db.tree = tree
+	db.history = newHistory(snapshot.Height, accounts)
+	db.lastBlock = block
+
+	return nil
+}
+
+// verifySnapshot ensures given Snapshot matches the stored block. It returns the block
+// together with the accounts and the state tree restored from the snapshot.
+func (db *Database) verifySnapshot(snapshot Snapshot) (Block, Accounts, *smt.Tree, error) {
+	block, err := db.ReadBlock(snapshot.Height)
+	if err != nil {
+		return Block{}, nil, nil, err
+	}
+	if block.Hash() != snapshot.BlockHash {
+		return Block{}, nil, nil, errors.New("snapshot block hash does not match the stored block")
+	}
+
+	accounts := make(Accounts, len(snapshot.Accounts))
+	tree := smt.New()
+	for _, account := range snapshot.Accounts {
+		accounts[account.ID] = account
+		tree.Update(accountKey(account.ID), encodeAccount(account))
+	}
+
+	// State root proves the accounts are exactly what the block committed to.
+	stateRoot := hexutil.Encode(tree.Root())
+	if stateRoot != snapshot.StateRoot || stateRoot != block.Header.StateRoot {
+		return Block{}, nil, nil, errors.New("snapshot state root does not match the stored block")
+	}
+
+	return block, accounts, tree, nil
+}
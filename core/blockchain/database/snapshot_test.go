@@ -0,0 +1,118 @@
+package database_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/storage/memory"
+)
+
+func TestDatabase_Snapshot(t *testing.T) {
+	storage := mockTrackingStorage(t)
+
+	db, err := database.New(database.Config{Genesis: mockGenesis(), Storage: storage, SnapshotInterval: 2})
+	assert.Nil(t, err)
+
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		err = db.ApplyBlock(mockBlock(t, db, mockBlockTx(t, nonce)))
+		assert.Nil(t, err)
+	}
+
+	// Ensure snapshot is persisted only for the block closing the interval
+	heights, err := storage.Snapshots()
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{2}, heights)
+
+	// Ensure the database restored from the snapshot replays only the following blocks
+	storage.reads = nil
+	restored, err := database.New(database.Config{Genesis: mockGenesis(), Storage: storage})
+	assert.Nil(t, err)
+	assert.NotContains(t, storage.reads, uint64(1))
+	assert.Equal(t, db.LastBlock().Hash(), restored.LastBlock().Hash())
+	assert.Equal(t, db.Accounts(), restored.Accounts())
+	assert.Equal(t, db.StateRoot(), restored.StateRoot())
+
+	// Ensure full replay ignores the snapshot
+	storage.reads = nil
+	replayed, err := database.New(database.Config{Genesis: mockGenesis(), Storage: storage, FullReplay: true})
+	assert.Nil(t, err)
+	assert.Contains(t, storage.reads, uint64(1))
+	assert.Equal(t, db.Accounts(), replayed.Accounts())
+
+	// Ensure snapshot which does not match the block state root is ignored
+	snapshot, err := storage.ReadSnapshot(2)
+	assert.Nil(t, err)
+	snapshot.Accounts[0].Balance++
+	err = storage.WriteSnapshot(*snapshot)
+	assert.Nil(t, err)
+
+	storage.reads = nil
+	restored, err = database.New(database.Config{Genesis: mockGenesis(), Storage: storage})
+	assert.Nil(t, err)
+	assert.Contains(t, storage.reads, uint64(1))
+	assert.Equal(t, db.Accounts(), restored.Accounts())
+
+	// Ensure rollback removes the snapshots of the detached blocks
+	_, err = restored.Rollback(1)
+	assert.Nil(t, err)
+	heights, err = storage.Snapshots()
+	assert.Nil(t, err)
+	assert.Empty(t, heights)
+}
+
+func TestDatabase_SnapshotFailure(t *testing.T) {
+	storage := &failingSnapshotStorage{Memory: mockMemory(t)}
+
+	var events []string
+	db, err := database.New(database.Config{
+		Genesis:          mockGenesis(),
+		Storage:          storage,
+		SnapshotInterval: 1,
+		EventHandler: func(s string, args ...any) {
+			events = append(events, fmt.Sprintf(s, args...))
+		},
+	})
+	assert.Nil(t, err)
+
+	// Ensure the block is applied, while the snapshot failure is reported
+	err = db.ApplyBlock(mockBlock(t, db, mockBlockTx(t, 1)))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), db.LastBlock().Height())
+	assert.Equal(t, []string{"[DB][ApplyBlock][Writing snapshot at: 1 failed: write snapshot err: disk full]"}, events)
+}
+
+// Helper functions
+
+// failingSnapshotStorage fails to write every snapshot.
+type failingSnapshotStorage struct {
+	*memory.Memory
+}
+
+func (s *failingSnapshotStorage) WriteSnapshot(_ database.Snapshot) error {
+	return errors.New("disk full")
+}
+
+// trackingStorage records the heights of all blocks read from the storage.
+type trackingStorage struct {
+	*memory.Memory
+	reads []uint64
+}
+
+func (s *trackingStorage) Read(height uint64) (*database.BlockData, error) {
+	s.reads = append(s.reads, height)
+	return s.Memory.Read(height)
+}
+
+func (s *trackingStorage) Range(from, to uint64, fn func(height uint64, data database.BlockData) error) error {
+	return s.Memory.Range(from, to, func(height uint64, data database.BlockData) error {
+		s.reads = append(s.reads, height)
+		return fn(height, data)
+	})
+}
+
+func mockTrackingStorage(t *testing.T) *trackingStorage {
+	return &trackingStorage{Memory: mockMemory(t)}
+}
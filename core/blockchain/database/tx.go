@@ -6,12 +6,16 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"math/bits"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/tchorzewski1991/fitbit/core/blockchain/signature"
 )
 
+// errCostOverflow is returned when the cost of the transaction does not fit uint64.
+var errCostOverflow = errors.New("tx invalid, cost overflow")
+
 // Tx represents transactional change between two accounts.
 type Tx struct {
 	ChainID uint16    `json:"chain_id"`
@@ -110,11 +114,40 @@ func NewBlockTx(tx SignedTx, gasPrice, gasUnits uint64) BlockTx {
 	}
 }
 
+// Fee returns the tip together with the gas fee paid to the beneficiary.
+func (tx BlockTx) Fee() (uint64, error) {
+	gasHi, gasFee := bits.Mul64(tx.GasPrice, tx.GasUnits)
+	fee, carry := bits.Add64(gasFee, tx.Tip, 0)
+	if gasHi != 0 || carry != 0 {
+		return 0, errCostOverflow
+	}
+	return fee, nil
+}
+
+// Cost returns the total amount charged from the sender, which is the value
+// of the transaction together with its fee.
+func (tx BlockTx) Cost() (uint64, error) {
+	fee, err := tx.Fee()
+	if err != nil {
+		return 0, err
+	}
+	cost, carry := bits.Add64(fee, tx.Value, 0)
+	if carry != 0 {
+		return 0, errCostOverflow
+	}
+	return cost, nil
+}
+
 func (tx BlockTx) Hash() ([]byte, error) {
 	hash := signature.Hash(tx)
 	return hexutil.Decode(hash)
 }
 
+// HexHash returns the 0x prefixed hex encoded hash, which identifies the transaction.
+func (tx BlockTx) HexHash() string {
+	return signature.Hash(tx)
+}
+
 func (tx BlockTx) Equals(other BlockTx) bool {
 	if tx.Nonce != other.Nonce {
 		return false
@@ -8,27 +8,61 @@ import (
 	"github.com/goccy/go-json"
 )
 
+// Genesis represents the initial settings of the blockchain.
+//   - Difficulty is the number of leading zeros required from the hash of the first block
+//   - RetargetWindow is the number of blocks after which the target is recalculated
+//   - BlockTime is the expected time in seconds between two consecutive blocks
 type Genesis struct {
-	Date         time.Time         `json:"date"`
-	ChainID      uint16            `json:"chain_id"`
-	TxPerBlock   uint16            `json:"tx_per_block"`
-	Difficulty   uint16            `json:"difficulty"`
-	MiningReward uint64            `json:"mining_reward"`
-	GasPrice     uint64            `json:"gas_price"`
-	Balances     map[string]uint64 `json:"balances"`
+	Date           time.Time         `json:"date"`
+	ChainID        uint16            `json:"chain_id"`
+	TxPerBlock     uint16            `json:"tx_per_block"`
+	Difficulty     uint16            `json:"difficulty"`
+	RetargetWindow uint64            `json:"retarget_window"`
+	BlockTime      uint64            `json:"block_time"`
+	MiningReward   uint64            `json:"mining_reward"`
+	GasPrice       uint64            `json:"gas_price"`
+	Balances       map[string]uint64 `json:"balances"`
 }
 
+// DefaultPath is the location of the genesis file used by the node.
+const DefaultPath = "data/genesis.json"
+
+// MaxDifficulty is the highest difficulty, as the hash has 64 hex encoded digits.
+const MaxDifficulty = 64
+
+// Load reads the genesis file from the default location.
 func Load() (Genesis, error) {
-	f, err := os.Open("data/genesis.json")
+	return LoadFile(DefaultPath)
+}
+
+// LoadFile reads the genesis file from given location.
+func LoadFile(path string) (Genesis, error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return Genesis{}, fmt.Errorf("failed to open genesis file: %w", err)
 	}
 
+	defer func() {
+		_ = f.Close()
+	}()
+
 	var gen Genesis
 	err = json.NewDecoder(f).Decode(&gen)
 	if err != nil {
 		return Genesis{}, fmt.Errorf("failed to encode genesis file: %w", err)
 	}
+	if gen.Difficulty > MaxDifficulty {
+		return Genesis{}, fmt.Errorf("genesis difficulty: %d exceeds the maximum: %d", gen.Difficulty, MaxDifficulty)
+	}
 
 	return gen, nil
 }
+
+// SaveFile writes the genesis file to given location.
+func SaveFile(path string, gen Genesis) error {
+	bs, err := json.MarshalIndent(gen, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode genesis file: %w", err)
+	}
+	return os.WriteFile(path, bs, 0600)
+}
@@ -0,0 +1,187 @@
+package mempool
+
+import (
+	"strings"
+	"time"
+
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+)
+
+// maxEvictions defines how many of the most recent evictions are kept around.
+const maxEvictions = 1000
+
+// EvictReason describes why the transaction has been evicted from Mempool.
+type EvictReason string
+
+// Set of possible eviction reasons.
+const (
+	EvictCapacity EvictReason = "capacity"
+	EvictExpired  EvictReason = "expired"
+	EvictReplaced EvictReason = "replaced"
+	EvictStale    EvictReason = "stale"
+	EvictInvalid  EvictReason = "invalid"
+)
+
+// Eviction describes the transaction evicted from Mempool. Error is only set
+// for transactions evicted as invalid.
+type Eviction struct {
+	Tx        database.BlockTx
+	Hash      string
+	Reason    EvictReason
+	Error     string
+	EvictedAt time.Time
+}
+
+// EvictHandler allows for reacting to the transactions evicted from Mempool.
+type EvictHandler func(e Eviction)
+
+// Expire evicts all transactions which have been waiting in Mempool longer than
+// the configured time-to-live. It returns the number of evicted transactions.
+func (m *Mempool) Expire(now time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.expire(now)
+}
+
+// Discard evicts the transaction which turned out to be invalid, together with
+// the error which made it invalid.
+func (m *Mempool) Discard(tx database.BlockTx, cause error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.get(prepareKey(tx)); !ok {
+		return
+	}
+
+	m.evict(Eviction{Tx: tx, Reason: EvictInvalid, Error: cause.Error(), EvictedAt: time.Now()})
+}
+
+// Evictions returns a copy of the most recent evictions, starting with the latest one.
+func (m *Mempool) Evictions() []Eviction {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	evictions := make([]Eviction, len(m.evictions))
+	for i, e := range m.evictions {
+		evictions[len(m.evictions)-1-i] = e
+	}
+	return evictions
+}
+
+// FindEviction returns the most recent eviction of the transaction by given hash.
+func (m *Mempool) FindEviction(hash string) (Eviction, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hash = strings.ToLower(hash)
+	for i := len(m.evictions) - 1; i >= 0; i-- {
+		if m.evictions[i].Hash == hash {
+			return m.evictions[i], true
+		}
+	}
+	return Eviction{}, false
+}
+
+// private API
+
+func (m *Mempool) expire(now time.Time) int {
+	if m.ttl == 0 {
+		return 0
+	}
+
+	var expired []database.BlockTx
+	for key, arrival := range m.arrivals {
+		if now.Sub(arrival) < m.ttl {
+			continue
+		}
+		if tx, ok := m.get(key); ok {
+			expired = append(expired, tx)
+		}
+	}
+
+	for _, tx := range expired {
+		m.evict(Eviction{Tx: tx, Reason: EvictExpired, EvictedAt: now})
+	}
+
+	return len(expired)
+}
+
+// makeRoom ensures there is a space for the new transaction, once Mempool reaches its
+// capacity. Expired transactions go first, then the one with the lowest priority is
+// evicted, as long as the new transaction has higher priority.
+func (m *Mempool) makeRoom(tx database.BlockTx) error {
+	if m.capacity == 0 || len(m.pending)+len(m.queued) < m.capacity {
+		return nil
+	}
+
+	now := time.Now()
+	if m.expire(now) > 0 && len(m.pending)+len(m.queued) < m.capacity {
+		return nil
+	}
+
+	victim, ok := m.evictionCandidate(tx.From)
+	if !ok || !hasHigherPriority(tx, victim) {
+		return ErrMempoolFull
+	}
+	m.evict(Eviction{Tx: victim, Reason: EvictCapacity, EvictedAt: now})
+
+	return nil
+}
+
+// evictionCandidate returns the transaction with the lowest priority out of the last
+// transactions of every account, so evicting it never leaves a nonce gap behind.
+// Transactions of the given account are not considered.
+func (m *Mempool) evictionCandidate(except database.AccountID) (database.BlockTx, bool) {
+	last := make(map[database.AccountID]database.BlockTx)
+	for _, pool := range []map[string]database.BlockTx{m.pending, m.queued} {
+		for _, tx := range pool {
+			if tx.From == except {
+				continue
+			}
+			if other, ok := last[tx.From]; !ok || tx.Nonce > other.Nonce {
+				last[tx.From] = tx
+			}
+		}
+	}
+
+	var victim database.BlockTx
+	var found bool
+	for _, tx := range last {
+		if !found || hasHigherPriority(victim, tx) {
+			victim = tx
+			found = true
+		}
+	}
+
+	return victim, found
+}
+
+// evict removes the transaction from Mempool and records the eviction. Pending
+// transactions of the same account which follow the evicted one are moved back
+// to the queue, since they cannot be executed anymore.
+func (m *Mempool) evict(e Eviction) {
+	key := prepareKey(e.Tx)
+	e.Hash = m.hashes[key]
+	m.drop(key)
+
+	for k, pending := range m.pending {
+		if pending.From == e.Tx.From && pending.Nonce > e.Tx.Nonce {
+			m.queued[k] = pending
+			delete(m.pending, k)
+		}
+	}
+
+	m.record(e)
+}
+
+// record appends the eviction to the log, dropping the oldest ones, and
+// passes it to the evict handler.
+func (m *Mempool) record(e Eviction) {
+	m.evictions = append(m.evictions, e)
+	if len(m.evictions) > maxEvictions {
+		m.evictions = m.evictions[len(m.evictions)-maxEvictions:]
+	}
+
+	m.onEvict(e)
+}
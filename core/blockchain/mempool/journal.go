@@ -0,0 +1,235 @@
+package mempool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+)
+
+// journalHeaderSize is the size of the record header keeping the length
+// and the checksum of the record payload.
+const journalHeaderSize = 8
+
+// journalArrivalSize is the size of the arrival time preceding the encoded
+// transaction in the record payload.
+const journalArrivalSize = 8
+
+// maxJournalRecord bounds the length prefix of the journal record. A single transaction
+// takes a few hundred bytes, so a longer record means the journal is damaged and the
+// replay stops there.
+const maxJournalRecord = 1 << 20
+
+// Journal persists transactions accepted by Mempool on the disk, so they survive
+// the restart of the node. Transactions are only appended to the journal, while
+// the stale ones are dropped whenever the journal is rotated.
+//
+// Every record consists of the big endian encoded length and CRC32 checksum of the
+// payload, followed by the payload itself: the arrival time in Unix nanoseconds and
+// the transaction encoded with the database binary codec.
+type Journal struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// JournalEntry represents the journaled transaction together with the time it
+// arrived at Mempool, so the transaction expires on time after the restart.
+type JournalEntry struct {
+	Tx      database.BlockTx
+	Arrival time.Time
+}
+
+// OpenJournal constructs a new Journal kept in the file under given path.
+// Transactions are not appended until Load or Rotate is called.
+func OpenJournal(filePath string) (*Journal, error) {
+	if err := os.MkdirAll(path.Dir(filePath), 0755); err != nil {
+		return nil, err
+	}
+	return &Journal{path: filePath}, nil
+}
+
+// Load reads all entries from the journal and passes them to fn. Reading stops
+// at the first damaged record, which is the expected result of a crash during the
+// write. It returns the number of entries read.
+func (j *Journal) Load(fn func(entry JournalEntry) error) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	f, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+
+	r := bufio.NewReader(f)
+
+	var n int
+	for {
+		entry, err := readJournalRecord(r)
+		if err != nil {
+			return n, nil
+		}
+		n++
+		if err = fn(entry); err != nil {
+			return n, err
+		}
+	}
+}
+
+// Append writes the entry at the end of the journal.
+func (j *Journal) Append(entry JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil {
+		return errors.New("journal is not open for writing")
+	}
+
+	record, err := encodeJournalRecord(entry)
+	if err != nil {
+		return err
+	}
+	if _, err = j.f.Write(record); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// Rotate replaces the content of the journal with the entries returned by snapshot,
+// which drops the transactions which have been mined or evicted in the meantime. Snapshot
+// is taken while holding the lock, so any transaction accepted after it is appended to
+// the rotated journal.
+func (j *Journal) Rotate(snapshot func() []JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entries := snapshot()
+
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, entry := range entries {
+		record, err := encodeJournalRecord(entry)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+		if _, err = w.Write(record); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	if j.f != nil {
+		_ = j.f.Close()
+		j.f = nil
+	}
+	if err = os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+
+	// Ensure the rename itself survives the crash.
+	dir, err := os.Open(path.Dir(j.path))
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	_ = dir.Close()
+	if err != nil {
+		return err
+	}
+
+	j.f, err = os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0600)
+	return err
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+// private API
+
+func encodeJournalRecord(entry JournalEntry) ([]byte, error) {
+	bs, err := entry.Tx.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, journalArrivalSize+len(bs))
+	binary.BigEndian.PutUint64(payload[0:journalArrivalSize], uint64(entry.Arrival.UnixNano()))
+	copy(payload[journalArrivalSize:], bs)
+
+	record := make([]byte, journalHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[journalHeaderSize:], payload)
+
+	return record, nil
+}
+
+func readJournalRecord(r io.Reader) (JournalEntry, error) {
+	header := make([]byte, journalHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return JournalEntry{}, err
+	}
+
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size > maxJournalRecord {
+		return JournalEntry{}, fmt.Errorf("journal record too large: %d", size)
+	}
+	if size < journalArrivalSize {
+		return JournalEntry{}, fmt.Errorf("journal record too short: %d", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return JournalEntry{}, err
+	}
+	if binary.BigEndian.Uint32(header[4:8]) != crc32.ChecksumIEEE(payload) {
+		return JournalEntry{}, errors.New("journal record checksum mismatch")
+	}
+
+	var tx database.BlockTx
+	if err := tx.UnmarshalBinary(payload[journalArrivalSize:]); err != nil {
+		return JournalEntry{}, err
+	}
+	arrival := time.Unix(0, int64(binary.BigEndian.Uint64(payload[0:journalArrivalSize])))
+
+	return JournalEntry{Tx: tx, Arrival: arrival}, nil
+}
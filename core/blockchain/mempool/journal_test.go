@@ -0,0 +1,102 @@
+package mempool_test
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/mempool"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/testdata"
+)
+
+func TestJournal(t *testing.T) {
+	// Setup test data
+	priv := testdata.LoadPrivateKey(t)
+	from, err := database.PubToAccountID(priv.PublicKey)
+	assert.Nil(t, err)
+
+	to, err := database.ToAccountID("0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0")
+	assert.Nil(t, err)
+
+	arrival := time.Unix(0, time.Now().UnixNano())
+	tx1 := mempool.JournalEntry{Tx: prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 1, tip: 10}), Arrival: arrival}
+	tx2 := mempool.JournalEntry{Tx: prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 2, tip: 10}), Arrival: arrival}
+	tx3 := mempool.JournalEntry{Tx: prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 3, tip: 10}), Arrival: arrival.Add(time.Second)}
+
+	journalPath := path.Join(t.TempDir(), "mempool", "journal")
+
+	// Run test
+
+	// Load missing journal
+	j, err := mempool.OpenJournal(journalPath)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(loadJournal(t, j)))
+
+	// Append is not possible before the journal is rotated
+	err = j.Append(tx1)
+	assert.NotNil(t, err)
+
+	// Rotate the journal and append txs
+	err = j.Rotate(snapshot(tx1))
+	assert.Nil(t, err)
+	err = j.Append(tx2)
+	assert.Nil(t, err)
+	err = j.Append(tx3)
+	assert.Nil(t, err)
+	err = j.Close()
+	assert.Nil(t, err)
+
+	// Ensure txs are loaded together with their arrival times
+	txs := loadJournal(t, j)
+	assert.Equal(t, 3, len(txs))
+	assert.Equal(t, tx1, txs[0])
+	assert.Equal(t, tx3, txs[2])
+
+	// Damage the last record and ensure the preceding ones are still loaded
+	info, err := os.Stat(journalPath)
+	assert.Nil(t, err)
+	err = os.Truncate(journalPath, info.Size()-5)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(loadJournal(t, j)))
+
+	// Rotate the journal to drop stale txs
+	err = j.Rotate(snapshot(tx3))
+	assert.Nil(t, err)
+	txs = loadJournal(t, j)
+	assert.Equal(t, 1, len(txs))
+	assert.Equal(t, tx3, txs[0])
+
+	// Append tx accepted while the journal is rotated and ensure it is not lost
+	appended := make(chan error)
+	err = j.Rotate(func() []mempool.JournalEntry {
+		go func() { appended <- j.Append(tx1) }()
+		return []mempool.JournalEntry{tx3}
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, <-appended)
+	txs = loadJournal(t, j)
+	assert.Equal(t, []mempool.JournalEntry{tx3, tx1}, txs)
+	assert.Nil(t, j.Close())
+}
+
+// Helper functions
+
+func loadJournal(t *testing.T, j *mempool.Journal) []mempool.JournalEntry {
+	var txs []mempool.JournalEntry
+	n, err := j.Load(func(entry mempool.JournalEntry) error {
+		txs = append(txs, entry)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, len(txs), n)
+	return txs
+}
+
+func snapshot(txs ...mempool.JournalEntry) func() []mempool.JournalEntry {
+	return func() []mempool.JournalEntry {
+		return txs
+	}
+}
@@ -1,23 +1,145 @@
 package mempool
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
+	"math/bits"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
 )
 
-// Mempool represents the cache for waiting transactions.
+// Set of errors returned when the transaction is not admitted to Mempool.
+var (
+	// ErrUnderpriced is returned when a transaction attempts to replace a pending
+	// transaction with the same nonce without paying high enough tip.
+	ErrUnderpriced = errors.New("replacement tx underpriced")
+
+	// ErrNonceTooLow is returned when the nonce of the transaction has been already
+	// used by the sender account.
+	ErrNonceTooLow = errors.New("tx nonce too low")
+
+	// ErrInsufficientFunds is returned when the sender account cannot cover the costs
+	// of all its transactions waiting in Mempool.
+	ErrInsufficientFunds = errors.New("insufficient funds")
+
+	// ErrAccountLimit is returned when the sender account has reached the limit
+	// of transactions waiting in Mempool.
+	ErrAccountLimit = errors.New("account tx limit reached")
+
+	// ErrMempoolFull is returned when Mempool has reached its capacity and the
+	// transaction does not pay more than the transactions it could evict.
+	ErrMempoolFull = errors.New("mempool is full")
+
+	// ErrExpired is returned when the transaction arrived longer than TTL ago.
+	ErrExpired = errors.New("tx expired")
+)
+
+// Outcome describes what happened to the transaction upserted to Mempool.
+type Outcome int
+
+// Set of possible upsert outcomes.
+const (
+	Rejected Outcome = iota
+	Added
+	Replaced
+	Known
+)
+
+// String returns the name of the outcome.
+func (o Outcome) String() string {
+	switch o {
+	case Rejected:
+		return "rejected"
+	case Added:
+		return "added"
+	case Replaced:
+		return "replaced"
+	case Known:
+		return "known"
+	default:
+		return fmt.Sprintf("outcome(%d)", int(o))
+	}
+}
+
+// Config keeps the settings of Mempool.
+type Config struct {
+
+	// PriceBump is the minimum tip increase, in percent, required to replace
+	// a pending transaction with the same nonce.
+	PriceBump uint64
+
+	// Capacity is the maximum number of transactions kept by Mempool.
+	// Zero means no limit.
+	Capacity int
+
+	// AccountLimit is the maximum number of transactions of a single sender
+	// account kept by Mempool. Zero means no limit.
+	AccountLimit int
+
+	// TTL is the time after which transactions which have not been mined are
+	// dropped from Mempool. Zero means transactions never expire.
+	TTL time.Duration
+
+	// EvictHandler is called for every evicted transaction. It is called while
+	// Mempool is locked, so it must not call Mempool back.
+	EvictHandler EvictHandler
+}
+
+// Pool identifies the sub-pool of Mempool keeping the transaction.
+type Pool string
+
+// Set of Mempool sub-pools.
+const (
+	PoolPending Pool = "pending"
+	PoolQueued  Pool = "queued"
+)
+
+// Stats describes the number of transactions kept by Mempool.
+type Stats struct {
+	Pending int `json:"pending"`
+	Queued  int `json:"queued"`
+}
+
+// Mempool represents the cache for waiting transactions. Transactions which can be
+// executed right away are kept as pending, while the ones waiting for the transactions
+// with lower nonces of the same account are kept as queued.
 type Mempool struct {
-	mu   sync.RWMutex
-	pool map[string]database.BlockTx
+	mu        sync.RWMutex
+	pending   map[string]database.BlockTx
+	queued    map[string]database.BlockTx
+	arrivals  map[string]time.Time
+	hashes    map[string]string
+	evictions []Eviction
+
+	priceBump    uint64
+	capacity     int
+	accountLimit int
+	ttl          time.Duration
+	onEvict      EvictHandler
 }
 
 // New constructs a new Mempool.
-func New() *Mempool {
+func New(cfg Config) *Mempool {
+	if cfg.EvictHandler == nil {
+		// Set no-op evict handler if evict handler has not been set.
+		cfg.EvictHandler = func(e Eviction) {}
+	}
+
 	return &Mempool{
-		pool: make(map[string]database.BlockTx),
+		pending:      make(map[string]database.BlockTx),
+		queued:       make(map[string]database.BlockTx),
+		arrivals:     make(map[string]time.Time),
+		hashes:       make(map[string]string),
+		priceBump:    cfg.PriceBump,
+		capacity:     cfg.Capacity,
+		accountLimit: cfg.AccountLimit,
+		ttl:          cfg.TTL,
+		onEvict:      cfg.EvictHandler,
 	}
 }
 
@@ -25,19 +147,87 @@ func New() *Mempool {
 func (m *Mempool) Size() int {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return len(m.pool)
+	return len(m.pending) + len(m.queued)
+}
+
+// Stats returns the current number of pending and queued transactions in the Mempool.
+func (m *Mempool) Stats() Stats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return Stats{
+		Pending: len(m.pending),
+		Queued:  len(m.queued),
+	}
+}
+
+// Upsert adds a new transaction to Mempool after validating it against the current
+// state of the sender account. Transaction with the same nonce as the waiting one
+// replaces it only when its tip is higher by the price bump.
+func (m *Mempool) Upsert(tx database.BlockTx, account database.Account) (Outcome, error) {
+	return m.UpsertAt(tx, account, time.Now())
 }
 
-// Upsert adds a new transaction to Mempool.
-func (m *Mempool) Upsert(tx database.BlockTx) error {
+// UpsertAt works like Upsert, but the transaction is considered to arrive at given
+// time, which lets restored transactions keep their original arrival time. Transaction
+// which has already outlived TTL is rejected.
+func (m *Mempool) UpsertAt(tx database.BlockTx, account database.Account, arrival time.Time) (Outcome, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if m.ttl > 0 && time.Since(arrival) >= m.ttl {
+		return Rejected, fmt.Errorf("%w: arrived at: %s", ErrExpired, arrival.Format(time.RFC3339))
+	}
+
+	if tx.Nonce <= account.Nonce {
+		return Rejected, fmt.Errorf("%w: %d, account nonce: %d", ErrNonceTooLow, tx.Nonce, account.Nonce)
+	}
+
 	key := prepareKey(tx)
 
-	m.pool[key] = tx
+	outcome := Added
+	if waiting, ok := m.get(key); ok {
+		if waiting.Equals(tx) {
+			return Known, nil
+		}
+		required, ok := m.requiredTip(waiting)
+		if !ok {
+			return Rejected, fmt.Errorf("%w: tip: %d cannot be bumped any further", ErrUnderpriced, waiting.Tip)
+		}
+		if tx.Tip < required {
+			return Rejected, fmt.Errorf("%w: tip: %d, required: %d", ErrUnderpriced, tx.Tip, required)
+		}
+		outcome = Replaced
+	}
+
+	if outcome == Added && m.accountLimit > 0 && m.count(tx.From) >= m.accountLimit {
+		return Rejected, fmt.Errorf("%w: %d", ErrAccountLimit, m.accountLimit)
+	}
+
+	if err := m.checkFunds(tx, account); err != nil {
+		return Rejected, err
+	}
 
-	return nil
+	if outcome == Added {
+		if err := m.makeRoom(tx); err != nil {
+			return Rejected, err
+		}
+	}
+
+	if outcome == Replaced {
+		waiting, _ := m.get(key)
+		m.record(Eviction{Tx: waiting, Hash: m.hashes[key], Reason: EvictReplaced, EvictedAt: time.Now()})
+	}
+
+	if _, ok := m.pending[key]; ok {
+		m.pending[key] = tx
+	} else {
+		m.queued[key] = tx
+	}
+	m.arrivals[key] = arrival
+	m.hashes[key] = tx.HexHash()
+	m.promote(tx.From, account.Nonce)
+
+	return outcome, nil
 }
 
 // Remove deletes a transaction from Mempool.
@@ -45,13 +235,86 @@ func (m *Mempool) Remove(tx database.BlockTx) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	key := prepareKey(tx)
-
-	delete(m.pool, key)
+	m.drop(prepareKey(tx))
 
 	return nil
 }
 
+// Update reconciles Mempool with the current state of the accounts. Transactions with
+// already used nonces are evicted as stale, pending transactions following a nonce gap are moved
+// back to the queue and queued transactions which gaps have been filled are promoted.
+func (m *Mempool) Update(accounts database.Accounts) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Move all transactions to the queue, so they are classified from scratch.
+	for key, tx := range m.pending {
+		m.queued[key] = tx
+		delete(m.pending, key)
+	}
+
+	now := time.Now()
+	senders := make(map[database.AccountID]struct{})
+	for _, tx := range m.queued {
+		if tx.Nonce <= accounts[tx.From].Nonce {
+			m.evict(Eviction{Tx: tx, Reason: EvictStale, EvictedAt: now})
+			continue
+		}
+		senders[tx.From] = struct{}{}
+	}
+
+	for from := range senders {
+		m.promote(from, accounts[from].Nonce)
+	}
+}
+
+// NextNonce returns the next nonce the account can use, which follows the account
+// nonce and all transactions of the account waiting in Mempool without a gap.
+func (m *Mempool) NextNonce(account database.Account) uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	next := account.Nonce + 1
+	for {
+		if _, ok := m.get(accountKey(account.ID, next)); !ok {
+			return next
+		}
+		next++
+	}
+}
+
+// Find returns a copy of the waiting transaction by given hash together with
+// the sub-pool keeping it.
+func (m *Mempool) Find(hash string) (database.BlockTx, Pool, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hash = strings.ToLower(hash)
+	for key, h := range m.hashes {
+		if h != hash {
+			continue
+		}
+		if tx, ok := m.pending[key]; ok {
+			return tx, PoolPending, true
+		}
+		if tx, ok := m.queued[key]; ok {
+			return tx, PoolQueued, true
+		}
+	}
+
+	return database.BlockTx{}, "", false
+}
+
+// HashOf returns the hash of the transaction waiting in Mempool with the same
+// sender and nonce as given transaction.
+func (m *Mempool) HashOf(tx database.BlockTx) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hash, ok := m.hashes[prepareKey(tx)]
+	return hash, ok
+}
+
 // Select returns a copy of all transactions from Mempool.
 func (m *Mempool) Select(filter SelectFunc) []database.BlockTx {
 	m.mu.RLock()
@@ -59,26 +322,214 @@ func (m *Mempool) Select(filter SelectFunc) []database.BlockTx {
 
 	var txs []database.BlockTx
 
-	for _, tx := range m.pool {
-		if filter(tx) {
-			txs = append(txs, tx)
+	for _, pool := range []map[string]database.BlockTx{m.pending, m.queued} {
+		for _, tx := range pool {
+			if filter(tx) {
+				txs = append(txs, tx)
+			}
+		}
+	}
+
+	sort.Sort(byNonce(txs))
+
+	return txs
+}
+
+// Entries returns a copy of all transactions from Mempool together with the time
+// they arrived at, in the order of their nonces.
+func (m *Mempool) Entries() []JournalEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := make([]JournalEntry, 0, len(m.pending)+len(m.queued))
+	for _, pool := range []map[string]database.BlockTx{m.pending, m.queued} {
+		for key, tx := range pool {
+			entries = append(entries, JournalEntry{Tx: tx, Arrival: m.arrivals[key]})
 		}
 	}
 
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Tx.Nonce < entries[j].Tx.Nonce
+	})
+
+	return entries
+}
+
+// Pending returns a copy of the pending transactions ordered by their nonces, which
+// keeps transactions of the same account in the order they can be executed in.
+func (m *Mempool) Pending() []database.BlockTx {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	txs := make([]database.BlockTx, 0, len(m.pending))
+	for _, tx := range m.pending {
+		txs = append(txs, tx)
+	}
+
 	sort.Sort(byNonce(txs))
 
 	return txs
 }
 
+// PickBest returns at most howMany pending transactions with the highest tip per unit of gas.
+// Transactions of the same account are always returned in the order of their nonces,
+// so the tip of an account transaction can be only considered once all transactions
+// with lower nonces from that account have been picked.
+func (m *Mempool) PickBest(howMany uint16) []database.BlockTx {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// Group transactions into per account queues ordered by nonce.
+	queues := make(map[database.AccountID][]database.BlockTx)
+	for _, tx := range m.pending {
+		queues[tx.From] = append(queues[tx.From], tx)
+	}
+	for from := range queues {
+		sort.Sort(byNonce(queues[from]))
+	}
+
+	var txs []database.BlockTx
+
+	for len(txs) < int(howMany) {
+
+		// Find the account whose next transaction has the highest priority.
+		var best database.AccountID
+		for from, queue := range queues {
+			if len(queue) == 0 {
+				continue
+			}
+			if best == "" || hasHigherPriority(queue[0], queues[best][0]) {
+				best = from
+			}
+		}
+		if best == "" {
+			break
+		}
+
+		txs = append(txs, queues[best][0])
+		queues[best] = queues[best][1:]
+	}
+
+	return txs
+}
+
 // Truncate deletes all transactions from Mempool.
 func (m *Mempool) Truncate() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.pool = make(map[string]database.BlockTx)
+	m.pending = make(map[string]database.BlockTx)
+	m.queued = make(map[string]database.BlockTx)
+	m.arrivals = make(map[string]time.Time)
+	m.hashes = make(map[string]string)
+}
+
+// get returns the waiting transaction by given key from any of the sub-pools.
+func (m *Mempool) get(key string) (database.BlockTx, bool) {
+	if tx, ok := m.pending[key]; ok {
+		return tx, true
+	}
+	tx, ok := m.queued[key]
+	return tx, ok
+}
+
+// drop deletes the transaction by given key from all sub-pools and indexes.
+func (m *Mempool) drop(key string) {
+	delete(m.pending, key)
+	delete(m.queued, key)
+	delete(m.arrivals, key)
+	delete(m.hashes, key)
+}
+
+// promote moves queued transactions of the account to pending, as long as they
+// follow the account nonce without a gap.
+func (m *Mempool) promote(from database.AccountID, nonce uint64) {
+	for next := nonce + 1; ; next++ {
+		key := accountKey(from, next)
+		if _, ok := m.pending[key]; ok {
+			continue
+		}
+		tx, ok := m.queued[key]
+		if !ok {
+			return
+		}
+		m.pending[key] = tx
+		delete(m.queued, key)
+	}
+}
+
+// count returns the number of transactions of the account waiting in Mempool.
+func (m *Mempool) count(from database.AccountID) int {
+	var n int
+	for _, pool := range []map[string]database.BlockTx{m.pending, m.queued} {
+		for _, tx := range pool {
+			if tx.From == from {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// checkFunds ensures the account balance covers the costs of the transaction
+// together with all other transactions of the account waiting in Mempool.
+func (m *Mempool) checkFunds(tx database.BlockTx, account database.Account) error {
+	total, err := tx.Cost()
+	if err != nil {
+		return err
+	}
+
+	key := prepareKey(tx)
+	for _, pool := range []map[string]database.BlockTx{m.pending, m.queued} {
+		for k, waiting := range pool {
+			if waiting.From != tx.From || k == key {
+				continue
+			}
+			cost, err := waiting.Cost()
+			if err != nil {
+				return err
+			}
+			var carry uint64
+			if total, carry = bits.Add64(total, cost, 0); carry != 0 {
+				return fmt.Errorf("%w: costs overflow", ErrInsufficientFunds)
+			}
+		}
+	}
+
+	if total > account.Balance {
+		return fmt.Errorf("%w: balance: %d, required: %d", ErrInsufficientFunds, account.Balance, total)
+	}
+
+	return nil
+}
+
+// requiredTip returns the minimum tip of the transaction replacing the waiting one.
+// The tip has to be increased by the price bump, and by at least one unit. Tips are
+// chosen by the senders, so the tip is bumped without overflowing, and false is
+// reported when the required tip does not fit the uint64.
+func (m *Mempool) requiredTip(pending database.BlockTx) (uint64, bool) {
+	tip := new(big.Int).SetUint64(pending.Tip)
+
+	bump := new(big.Int).Mul(tip, new(big.Int).SetUint64(m.priceBump))
+	bump.Add(bump, big.NewInt(99))
+	bump.Div(bump, big.NewInt(100))
+	if bump.Sign() == 0 {
+		bump.SetInt64(1)
+	}
+
+	required := bump.Add(bump, tip)
+	if !required.IsUint64() {
+		return 0, false
+	}
+	return required.Uint64(), true
 }
 
 // prepareKey builds a new mempool key based on transaction from address and nonce.
 func prepareKey(tx database.BlockTx) string {
-	return fmt.Sprintf("%s:%d", tx.From, tx.Nonce)
+	return accountKey(tx.From, tx.Nonce)
+}
+
+// accountKey builds a new mempool key based on the account and nonce.
+func accountKey(from database.AccountID, nonce uint64) string {
+	return fmt.Sprintf("%s:%d", from, nonce)
 }
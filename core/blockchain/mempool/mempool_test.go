@@ -2,8 +2,12 @@ package mempool_test
 
 import (
 	"crypto/ecdsa"
+	"errors"
+	"math"
 	"testing"
+	"time"
 
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/stretchr/testify/assert"
 	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
 	"github.com/tchorzewski1991/fitbit/core/blockchain/mempool"
@@ -25,24 +29,28 @@ func TestMempool(t *testing.T) {
 		from:  from,
 		to:    to,
 		nonce: 1,
+		tip:   10,
 	})
 	blockTx2 := prepareBlockTx(t, blockTxArgs{
 		priv:  priv,
 		from:  from,
 		to:    to,
 		nonce: 2,
+		tip:   10,
 	})
 
 	// Run test
 
-	m := mempool.New()
+	m := mempool.New(mempool.Config{PriceBump: 10})
 	assert.Equal(t, 0, m.Size())
 
-	err = m.Upsert(blockTx1)
+	outcome, err := m.Upsert(blockTx1, mockAccount(from))
 	assert.Nil(t, err)
+	assert.Equal(t, mempool.Added, outcome)
 
-	err = m.Upsert(blockTx2)
+	outcome, err = m.Upsert(blockTx2, mockAccount(from))
 	assert.Nil(t, err)
+	assert.Equal(t, mempool.Added, outcome)
 
 	assert.Equal(t, 2, m.Size())
 
@@ -60,11 +68,397 @@ func TestMempool(t *testing.T) {
 	assert.Equal(t, 0, m.Size())
 }
 
+func TestMempool_PickBest(t *testing.T) {
+	// Setup test data
+	priv1 := testdata.LoadPrivateKey(t)
+	from1, err := database.PubToAccountID(priv1.PublicKey)
+	assert.Nil(t, err)
+
+	priv2, err := crypto.GenerateKey()
+	assert.Nil(t, err)
+	from2, err := database.PubToAccountID(priv2.PublicKey)
+	assert.Nil(t, err)
+
+	to, err := database.ToAccountID("0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0")
+	assert.Nil(t, err)
+
+	// Account 1 pays low tip first and high tip second
+	tx11 := prepareBlockTx(t, blockTxArgs{priv: priv1, from: from1, to: to, nonce: 1, tip: 1})
+	tx12 := prepareBlockTx(t, blockTxArgs{priv: priv1, from: from1, to: to, nonce: 2, tip: 50})
+
+	// Account 2 pays medium tips
+	tx21 := prepareBlockTx(t, blockTxArgs{priv: priv2, from: from2, to: to, nonce: 1, tip: 20})
+	tx22 := prepareBlockTx(t, blockTxArgs{priv: priv2, from: from2, to: to, nonce: 2, tip: 10})
+
+	m := mempool.New(mempool.Config{PriceBump: 10})
+	for _, tx := range []database.BlockTx{tx12, tx22, tx11, tx21} {
+		_, err = m.Upsert(tx, mockAccount(tx.From))
+		assert.Nil(t, err)
+	}
+
+	// Run test
+
+	// Ensure the high tip of account 1 does not jump over its own lower nonce
+	txs := m.PickBest(10)
+	assert.Equal(t, 4, len(txs))
+	assert.True(t, txs[0].Equals(tx21))
+	assert.True(t, txs[1].Equals(tx22))
+	assert.True(t, txs[2].Equals(tx11))
+	assert.True(t, txs[3].Equals(tx12))
+
+	// Ensure the limit is respected and the selection is deterministic
+	for i := 0; i < 10; i++ {
+		txs = m.PickBest(1)
+		assert.Equal(t, 1, len(txs))
+		assert.True(t, txs[0].Equals(tx21))
+	}
+}
+
+func TestMempool_Replace(t *testing.T) {
+	// Setup test data
+	priv := testdata.LoadPrivateKey(t)
+	from, err := database.PubToAccountID(priv.PublicKey)
+	assert.Nil(t, err)
+
+	to, err := database.ToAccountID("0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0")
+	assert.Nil(t, err)
+
+	pending := prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 1, tip: 100})
+
+	m := mempool.New(mempool.Config{PriceBump: 10})
+	outcome, err := m.Upsert(pending, mockAccount(from))
+	assert.Nil(t, err)
+	assert.Equal(t, mempool.Added, outcome)
+
+	// Run test
+
+	// Upsert the same tx again and ensure it is reported as known
+	outcome, err = m.Upsert(pending, mockAccount(from))
+	assert.Nil(t, err)
+	assert.Equal(t, mempool.Known, outcome)
+
+	// Replace the pending tx with a cheaper one and assert err
+	cheaper := prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 1, tip: 50})
+	outcome, err = m.Upsert(cheaper, mockAccount(from))
+	assert.ErrorIs(t, err, mempool.ErrUnderpriced)
+	assert.EqualError(t, err, "replacement tx underpriced: tip: 50, required: 110")
+	assert.Equal(t, mempool.Rejected, outcome)
+
+	// Replace the pending tx with not high enough tip bump and assert err
+	bumped := prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 1, tip: 109})
+	_, err = m.Upsert(bumped, mockAccount(from))
+	assert.ErrorIs(t, err, mempool.ErrUnderpriced)
+
+	// Replace the pending tx with the required tip bump
+	bumped = prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 1, tip: 110})
+	outcome, err = m.Upsert(bumped, mockAccount(from))
+	assert.Nil(t, err)
+	assert.Equal(t, mempool.Replaced, outcome)
+
+	txs := m.Select(mempool.SelectAll())
+	assert.Equal(t, 1, len(txs))
+	assert.True(t, txs[0].Equals(bumped))
+
+	// Ensure the tip of zero has to be increased by at least one unit
+	free := prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 2, tip: 0})
+	_, err = m.Upsert(free, mockAccount(from))
+	assert.Nil(t, err)
+	_, err = m.Upsert(prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 2, value: 50, tip: 0}), mockAccount(from))
+	assert.EqualError(t, err, "replacement tx underpriced: tip: 0, required: 1")
+}
+
+func TestMempool_ReplaceLargeTip(t *testing.T) {
+	// Setup test data
+	priv := testdata.LoadPrivateKey(t)
+	from, err := database.PubToAccountID(priv.PublicKey)
+	assert.Nil(t, err)
+
+	to, err := database.ToAccountID("0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0")
+	assert.Nil(t, err)
+
+	priv2, err := crypto.GenerateKey()
+	assert.Nil(t, err)
+	from2, err := database.PubToAccountID(priv2.PublicKey)
+	assert.Nil(t, err)
+
+	account := database.Account{ID: from, Balance: math.MaxUint64}
+	account2 := database.Account{ID: from2, Balance: math.MaxUint64}
+	tip := uint64(math.MaxUint64 / 5)
+
+	m := mempool.New(mempool.Config{PriceBump: 10})
+	_, err = m.Upsert(prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 1, tip: tip}), account)
+	assert.Nil(t, err)
+	_, err = m.Upsert(prepareBlockTx(t, blockTxArgs{priv: priv2, from: from2, to: to, nonce: 1, tip: math.MaxUint64 - 1_000_000}), account2)
+	assert.Nil(t, err)
+
+	// Run test
+
+	// Replace the tx with the tip bumped by less than the price bump and assert err
+	_, err = m.Upsert(prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 1, tip: tip + 1}), account)
+	assert.ErrorIs(t, err, mempool.ErrUnderpriced)
+
+	// Replace the tx with the required tip bump
+	outcome, err := m.Upsert(prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 1, tip: tip + tip/10 + 1}), account)
+	assert.Nil(t, err)
+	assert.Equal(t, mempool.Replaced, outcome)
+
+	// Replace the tx which tip cannot be bumped any further and assert err
+	_, err = m.Upsert(prepareBlockTx(t, blockTxArgs{priv: priv2, from: from2, to: to, nonce: 1, tip: math.MaxUint64 - 1_000}), account2)
+	assert.ErrorIs(t, err, mempool.ErrUnderpriced)
+}
+
+func TestMempool_Admission(t *testing.T) {
+	// Setup test data
+	priv := testdata.LoadPrivateKey(t)
+	from, err := database.PubToAccountID(priv.PublicKey)
+	assert.Nil(t, err)
+
+	to, err := database.ToAccountID("0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0")
+	assert.Nil(t, err)
+
+	// Account has already used nonce 1 and can afford three txs
+	account := database.Account{ID: from, Nonce: 1, Balance: 330}
+
+	tx1 := prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 1, tip: 10})
+	tx2 := prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 2, tip: 10})
+	tx3 := prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 3, tip: 10})
+	tx4 := prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 4, tip: 10})
+	tx5 := prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 5, tip: 10})
+
+	m := mempool.New(mempool.Config{PriceBump: 10})
+
+	// Run test
+
+	// Upsert tx with already used nonce and assert err
+	_, err = m.Upsert(tx1, account)
+	assert.ErrorIs(t, err, mempool.ErrNonceTooLow)
+	assert.EqualError(t, err, "tx nonce too low: 1, account nonce: 1")
+
+	// Upsert tx following the nonce gap, which is queued
+	_, err = m.Upsert(tx3, account)
+	assert.Nil(t, err)
+	assert.Equal(t, mempool.Stats{Pending: 0, Queued: 1}, m.Stats())
+	assert.Equal(t, 0, len(m.PickBest(10)))
+
+	// Fill the gap and ensure the queued tx is promoted
+	_, err = m.Upsert(tx2, account)
+	assert.Nil(t, err)
+	assert.Equal(t, mempool.Stats{Pending: 2, Queued: 0}, m.Stats())
+	assert.Equal(t, 2, len(m.PickBest(10)))
+
+	// Upsert tx the account cannot afford together with the waiting ones and assert err
+	_, err = m.Upsert(tx5, account)
+	assert.Nil(t, err)
+	_, err = m.Upsert(tx4, account)
+	assert.ErrorIs(t, err, mempool.ErrInsufficientFunds)
+	assert.EqualError(t, err, "insufficient funds: balance: 330, required: 440")
+	assert.Equal(t, mempool.Stats{Pending: 2, Queued: 1}, m.Stats())
+
+	// Tx with nonce 2 is mined, so it is dropped and the rest is reconciled
+	account.Nonce = 2
+	m.Update(database.Accounts{from: account})
+	assert.Equal(t, mempool.Stats{Pending: 1, Queued: 1}, m.Stats())
+
+	// Tx with nonce 3 is dropped, so the following tx is moved back to the queue
+	err = m.Remove(tx3)
+	assert.Nil(t, err)
+	_, err = m.Upsert(tx4, account)
+	assert.Nil(t, err)
+	assert.Equal(t, mempool.Stats{Pending: 0, Queued: 2}, m.Stats())
+
+	// Account catches up with the queued txs and they are promoted
+	account.Nonce = 3
+	m.Update(database.Accounts{from: account})
+	assert.Equal(t, mempool.Stats{Pending: 2, Queued: 0}, m.Stats())
+}
+
+func TestMempool_Limits(t *testing.T) {
+	// Setup test data
+	priv1 := testdata.LoadPrivateKey(t)
+	from1, err := database.PubToAccountID(priv1.PublicKey)
+	assert.Nil(t, err)
+
+	priv2, err := crypto.GenerateKey()
+	assert.Nil(t, err)
+	from2, err := database.PubToAccountID(priv2.PublicKey)
+	assert.Nil(t, err)
+
+	priv3, err := crypto.GenerateKey()
+	assert.Nil(t, err)
+	from3, err := database.PubToAccountID(priv3.PublicKey)
+	assert.Nil(t, err)
+
+	to, err := database.ToAccountID("0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0")
+	assert.Nil(t, err)
+
+	var evicted []mempool.Eviction
+	m := mempool.New(mempool.Config{
+		Capacity:     3,
+		AccountLimit: 2,
+		EvictHandler: func(e mempool.Eviction) {
+			evicted = append(evicted, e)
+		},
+	})
+
+	// Run test
+
+	// Fill the account quota and assert err for the next tx
+	for nonce := uint64(1); nonce <= 2; nonce++ {
+		_, err = m.Upsert(prepareBlockTx(t, blockTxArgs{priv: priv1, from: from1, to: to, nonce: nonce, tip: 10}), mockAccount(from1))
+		assert.Nil(t, err)
+	}
+	_, err = m.Upsert(prepareBlockTx(t, blockTxArgs{priv: priv1, from: from1, to: to, nonce: 3, tip: 10}), mockAccount(from1))
+	assert.ErrorIs(t, err, mempool.ErrAccountLimit)
+
+	// Fill the mempool with the tx of another account
+	low := prepareBlockTx(t, blockTxArgs{priv: priv2, from: from2, to: to, nonce: 1, tip: 1})
+	_, err = m.Upsert(low, mockAccount(from2))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, m.Size())
+
+	// Upsert tx which does not pay more than the lowest tip and assert err
+	_, err = m.Upsert(prepareBlockTx(t, blockTxArgs{priv: priv3, from: from3, to: to, nonce: 1, tip: 1}), mockAccount(from3))
+	assert.ErrorIs(t, err, mempool.ErrMempoolFull)
+
+	// Upsert tx paying more, so the lowest tip tx is evicted
+	high := prepareBlockTx(t, blockTxArgs{priv: priv3, from: from3, to: to, nonce: 1, tip: 50})
+	_, err = m.Upsert(high, mockAccount(from3))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, m.Size())
+	assert.Equal(t, 1, len(evicted))
+	assert.True(t, evicted[0].Tx.Equals(low))
+	assert.Equal(t, mempool.EvictCapacity, evicted[0].Reason)
+
+	// Ensure the last tx of the account is evicted, so no nonce gap is left behind
+	_, err = m.Upsert(prepareBlockTx(t, blockTxArgs{priv: priv2, from: from2, to: to, nonce: 1, tip: 20}), mockAccount(from2))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(evicted))
+	assert.Equal(t, from1, evicted[1].Tx.From)
+	assert.Equal(t, uint64(2), evicted[1].Tx.Nonce)
+	assert.Equal(t, mempool.Stats{Pending: 3, Queued: 0}, m.Stats())
+
+	// Ensure the evictions are listed starting with the latest one
+	evictions := m.Evictions()
+	assert.Equal(t, 2, len(evictions))
+	assert.True(t, evictions[1].Tx.Equals(low))
+}
+
+func TestMempool_Expire(t *testing.T) {
+	// Setup test data
+	priv := testdata.LoadPrivateKey(t)
+	from, err := database.PubToAccountID(priv.PublicKey)
+	assert.Nil(t, err)
+
+	to, err := database.ToAccountID("0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0")
+	assert.Nil(t, err)
+
+	m := mempool.New(mempool.Config{TTL: time.Hour})
+	for nonce := uint64(1); nonce <= 2; nonce++ {
+		_, err = m.Upsert(prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: nonce, tip: 10}), mockAccount(from))
+		assert.Nil(t, err)
+	}
+
+	// Run test
+
+	// Nothing expires within the time-to-live
+	assert.Equal(t, 0, m.Expire(time.Now()))
+	assert.Equal(t, 2, m.Size())
+
+	// All transactions expire once the time-to-live has passed
+	assert.Equal(t, 2, m.Expire(time.Now().Add(time.Hour)))
+	assert.Equal(t, 0, m.Size())
+
+	evictions := m.Evictions()
+	assert.Equal(t, 2, len(evictions))
+	assert.Equal(t, mempool.EvictExpired, evictions[0].Reason)
+
+	// Restore the tx with its original arrival time and ensure it expires on time
+	arrival := time.Now().Add(-time.Hour)
+	_, err = m.UpsertAt(prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 1, tip: 10}), mockAccount(from), arrival)
+	assert.ErrorIs(t, err, mempool.ErrExpired)
+
+	arrival = time.Now().Add(-time.Minute)
+	_, err = m.UpsertAt(prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 1, tip: 10}), mockAccount(from), arrival)
+	assert.Nil(t, err)
+	assert.Equal(t, arrival, m.Entries()[0].Arrival)
+	assert.Equal(t, 1, m.Expire(arrival.Add(time.Hour)))
+}
+
+func TestMempool_Find(t *testing.T) {
+	// Setup test data
+	priv := testdata.LoadPrivateKey(t)
+	from, err := database.PubToAccountID(priv.PublicKey)
+	assert.Nil(t, err)
+
+	to, err := database.ToAccountID("0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0")
+	assert.Nil(t, err)
+
+	m := mempool.New(mempool.Config{PriceBump: 10})
+
+	first := prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 1, tip: 10})
+	queued := prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 3, tip: 10})
+	for _, tx := range []database.BlockTx{first, queued} {
+		_, err = m.Upsert(tx, mockAccount(from))
+		assert.Nil(t, err)
+	}
+
+	// Run test
+
+	// Find waiting txs by hash
+	_, pool, ok := m.Find(first.HexHash())
+	assert.True(t, ok)
+	assert.Equal(t, mempool.PoolPending, pool)
+
+	_, pool, ok = m.Find(queued.HexHash())
+	assert.True(t, ok)
+	assert.Equal(t, mempool.PoolQueued, pool)
+
+	// Ensure only the pending tx is executable
+	pending := m.Pending()
+	assert.Equal(t, 1, len(pending))
+	assert.True(t, pending[0].Equals(first))
+
+	// Replace the first tx and assert the eviction is recorded
+	replacement := prepareBlockTx(t, blockTxArgs{priv: priv, from: from, to: to, nonce: 1, tip: 20})
+	_, err = m.Upsert(replacement, mockAccount(from))
+	assert.Nil(t, err)
+
+	_, _, ok = m.Find(first.HexHash())
+	assert.False(t, ok)
+	hash, ok := m.HashOf(first)
+	assert.True(t, ok)
+	assert.Equal(t, replacement.HexHash(), hash)
+
+	eviction, ok := m.FindEviction(first.HexHash())
+	assert.True(t, ok)
+	assert.Equal(t, mempool.EvictReplaced, eviction.Reason)
+
+	// Discard the replacement as invalid
+	m.Discard(replacement, errors.New("tx invalid"))
+
+	eviction, ok = m.FindEviction(replacement.HexHash())
+	assert.True(t, ok)
+	assert.Equal(t, mempool.EvictInvalid, eviction.Reason)
+	assert.Equal(t, "tx invalid", eviction.Error)
+
+	// Use the nonce of the queued tx and assert it is evicted as stale
+	m.Update(database.Accounts{from: {ID: from, Balance: 1000, Nonce: 3}})
+
+	eviction, ok = m.FindEviction(queued.HexHash())
+	assert.True(t, ok)
+	assert.Equal(t, mempool.EvictStale, eviction.Reason)
+	assert.Equal(t, 0, m.Size())
+}
+
+// Helper functions
+
 type blockTxArgs struct {
 	priv  *ecdsa.PrivateKey
 	from  database.AccountID
 	to    database.AccountID
 	nonce uint64
+	value uint64
+	tip   uint64
 }
 
 func prepareBlockTx(t *testing.T, args blockTxArgs) database.BlockTx {
@@ -74,10 +468,17 @@ func prepareBlockTx(t *testing.T, args blockTxArgs) database.BlockTx {
 		From:    args.from,
 		To:      args.to,
 		Value:   100,
-		Tip:     10,
+		Tip:     args.tip,
+	}
+	if args.value != 0 {
+		tx.Value = args.value
 	}
 	signedTx, err := tx.Sign(args.priv)
 	assert.Nil(t, err)
 
 	return database.NewBlockTx(signedTx, 0, 0)
 }
+
+func mockAccount(id database.AccountID) database.Account {
+	return database.Account{ID: id, Balance: 1_000_000}
+}
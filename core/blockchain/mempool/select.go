@@ -36,3 +36,30 @@ func (bn byNonce) Less(i, j int) bool {
 func (bn byNonce) Swap(i, j int) {
 	bn[i], bn[j] = bn[j], bn[i]
 }
+
+// hasHigherPriority reports whether tx should be picked before the other transaction.
+// Transactions with higher tip per unit of gas go first, while ties are resolved
+// by the timestamp and the sender, so the order is always deterministic.
+func hasHigherPriority(tx, other database.BlockTx) bool {
+
+	// Compare tip per gas ratios without losing the precision of integer division.
+	txTip := tx.Tip * gasUnits(other)
+	otherTip := other.Tip * gasUnits(tx)
+	if txTip != otherTip {
+		return txTip > otherTip
+	}
+
+	if tx.Timestamp != other.Timestamp {
+		return tx.Timestamp < other.Timestamp
+	}
+
+	return tx.From < other.From
+}
+
+// gasUnits returns the gas units of the transaction, where zero is treated as one.
+func gasUnits(tx database.BlockTx) uint64 {
+	if tx.GasUnits == 0 {
+		return 1
+	}
+	return tx.GasUnits
+}
@@ -0,0 +1,88 @@
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// MessageType identifies the content of the Message exchanged between peers.
+type MessageType uint8
+
+// Set of message types exchanged between peers.
+const (
+	MsgBlock MessageType = iota + 1
+	MsgTx
+	MsgStatusRequest
+	MsgStatus
+	MsgPeers
+)
+
+// String returns the name of the message type.
+func (t MessageType) String() string {
+	switch t {
+	case MsgBlock:
+		return "block"
+	case MsgTx:
+		return "tx"
+	case MsgStatusRequest:
+		return "status_request"
+	case MsgStatus:
+		return "status"
+	case MsgPeers:
+		return "peers"
+	default:
+		return fmt.Sprintf("message(%d)", uint8(t))
+	}
+}
+
+// Message represents the typed message exchanged between peers over the Transport.
+// The payload is encoded by the sender according to the message type, the Transport
+// does not look into it.
+type Message struct {
+	Type    MessageType
+	Payload []byte
+}
+
+// messageHeaderSize is the size of the frame header keeping the message type,
+// the frame flags and the request id.
+const messageHeaderSize = 10
+
+// Set of frame flags.
+const (
+	flagResponse uint8 = 1 << iota
+	flagError
+)
+
+// frame represents the Message on the wire. Requests and their responses share
+// the same id, while one-way messages have the id set to zero.
+type frame struct {
+	flags uint8
+	id    uint64
+	msg   Message
+}
+
+// encodeFrame encodes the frame as the big endian header followed by the payload.
+func encodeFrame(f frame) []byte {
+	bs := make([]byte, messageHeaderSize+len(f.msg.Payload))
+	bs[0] = byte(f.msg.Type)
+	bs[1] = f.flags
+	binary.BigEndian.PutUint64(bs[2:10], f.id)
+	copy(bs[messageHeaderSize:], f.msg.Payload)
+	return bs
+}
+
+// decodeFrame decodes the frame encoded with encodeFrame.
+func decodeFrame(bs []byte) (frame, error) {
+	if len(bs) < messageHeaderSize {
+		return frame{}, fmt.Errorf("message frame too short: %d", len(bs))
+	}
+
+	return frame{
+		flags: bs[1],
+		id:    binary.BigEndian.Uint64(bs[2:10]),
+		msg: Message{
+			Type:    MessageType(bs[0]),
+			Payload: bs[messageHeaderSize:],
+		},
+	}, nil
+}
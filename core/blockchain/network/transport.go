@@ -0,0 +1,515 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Set of errors returned by the Transport.
+var (
+	// ErrNotConnected is returned when the connection to the peer cannot be established,
+	// or the peer has recently failed to connect and the redial interval has not passed yet.
+	ErrNotConnected = errors.New("peer not connected")
+
+	// ErrRequestTimeout is returned when the peer has not responded to the request in time.
+	ErrRequestTimeout = errors.New("peer request timed out")
+
+	// ErrTransportClosed is returned when the Transport has been already closed.
+	ErrTransportClosed = errors.New("transport closed")
+
+	// ErrPeerRejected is returned when the peer has failed to handle the message.
+	ErrPeerRejected = errors.New("peer rejected message")
+)
+
+// HostHeader is the request header carrying the host of the node which opens
+// the connection. The header is not verified, so it only tells the handler where
+// the message comes from, while the accepted connection is never used to send
+// messages to that host.
+const HostHeader = "X-Peer-Host"
+
+const (
+	// writeWait is the time allowed to write the message to the peer.
+	writeWait = 5 * time.Second
+
+	// pongWait is the time allowed to read the next message or pong from the peer.
+	pongWait = 60 * time.Second
+
+	// pingPeriod is the period of pings sent to the peer, which must be less than pongWait.
+	pingPeriod = pongWait * 9 / 10
+
+	// maxMessageSize is the maximum size of the message read from the peer.
+	maxMessageSize = 32 << 20
+
+	// handleQueueSize is the number of messages waiting to be handled per connection.
+	// Messages received while the queue is full are rejected.
+	handleQueueSize = 256
+)
+
+// EventHandler allows for reacting to the events happening in the Transport.
+type EventHandler func(s string, args ...any)
+
+// MessageHandler handles the message received from the peer. The returned message is
+// sent back to the peer as the response to the request, while the returned error is
+// sent back as the rejection of it.
+type MessageHandler func(from Peer, msg Message) (Message, error)
+
+// TransportConfig keeps the settings of the Transport.
+type TransportConfig struct {
+
+	// Host is the host of the current node, which the peers know it by.
+	Host string
+
+	// Endpoint is the format of the websocket url the peers accept connections at.
+	// It is expected to contain a single verb for the peer host.
+	Endpoint string
+
+	// Handler handles all messages received from the peers.
+	Handler MessageHandler
+
+	// EventHandler is called for the connection related events.
+	EventHandler EventHandler
+
+	// RequestTimeout is the time the peer has to respond to the request.
+	RequestTimeout time.Duration
+
+	// RedialInterval is the time after which the peer which failed to connect is dialed again.
+	// Until then, messages to the peer fail right away, so the caller can fall back quickly.
+	RedialInterval time.Duration
+}
+
+// Transport keeps long-lived websocket connections to the peers, multiplexing the typed
+// messages over a single connection per peer. Messages are only sent over the connections
+// dialed by the current node, so they always reach the peer behind the dialed host, while
+// the accepted connections only carry the messages of the peers which opened them and
+// the responses to those. Messages are handled by a separate goroutine per connection,
+// so the responses are read while they are handled.
+type Transport struct {
+	host           string
+	endpoint       string
+	handler        MessageHandler
+	ev             EventHandler
+	requestTimeout time.Duration
+	redialInterval time.Duration
+	dialer         *websocket.Dialer
+	upgrader       websocket.Upgrader
+
+	mu       sync.Mutex
+	conns    map[Peer]*conn
+	accepted map[*conn]struct{}
+	failed   map[Peer]time.Time
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewTransport constructs a new Transport.
+func NewTransport(cfg TransportConfig) *Transport {
+	if cfg.EventHandler == nil {
+		// Set no-op event handler if event handler has not been set.
+		cfg.EventHandler = func(s string, args ...any) {}
+	}
+	if cfg.RequestTimeout == 0 {
+		cfg.RequestTimeout = 5 * time.Second
+	}
+	if cfg.RedialInterval == 0 {
+		cfg.RedialInterval = 30 * time.Second
+	}
+
+	return &Transport{
+		host:           cfg.Host,
+		endpoint:       cfg.Endpoint,
+		handler:        cfg.Handler,
+		ev:             cfg.EventHandler,
+		requestTimeout: cfg.RequestTimeout,
+		redialInterval: cfg.RedialInterval,
+		dialer:         &websocket.Dialer{HandshakeTimeout: writeWait},
+		conns:          make(map[Peer]*conn),
+		accepted:       make(map[*conn]struct{}),
+		failed:         make(map[Peer]time.Time),
+	}
+}
+
+// Send sends the message to the peer, connecting to it when necessary, and waits until
+// the peer acknowledges it. The error the peer failed to handle the message with is
+// returned wrapped with ErrPeerRejected.
+func (t *Transport) Send(peer Peer, msg Message) error {
+	_, err := t.Request(peer, msg)
+	return err
+}
+
+// Request sends the message to the peer and waits for the response.
+func (t *Transport) Request(peer Peer, msg Message) (Message, error) {
+	c, err := t.connect(peer)
+	if err != nil {
+		return Message{}, err
+	}
+
+	id, responses := c.register()
+	defer c.unregister(id)
+
+	if err = c.write(frame{id: id, msg: msg}); err != nil {
+		return Message{}, err
+	}
+
+	timer := time.NewTimer(t.requestTimeout)
+	defer timer.Stop()
+
+	select {
+	case f := <-responses:
+		if f.flags&flagError != 0 {
+			return Message{}, fmt.Errorf("%w: %s: %s", ErrPeerRejected, peer.Host, f.msg.Payload)
+		}
+		return f.msg, nil
+	case <-c.done:
+		return Message{}, fmt.Errorf("%w: connection to: %s closed", ErrNotConnected, peer.Host)
+	case <-timer.C:
+		return Message{}, fmt.Errorf("%w: %s", ErrRequestTimeout, peer.Host)
+	}
+}
+
+// Accept upgrades the HTTP request of the peer to the long-lived connection the peer
+// sends its messages over. The peer is identified by the HostHeader. Errors are already
+// written back to the peer.
+func (t *Transport) Accept(w http.ResponseWriter, r *http.Request) error {
+	host := r.Header.Get(HostHeader)
+	if host == "" {
+		err := fmt.Errorf("header %s is mandatory", HostHeader)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	ws, err := t.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return err
+	}
+
+	c := newConn(NewPeer(host), ws)
+
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		_ = ws.Close()
+		return ErrTransportClosed
+	}
+	t.accepted[c] = struct{}{}
+	t.wg.Add(3)
+	t.mu.Unlock()
+
+	t.serve(c)
+
+	return nil
+}
+
+// Disconnect closes the connection to the peer, if there is one.
+func (t *Transport) Disconnect(peer Peer) {
+	t.mu.Lock()
+	c, ok := t.conns[peer]
+	delete(t.conns, peer)
+	t.mu.Unlock()
+
+	if ok {
+		c.close()
+	}
+}
+
+// Close closes all connections and waits until they are done.
+func (t *Transport) Close() {
+	t.mu.Lock()
+	t.closed = true
+	conns := t.conns
+	accepted := t.accepted
+	t.conns = make(map[Peer]*conn)
+	t.accepted = make(map[*conn]struct{})
+	t.mu.Unlock()
+
+	for _, c := range conns {
+		c.close()
+	}
+	for c := range accepted {
+		c.close()
+	}
+	t.wg.Wait()
+}
+
+// private API
+
+// connect returns the connection to the peer, dialing it when there is none.
+func (t *Transport) connect(peer Peer) (*conn, error) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, ErrTransportClosed
+	}
+	if c, ok := t.conns[peer]; ok {
+		t.mu.Unlock()
+		return c, nil
+	}
+	if failedAt, ok := t.failed[peer]; ok && time.Since(failedAt) < t.redialInterval {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrNotConnected, peer.Host)
+	}
+	t.mu.Unlock()
+
+	header := http.Header{}
+	header.Set(HostHeader, t.host)
+
+	ws, _, err := t.dialer.Dial(fmt.Sprintf(t.endpoint, peer.Host), header)
+	if err != nil {
+		t.mu.Lock()
+		t.failed[peer] = time.Now()
+		t.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s: %s", ErrNotConnected, peer.Host, err)
+	}
+	t.ev("[TRANSPORT][connect][Connected to: %s]", peer.Host)
+
+	return t.add(peer, ws)
+}
+
+// add registers the connection dialed to the peer and starts serving it. When the peer
+// has been dialed concurrently, the connection registered first is kept.
+func (t *Transport) add(peer Peer, ws *websocket.Conn) (*conn, error) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		_ = ws.Close()
+		return nil, ErrTransportClosed
+	}
+	if existing, ok := t.conns[peer]; ok {
+		t.mu.Unlock()
+		_ = ws.Close()
+		return existing, nil
+	}
+
+	c := newConn(peer, ws)
+	t.conns[peer] = c
+	delete(t.failed, peer)
+	t.wg.Add(3)
+	t.mu.Unlock()
+
+	t.serve(c)
+
+	return c, nil
+}
+
+// serve starts reading, handling and pinging the connection.
+func (t *Transport) serve(c *conn) {
+	go t.readLoop(c)
+	go t.handleLoop(c)
+	go t.pingLoop(c)
+}
+
+// remove unregisters the connection, unless it has been already replaced.
+func (t *Transport) remove(c *conn) {
+	t.mu.Lock()
+	if t.conns[c.peer] == c {
+		delete(t.conns, c.peer)
+	}
+	delete(t.accepted, c)
+	t.mu.Unlock()
+
+	c.close()
+}
+
+// readLoop reads the messages from the peer until the connection is closed. Responses
+// are passed to the pending requests right away, while other messages are queued to be
+// handled in the order they have been received.
+func (t *Transport) readLoop(c *conn) {
+	defer t.wg.Done()
+	defer t.remove(c)
+
+	c.ws.SetReadLimit(maxMessageSize)
+	_ = c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		kind, bs, err := c.ws.ReadMessage()
+		if err != nil {
+			t.ev("[TRANSPORT][readLoop][Connection to: %s closed: %s]", c.peer.Host, err)
+			return
+		}
+		_ = c.ws.SetReadDeadline(time.Now().Add(pongWait))
+
+		if kind != websocket.BinaryMessage {
+			continue
+		}
+
+		f, err := decodeFrame(bs)
+		if err != nil {
+			t.ev("[TRANSPORT][readLoop][Got invalid message from: %s: %s]", c.peer.Host, err)
+			continue
+		}
+
+		if f.flags&flagResponse != 0 {
+			c.resolve(f)
+			continue
+		}
+
+		select {
+		case c.queue <- f:
+		default:
+			t.reject(c, f, errors.New("too many messages waiting to be handled"))
+		}
+	}
+}
+
+// handleLoop handles the queued messages until the connection is closed.
+func (t *Transport) handleLoop(c *conn) {
+	defer t.wg.Done()
+
+	for {
+		select {
+		case f := <-c.queue:
+			t.handle(c, f)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// handle passes the message to the handler and responds to the request.
+func (t *Transport) handle(c *conn, f frame) {
+	resp, err := t.handler(c.peer, f.msg)
+	if f.id == 0 {
+		if err != nil {
+			t.ev("[TRANSPORT][handle][Handling %s from: %s failed: %s]", f.msg.Type, c.peer.Host, err)
+		}
+		return
+	}
+
+	if err != nil {
+		t.reject(c, f, err)
+		return
+	}
+	if err = c.write(frame{flags: flagResponse, id: f.id, msg: resp}); err != nil {
+		t.ev("[TRANSPORT][handle][Responding to: %s failed: %s]", c.peer.Host, err)
+	}
+}
+
+// reject responds to the request with the error. One-way messages are dropped.
+func (t *Transport) reject(c *conn, f frame, err error) {
+	if f.id == 0 {
+		t.ev("[TRANSPORT][reject][Dropping %s from: %s: %s]", f.msg.Type, c.peer.Host, err)
+		return
+	}
+
+	reply := frame{flags: flagResponse | flagError, id: f.id, msg: Message{Type: f.msg.Type, Payload: []byte(err.Error())}}
+	if err = c.write(reply); err != nil {
+		t.ev("[TRANSPORT][reject][Responding to: %s failed: %s]", c.peer.Host, err)
+	}
+}
+
+// pingLoop keeps the connection alive and detects the peers which went away.
+func (t *Transport) pingLoop(c *conn) {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.ping(); err != nil {
+				t.ev("[TRANSPORT][pingLoop][Ping to: %s failed: %s]", c.peer.Host, err)
+				c.close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// conn represents the websocket connection to the peer together with the requests
+// waiting for the responses and the messages waiting to be handled.
+type conn struct {
+	peer  Peer
+	ws    *websocket.Conn
+	queue chan frame
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan frame
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newConn constructs a new conn.
+func newConn(peer Peer, ws *websocket.Conn) *conn {
+	return &conn{
+		peer:    peer,
+		ws:      ws,
+		queue:   make(chan frame, handleQueueSize),
+		pending: make(map[uint64]chan frame),
+		done:    make(chan struct{}),
+	}
+}
+
+// write sends the frame to the peer. Websocket connections support a single writer
+// at a time, so the writes are serialized. Connection which failed to write is closed.
+func (c *conn) write(f frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	_ = c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, encodeFrame(f)); err != nil {
+		c.close()
+		return fmt.Errorf("%w: %s: %s", ErrNotConnected, c.peer.Host, err)
+	}
+	return nil
+}
+
+// ping sends the ping control message to the peer.
+func (c *conn) ping() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	return c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+}
+
+// register allocates the id of the new request and the channel its response is passed to.
+func (c *conn) register() (uint64, chan frame) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextID++
+	responses := make(chan frame, 1)
+	c.pending[c.nextID] = responses
+
+	return c.nextID, responses
+}
+
+// unregister forgets the request once it is done.
+func (c *conn) unregister(id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.pending, id)
+}
+
+// resolve passes the response to the request waiting for it.
+func (c *conn) resolve(f frame) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if responses, ok := c.pending[f.id]; ok {
+		responses <- f
+		delete(c.pending, f.id)
+	}
+}
+
+// close closes the connection, which also stops the requests waiting for the responses.
+func (c *conn) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		_ = c.ws.Close()
+	})
+}
@@ -0,0 +1,206 @@
+package network_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/network"
+)
+
+func TestTransport(t *testing.T) {
+	// Setup test data
+	received := make(chan network.Message, 1)
+	handler := func(from network.Peer, msg network.Message) (network.Message, error) {
+		switch msg.Type {
+		case network.MsgStatusRequest:
+			return network.Message{Type: network.MsgStatus, Payload: []byte(from.Host)}, nil
+		case network.MsgPeers, network.MsgBlock:
+			return network.Message{}, errors.New("message not accepted")
+		default:
+			received <- msg
+			return network.Message{}, nil
+		}
+	}
+
+	a := network.NewTransport(network.TransportConfig{Host: "a", Endpoint: "ws://%s/ws", Handler: handler})
+	defer a.Close()
+
+	b := network.NewTransport(network.TransportConfig{Host: "b", Endpoint: "ws://%s/ws", Handler: handler})
+	defer b.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = b.Accept(w, r)
+	}))
+	defer srv.Close()
+
+	peerB := network.NewPeer(strings.TrimPrefix(srv.URL, "http://"))
+
+	// Run test
+
+	// Send message to the peer
+	err := a.Send(peerB, network.Message{Type: network.MsgTx, Payload: []byte("tx")})
+	assert.Nil(t, err)
+	assert.Equal(t, network.Message{Type: network.MsgTx, Payload: []byte("tx")}, receive(t, received))
+
+	// Send message failing on the peer side
+	err = a.Send(peerB, network.Message{Type: network.MsgBlock, Payload: []byte("block")})
+	assert.ErrorIs(t, err, network.ErrPeerRejected)
+
+	// Send request and assert the response identifies the requester
+	resp, err := a.Request(peerB, network.Message{Type: network.MsgStatusRequest})
+	assert.Nil(t, err)
+	assert.Equal(t, network.MsgStatus, resp.Type)
+	assert.Equal(t, "a", string(resp.Payload))
+
+	// Send request failing on the peer side
+	_, err = a.Request(peerB, network.Message{Type: network.MsgPeers})
+	assert.ErrorIs(t, err, network.ErrPeerRejected)
+	assert.ErrorContains(t, err, "message not accepted")
+
+	// Accepted connection is not used to send messages back, as "a" cannot be dialed
+	err = b.Send(network.NewPeer("a"), network.Message{Type: network.MsgTx, Payload: []byte("tx")})
+	assert.ErrorIs(t, err, network.ErrNotConnected)
+
+	// Disconnected peer is dialed again
+	a.Disconnect(peerB)
+	err = a.Send(peerB, network.Message{Type: network.MsgTx, Payload: []byte("tx")})
+	assert.Nil(t, err)
+	assert.Equal(t, network.MsgTx, receive(t, received).Type)
+}
+
+func TestTransport_Impostor(t *testing.T) {
+	// Setup test data
+	handler := func(received chan network.Message) network.MessageHandler {
+		return func(from network.Peer, msg network.Message) (network.Message, error) {
+			received <- msg
+			return network.Message{}, nil
+		}
+	}
+
+	receivedA := make(chan network.Message, 1)
+	var a *network.Transport
+	srvA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = a.Accept(w, r)
+	}))
+	defer srvA.Close()
+
+	hostA := strings.TrimPrefix(srvA.URL, "http://")
+	a = network.NewTransport(network.TransportConfig{Host: hostA, Endpoint: "ws://%s/ws", Handler: handler(receivedA)})
+	defer a.Close()
+
+	// Impostor claims to be "a" while connecting
+	receivedC := make(chan network.Message, 1)
+	c := network.NewTransport(network.TransportConfig{Host: hostA, Endpoint: "ws://%s/ws", Handler: handler(receivedC)})
+	defer c.Close()
+
+	receivedB := make(chan network.Message, 1)
+	b := network.NewTransport(network.TransportConfig{Host: "b", Endpoint: "ws://%s/ws", Handler: handler(receivedB)})
+	defer b.Close()
+
+	srvB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = b.Accept(w, r)
+	}))
+	defer srvB.Close()
+
+	peerB := network.NewPeer(strings.TrimPrefix(srvB.URL, "http://"))
+
+	// Run test
+
+	// Connect the impostor to the peer
+	err := c.Send(peerB, network.Message{Type: network.MsgTx, Payload: []byte("tx")})
+	assert.Nil(t, err)
+	receive(t, receivedB)
+
+	// Ensure the message to "a" is delivered to the genuine peer dialed by its host
+	err = b.Send(network.NewPeer(hostA), network.Message{Type: network.MsgBlock, Payload: []byte("block")})
+	assert.Nil(t, err)
+	assert.Equal(t, network.Message{Type: network.MsgBlock, Payload: []byte("block")}, receive(t, receivedA))
+	assert.Empty(t, receivedC)
+}
+
+func TestTransport_RequestWhileHandling(t *testing.T) {
+	// Setup test data
+	var a *network.Transport
+	srvA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = a.Accept(w, r)
+	}))
+	defer srvA.Close()
+
+	a = network.NewTransport(network.TransportConfig{
+		Host:           strings.TrimPrefix(srvA.URL, "http://"),
+		Endpoint:       "ws://%s/ws",
+		RequestTimeout: time.Second,
+		Handler: func(from network.Peer, msg network.Message) (network.Message, error) {
+			return network.Message{Type: network.MsgStatus, Payload: []byte("a")}, nil
+		},
+	})
+	defer a.Close()
+
+	var b *network.Transport
+	b = network.NewTransport(network.TransportConfig{
+		Host:           "b",
+		Endpoint:       "ws://%s/ws",
+		RequestTimeout: time.Second,
+		Handler: func(from network.Peer, msg network.Message) (network.Message, error) {
+			// Handler waits for the response of the peer it is handling the request of
+			return b.Request(from, network.Message{Type: network.MsgStatusRequest})
+		},
+	})
+	defer b.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = b.Accept(w, r)
+	}))
+	defer srv.Close()
+
+	peerB := network.NewPeer(strings.TrimPrefix(srv.URL, "http://"))
+
+	// Run test
+	resp, err := a.Request(peerB, network.Message{Type: network.MsgStatusRequest})
+	assert.Nil(t, err)
+	assert.Equal(t, network.Message{Type: network.MsgStatus, Payload: []byte("a")}, resp)
+}
+
+func TestTransport_NotConnected(t *testing.T) {
+	// Setup test data
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	a := network.NewTransport(network.TransportConfig{Host: "a", Endpoint: "ws://%s/ws", RedialInterval: time.Hour})
+	defer a.Close()
+
+	peer := network.NewPeer(strings.TrimPrefix(srv.URL, "http://"))
+
+	// Run test
+
+	// Peer without the transport support fails to connect
+	err := a.Send(peer, network.Message{Type: network.MsgTx})
+	assert.ErrorIs(t, err, network.ErrNotConnected)
+
+	// Peer is not dialed again until the redial interval passes
+	srv.Close()
+	_, err = a.Request(peer, network.Message{Type: network.MsgStatusRequest})
+	assert.ErrorIs(t, err, network.ErrNotConnected)
+
+	// Closed transport does not connect anymore
+	a.Close()
+	err = a.Send(peer, network.Message{Type: network.MsgTx})
+	assert.ErrorIs(t, err, network.ErrTransportClosed)
+}
+
+// Helper functions
+
+func receive(t *testing.T, received chan network.Message) network.Message {
+	select {
+	case msg := <-received:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+		return network.Message{}
+	}
+}
@@ -0,0 +1,216 @@
+// Package smt provides an implementation of a sparse merkle tree which can
+// be updated incrementally and proves the inclusion of a single value.
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+// depth represents the number of levels below the root, one per key bit.
+const depth = 256
+
+// Leaf and inner node hashes are prefixed differently, so the leaf can never
+// be interpreted as an inner node and vice versa.
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// Key represents the position of the value in the tree.
+type Key [32]byte
+
+// NewKey derives the Key from any data by hashing it.
+func NewKey(data []byte) Key {
+	return sha256.Sum256(data)
+}
+
+// defaults keeps the hashes of empty subtrees for every level, where the
+// level zero represents leaves and the level 256 represents the root.
+var defaults = func() [depth + 1][32]byte {
+	var d [depth + 1][32]byte
+	for level := 1; level <= depth; level++ {
+		d[level] = nodeHash(d[level-1], d[level-1])
+	}
+	return d
+}()
+
+// nodeKey identifies the node by its level and the key bits above that level.
+type nodeKey struct {
+	level uint16
+	path  Key
+}
+
+// Tree represents a sparse merkle tree of 2^256 leaves, where only the nodes
+// of non-empty subtrees are kept in memory. Updating a single value rehashes
+// only the nodes along its path, regardless of the number of values in the tree.
+//
+// Tree created by Fork keeps only its own changes and reads everything else
+// from its parent, until the changes are committed to the parent.
+type Tree struct {
+	parent *Tree
+	nodes  map[nodeKey][32]byte
+}
+
+// New constructs a new, empty Tree.
+func New() *Tree {
+	return &Tree{nodes: make(map[nodeKey][32]byte)}
+}
+
+// Fork constructs a new Tree on top of the current one. Changes made to the
+// forked tree are not visible in the current tree until they are committed.
+// The current tree must not be changed while the forked tree is in use.
+func (t *Tree) Fork() *Tree {
+	return &Tree{parent: t, nodes: make(map[nodeKey][32]byte)}
+}
+
+// Commit moves all changes of the forked tree to its parent.
+func (t *Tree) Commit() error {
+	if t.parent == nil {
+		return errors.New("cannot commit tree without parent")
+	}
+	for key, hash := range t.nodes {
+		t.parent.set(key, hash)
+	}
+	t.nodes = make(map[nodeKey][32]byte)
+	return nil
+}
+
+// Root returns the root hash of the Tree.
+func (t *Tree) Root() []byte {
+	root := t.get(nodeKey{level: depth})
+	return root[:]
+}
+
+// Update sets the value stored under given key and rehashes its path up to the root.
+// Nil value removes the key from the Tree.
+func (t *Tree) Update(key Key, value []byte) {
+	hash := leafHash(key, value)
+
+	for level := 0; level < depth; level++ {
+		t.set(nodeKey{level: uint16(level), path: pathAt(key, level)}, hash)
+
+		sibling := t.get(nodeKey{level: uint16(level), path: pathAt(flipBit(key, level), level)})
+		if bitAt(key, level) == 0 {
+			hash = nodeHash(hash, sibling)
+		} else {
+			hash = nodeHash(sibling, hash)
+		}
+	}
+
+	t.set(nodeKey{level: depth}, hash)
+}
+
+// Proof represents the list of siblings along the path from the leaf to the root.
+// Siblings which are empty subtrees are skipped, the bitmap marks the levels of
+// siblings which are present.
+type Proof struct {
+	Bitmap   []byte
+	Siblings [][]byte
+}
+
+// Prove builds the Proof for the value stored under given key.
+func (t *Tree) Prove(key Key) Proof {
+	proof := Proof{Bitmap: make([]byte, depth/8)}
+
+	for level := 0; level < depth; level++ {
+		sibling := t.get(nodeKey{level: uint16(level), path: pathAt(flipBit(key, level), level)})
+		if sibling == defaults[level] {
+			continue
+		}
+		proof.Bitmap[level/8] |= 1 << (level % 8)
+		proof.Siblings = append(proof.Siblings, append([]byte(nil), sibling[:]...))
+	}
+
+	return proof
+}
+
+// Verify checks whether the value is stored under given key in the tree with given root.
+// Nil value verifies that the key is not present in the tree.
+func Verify(root []byte, key Key, value []byte, proof Proof) bool {
+	if len(proof.Bitmap) != depth/8 {
+		return false
+	}
+
+	hash := leafHash(key, value)
+	siblings := proof.Siblings
+
+	for level := 0; level < depth; level++ {
+		sibling := defaults[level]
+
+		if proof.Bitmap[level/8]&(1<<(level%8)) != 0 {
+			if len(siblings) == 0 || len(siblings[0]) != len(sibling) {
+				return false
+			}
+			copy(sibling[:], siblings[0])
+			siblings = siblings[1:]
+		}
+
+		if bitAt(key, level) == 0 {
+			hash = nodeHash(hash, sibling)
+		} else {
+			hash = nodeHash(sibling, hash)
+		}
+	}
+
+	return len(siblings) == 0 && bytes.Equal(hash[:], root)
+}
+
+// private API
+
+func (t *Tree) get(key nodeKey) [32]byte {
+	for tree := t; tree != nil; tree = tree.parent {
+		if hash, ok := tree.nodes[key]; ok {
+			return hash
+		}
+	}
+	return defaults[key.level]
+}
+
+func (t *Tree) set(key nodeKey, hash [32]byte) {
+
+	// The forked tree needs to keep empty subtrees as well,
+	// as they may hide the nodes which still exist in the parent.
+	if t.parent == nil && hash == defaults[key.level] {
+		delete(t.nodes, key)
+		return
+	}
+	t.nodes[key] = hash
+}
+
+func leafHash(key Key, value []byte) [32]byte {
+	if value == nil {
+		return defaults[0]
+	}
+	return sha256.Sum256(append(append([]byte{leafPrefix}, key[:]...), value...))
+}
+
+func nodeHash(left, right [32]byte) [32]byte {
+	return sha256.Sum256(append(append([]byte{nodePrefix}, left[:]...), right[:]...))
+}
+
+// bitAt returns the key bit deciding about the side of the node at given level,
+// where the level zero corresponds to the least significant bit of the key.
+func bitAt(key Key, level int) byte {
+	return (key[len(key)-1-level/8] >> (level % 8)) & 1
+}
+
+// flipBit returns the key with the bit at given level flipped.
+func flipBit(key Key, level int) Key {
+	key[len(key)-1-level/8] ^= 1 << (level % 8)
+	return key
+}
+
+// pathAt returns the key with all bits below given level cleared, which
+// identifies the node at that level.
+func pathAt(key Key, level int) Key {
+	full := level / 8
+	for i := 0; i < full; i++ {
+		key[len(key)-1-i] = 0
+	}
+	if rest := level % 8; rest > 0 {
+		key[len(key)-1-full] &^= byte(1<<rest) - 1
+	}
+	return key
+}
@@ -0,0 +1,81 @@
+package smt_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/smt"
+)
+
+func TestTree_UpdateAndVerify(t *testing.T) {
+	tree := smt.New()
+	emptyRoot := tree.Root()
+
+	key1 := smt.NewKey([]byte("account1"))
+	key2 := smt.NewKey([]byte("account2"))
+
+	// Ensure every update changes the root
+	tree.Update(key1, []byte("value1"))
+	root1 := tree.Root()
+	assert.NotEqual(t, emptyRoot, root1)
+
+	tree.Update(key2, []byte("value2"))
+	root2 := tree.Root()
+	assert.NotEqual(t, root1, root2)
+
+	// Ensure the proof verifies stored values only
+	proof := tree.Prove(key1)
+	assert.True(t, smt.Verify(root2, key1, []byte("value1"), proof))
+	assert.False(t, smt.Verify(root2, key1, []byte("value2"), proof))
+	assert.False(t, smt.Verify(root1, key1, []byte("value1"), proof))
+
+	// Ensure the proof verifies the absence of the key
+	key3 := smt.NewKey([]byte("account3"))
+	assert.True(t, smt.Verify(root2, key3, nil, tree.Prove(key3)))
+	assert.False(t, smt.Verify(root2, key3, []byte("value3"), tree.Prove(key3)))
+
+	// Ensure the root does not depend on the order of updates
+	other := smt.New()
+	other.Update(key2, []byte("value2"))
+	other.Update(key1, []byte("value1"))
+	assert.Equal(t, root2, other.Root())
+
+	// Ensure removing all keys brings the empty root back
+	single := smt.New()
+	single.Update(key2, []byte("value2"))
+
+	tree.Update(key1, nil)
+	assert.Equal(t, single.Root(), tree.Root())
+	assert.Equal(t, single.Prove(key2), tree.Prove(key2))
+	tree.Update(key2, nil)
+	assert.Equal(t, emptyRoot, tree.Root())
+}
+
+func TestTree_ForkAndCommit(t *testing.T) {
+	tree := smt.New()
+
+	key1 := smt.NewKey([]byte("account1"))
+	key2 := smt.NewKey([]byte("account2"))
+
+	tree.Update(key1, []byte("value1"))
+	root := tree.Root()
+
+	// Changes of the forked tree are not visible in its parent
+	fork := tree.Fork()
+	fork.Update(key1, nil)
+	fork.Update(key2, []byte("value2"))
+	forkRoot := fork.Root()
+	assert.NotEqual(t, root, forkRoot)
+	assert.Equal(t, root, tree.Root())
+
+	// Changes of the forked tree become visible after the commit
+	err := fork.Commit()
+	assert.Nil(t, err)
+	assert.Equal(t, forkRoot, tree.Root())
+	assert.True(t, smt.Verify(tree.Root(), key2, []byte("value2"), tree.Prove(key2)))
+	assert.True(t, smt.Verify(tree.Root(), key1, nil, tree.Prove(key1)))
+
+	// The tree without parent cannot be committed
+	err = tree.Commit()
+	assert.EqualError(t, err, "cannot commit tree without parent")
+}
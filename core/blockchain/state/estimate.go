@@ -0,0 +1,86 @@
+package state
+
+import (
+	"sort"
+
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+)
+
+// Settings of the tip estimate.
+const (
+	tipEstimateBlocks     = 20
+	tipEstimatePercentile = 60
+	minTipEstimate        = 1
+)
+
+// TipEstimate describes the tip which gives the transaction a good chance to be
+// included in one of the next blocks.
+type TipEstimate struct {
+
+	// Tip is the suggested tip, the higher one of the block and the mempool tips.
+	Tip uint64
+
+	// BlockTip is the percentile of the tips paid by transactions of the recent blocks.
+	BlockTip uint64
+
+	// MempoolTip is the tip required to outbid pending transactions when there are
+	// more of them than fits the next block, zero otherwise.
+	MempoolTip uint64
+
+	// Blocks is the number of recent blocks the estimate is based on.
+	Blocks int
+
+	// Pending is the number of pending transactions in the mempool.
+	Pending int
+}
+
+// NextNonce returns the next nonce the account by given account ID can use, which
+// accounts for the transactions of the account already waiting in the mempool.
+func (s *State) NextNonce(accountID database.AccountID) uint64 {
+	return s.mempool.NextNonce(s.account(accountID))
+}
+
+// EstimateTip returns the tip estimate derived from the tips of the recent blocks
+// and the current pressure on the mempool.
+func (s *State) EstimateTip() TipEstimate {
+	var estimate TipEstimate
+
+	// Collect tips of the recent blocks, pruned blocks end the collection.
+	var tips []uint64
+	for height := s.db.LastBlock().Height(); height > 0 && estimate.Blocks < tipEstimateBlocks; height-- {
+		block, err := s.db.ReadBlock(height)
+		if err != nil {
+			break
+		}
+		for _, tx := range block.Tree.Values() {
+			tips = append(tips, tx.Tip)
+		}
+		estimate.Blocks++
+	}
+	if len(tips) > 0 {
+		sort.Slice(tips, func(i, j int) bool { return tips[i] < tips[j] })
+		estimate.BlockTip = tips[(len(tips)-1)*tipEstimatePercentile/100]
+	}
+
+	// Outbid the last pending transaction which still fits the next block.
+	estimate.Pending = s.mempool.Stats().Pending
+	if best := s.mempool.PickBest(s.genesis.TxPerBlock); len(best) > 0 && len(best) == int(s.genesis.TxPerBlock) {
+		lowest := best[0].Tip
+		for _, tx := range best {
+			if tx.Tip < lowest {
+				lowest = tx.Tip
+			}
+		}
+		estimate.MempoolTip = lowest + 1
+	}
+
+	estimate.Tip = estimate.BlockTip
+	if estimate.MempoolTip > estimate.Tip {
+		estimate.Tip = estimate.MempoolTip
+	}
+	if estimate.Tip < minTipEstimate {
+		estimate.Tip = minTipEstimate
+	}
+
+	return estimate
+}
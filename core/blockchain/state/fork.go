@@ -0,0 +1,311 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+)
+
+// maxForkDepth defines how many blocks below the tip of the main chain
+// we keep side branch blocks around before they are pruned.
+const maxForkDepth = 64
+
+// ErrBlockKnown is returned when processed block is already part of the main
+// chain or one of the known side branches.
+var ErrBlockKnown = errors.New("block already known")
+
+// forkSet keeps track of blocks which do not belong to the main chain, but
+// may become part of it once their branch accumulates more work.
+type forkSet struct {
+	blocks map[string]database.Block
+}
+
+func newForkSet() *forkSet {
+	return &forkSet{blocks: make(map[string]database.Block)}
+}
+
+func (f *forkSet) add(block database.Block) {
+	f.blocks[block.Hash()] = block
+}
+
+func (f *forkSet) get(hash string) (database.Block, bool) {
+	block, ok := f.blocks[hash]
+	return block, ok
+}
+
+func (f *forkSet) remove(hash string) {
+	delete(f.blocks, hash)
+}
+
+// prune removes all blocks too deep below the tip of the main chain to ever
+// take part in the chain reorganization.
+func (f *forkSet) prune(tipHeight uint64) {
+	for hash, block := range f.blocks {
+		if block.Height()+maxForkDepth < tipHeight {
+			delete(f.blocks, hash)
+		}
+	}
+}
+
+// workCache keeps the work of the main chain blocks by their heights, so comparing
+// side branches with the main chain does not read the same blocks over and over again.
+type workCache struct {
+	works map[uint64]*big.Int
+}
+
+func newWorkCache() *workCache {
+	return &workCache{works: make(map[uint64]*big.Int)}
+}
+
+func (w *workCache) add(block database.Block) {
+	w.works[block.Height()] = block.Work()
+}
+
+func (w *workCache) get(height uint64) (*big.Int, bool) {
+	work, ok := w.works[height]
+	return work, ok
+}
+
+// truncate removes the work of all blocks above given height, which are not part
+// of the main chain anymore.
+func (w *workCache) truncate(height uint64) {
+	for h := range w.works {
+		if h > height {
+			delete(w.works, h)
+		}
+	}
+}
+
+// prune removes the work of all blocks too deep below the tip of the main chain
+// for any side branch to fork from.
+func (w *workCache) prune(tipHeight uint64) {
+	for h := range w.works {
+		if h+maxForkDepth < tipHeight {
+			delete(w.works, h)
+		}
+	}
+}
+
+// processBlock decides whether given block extends the main chain, becomes part
+// of a side branch or triggers the chain reorganization. It reports whether the
+// tip of the main chain has been changed. It must be called with s.mu held.
+func (s *State) processBlock(block database.Block) (bool, error) {
+	hash := block.Hash()
+
+	if _, ok := s.forks.get(hash); ok {
+		return false, ErrBlockKnown
+	}
+	if _, ok := s.mainChainBlock(hash, block.Height()); ok {
+		return false, ErrBlockKnown
+	}
+
+	// Block extends the tip of the main chain, which is the most common case.
+	if block.Header.PrevHash == s.db.LastBlock().Hash() {
+		if err := s.applyBlock(block); err != nil {
+			return false, err
+		}
+		s.removeFromMempool([]database.Block{block})
+		s.forks.prune(block.Height())
+		s.works.prune(block.Height())
+		return true, nil
+	}
+
+	// Otherwise, block has to be linked to the main chain or a side branch.
+	parent, ok := s.findBlock(block.Header.PrevHash, block.Height()-1)
+	if !ok {
+		return false, fmt.Errorf("parent block: %s of block: %d not found", block.Header.PrevHash, block.Height())
+	}
+
+	if err := block.ValidateHeader(parent); err != nil {
+		return false, err
+	}
+
+	s.forks.add(block)
+	s.ev("[STATE][processBlock][Block: %d added to side branch: %s]", block.Height(), hash)
+
+	branch, forkHeight := s.branch(block)
+
+	mainWork, err := s.mainChainWork(forkHeight)
+	if err != nil {
+		return false, err
+	}
+
+	// The first seen branch wins when both branches have the same amount of work.
+	if chainWork(branch).Cmp(mainWork) <= 0 {
+		return false, nil
+	}
+
+	if err := s.reorganize(forkHeight, branch); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// reorganize switches the main chain to the given branch starting right above
+// the fork height. Detached blocks are kept as a side branch and their
+// transactions are moved back to the mempool.
+func (s *State) reorganize(forkHeight uint64, branch []database.Block) error {
+	s.ev("[STATE][reorganize][Started at fork height: %d, branch len: %d]", forkHeight, len(branch))
+	defer s.ev("[STATE][reorganize][Finished]")
+
+	detached, err := s.rollback(forkHeight)
+	if err != nil {
+		return err
+	}
+
+	for i, block := range branch {
+		if err = s.applyBlock(block); err == nil {
+			continue
+		}
+
+		s.ev("[STATE][reorganize][Branch block: %d is invalid: %s]", block.Height(), err)
+
+		// The branch turned out to be invalid, so it cannot win ever again.
+		for _, invalid := range branch[i:] {
+			s.forks.remove(invalid.Hash())
+		}
+
+		// Restore the main chain we had before the reorganization.
+		if _, rerr := s.rollback(forkHeight); rerr != nil {
+			return fmt.Errorf("restore main chain err: %w", rerr)
+		}
+		for _, d := range detached {
+			if rerr := s.applyBlock(d); rerr != nil {
+				return fmt.Errorf("restore main chain err: %w", rerr)
+			}
+		}
+
+		return err
+	}
+
+	for _, block := range branch {
+		s.forks.remove(block.Hash())
+	}
+	for _, block := range detached {
+		s.forks.add(block)
+	}
+
+	// Transactions from the detached blocks need another chance to be mined,
+	// unless they have been already included into the new branch.
+	for _, block := range detached {
+		for _, tx := range block.Tree.Values() {
+			if _, err = s.upsertTx(tx); err != nil {
+				s.ev("[STATE][reorganize][Re-injecting tx failed: %s]", err)
+			}
+		}
+	}
+	s.removeFromMempool(branch)
+
+	s.forks.prune(s.db.LastBlock().Height())
+	s.works.prune(s.db.LastBlock().Height())
+
+	return nil
+}
+
+// applyBlock applies given block on top of the main chain and keeps its work.
+func (s *State) applyBlock(block database.Block) error {
+	if err := s.db.ApplyBlock(block); err != nil {
+		return err
+	}
+	s.works.add(block)
+	return nil
+}
+
+// rollback detaches the main chain blocks above given height and forgets their work.
+func (s *State) rollback(height uint64) ([]database.Block, error) {
+	detached, err := s.db.Rollback(height)
+	if err != nil {
+		return nil, err
+	}
+	s.works.truncate(height)
+	return detached, nil
+}
+
+// branch returns the side branch ending with given block in ascending order
+// together with the height of the main chain block it forks from.
+func (s *State) branch(block database.Block) ([]database.Block, uint64) {
+	branch := []database.Block{block}
+
+	for {
+		parent, ok := s.forks.get(branch[0].Header.PrevHash)
+		if !ok {
+			return branch, branch[0].Height() - 1
+		}
+		branch = append([]database.Block{parent}, branch...)
+	}
+}
+
+// findBlock looks for the block with given hash and height in the side
+// branches and on the main chain.
+func (s *State) findBlock(hash string, height uint64) (database.Block, bool) {
+	if block, ok := s.forks.get(hash); ok {
+		return block, true
+	}
+	return s.mainChainBlock(hash, height)
+}
+
+// mainChainBlock looks for the block with given hash and height on the main chain.
+func (s *State) mainChainBlock(hash string, height uint64) (database.Block, bool) {
+
+	// Height zero represents the genesis, which is the parent of the first block.
+	if height == 0 {
+		return database.Block{}, hash == database.Block{}.Hash()
+	}
+
+	if height > s.db.LastBlock().Height() {
+		return database.Block{}, false
+	}
+
+	block, err := s.db.ReadBlock(height)
+	if err != nil || block.Hash() != hash {
+		return database.Block{}, false
+	}
+
+	return block, true
+}
+
+// mainChainWork returns the work of the main chain blocks above given height.
+// Blocks are only read from the storage when their work is not known yet.
+func (s *State) mainChainWork(height uint64) (*big.Int, error) {
+	work := new(big.Int)
+
+	for h := height + 1; h <= s.db.LastBlock().Height(); h++ {
+		blockWork, ok := s.works.get(h)
+		if !ok {
+			block, err := s.db.ReadBlock(h)
+			if err != nil {
+				return nil, fmt.Errorf("main chain block: %d: %w", h, err)
+			}
+			s.works.add(block)
+			blockWork = block.Work()
+		}
+		work.Add(work, blockWork)
+	}
+
+	return work, nil
+}
+
+// removeFromMempool removes all transactions included in given blocks from the mempool
+// and reconciles the remaining ones with the accounts after the blocks are applied.
+func (s *State) removeFromMempool(blocks []database.Block) {
+	for _, block := range blocks {
+		for _, tx := range block.Tree.Values() {
+			if err := s.mempool.Remove(tx); err != nil {
+				continue
+			}
+		}
+	}
+	s.mempool.Update(s.db.Accounts())
+}
+
+// chainWork sums up the work of given blocks.
+func chainWork(blocks []database.Block) *big.Int {
+	work := new(big.Int)
+	for _, block := range blocks {
+		work.Add(work, block.Work())
+	}
+	return work
+}
@@ -0,0 +1,41 @@
+package state
+
+import "github.com/tchorzewski1991/fitbit/core/blockchain/mempool"
+
+// RotateJournal rewrites the mempool journal with the transactions currently waiting
+// in the mempool, dropping the ones which have been mined or evicted in the meantime.
+func (s *State) RotateJournal() error {
+	if s.journal == nil {
+		return nil
+	}
+	return s.journal.Rotate(s.mempool.Entries)
+}
+
+// private API
+
+// loadJournal restores the mempool from the journal under given path. Every journaled
+// transaction is validated again, as the chain could have moved on since it was written,
+// and keeps its original arrival time, so the ones which have outlived the mempool TTL
+// in the meantime are dropped.
+func (s *State) loadJournal(path string) error {
+	journal, err := mempool.OpenJournal(path)
+	if err != nil {
+		return err
+	}
+
+	loaded, err := journal.Load(func(entry mempool.JournalEntry) error {
+		if err := entry.Tx.Verify(s.genesis.ChainID); err != nil {
+			return nil
+		}
+		_, _ = s.mempool.UpsertAt(entry.Tx, s.account(entry.Tx.From), entry.Arrival)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.ev("[STATE][loadJournal][Restored %d tx out of %d journaled]", s.mempool.Size(), loaded)
+
+	s.journal = journal
+
+	return s.RotateJournal()
+}
@@ -2,8 +2,10 @@ package state
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 
 	"github.com/goccy/go-json"
@@ -11,12 +13,24 @@ import (
 	"github.com/tchorzewski1991/fitbit/core/blockchain/network"
 )
 
-// RequestPeerStatus sends HTTP request to the given peer for the status info.
+// RequestPeerStatus sends request to the given peer for the status info. The peer transport
+// is used when possible, with the HTTP request as a fallback.
 func (s *State) RequestPeerStatus(peer network.Peer) (network.PeerStatus, error) {
 	s.ev("[STATE][RequestPeerStatus][Started new request to: %s]", peer.Host)
 	defer s.ev("[STATE][RequestPeerStatus][Request to: %s finished]", peer.Host)
 
-	response, err := sendRequest(http.MethodGet, fmt.Sprintf(peerStatusEndpoint, peer.Host), nil)
+	if s.transport != nil {
+		response, err := s.transport.Request(peer, network.Message{Type: network.MsgStatusRequest})
+		if err == nil {
+			var ps network.PeerStatus
+			if err = json.Unmarshal(response.Payload, &ps); err == nil {
+				return ps, nil
+			}
+		}
+		s.ev("[STATE][RequestPeerStatus][Transport request to: %s failed, falling back to HTTP: %s]", peer.Host, err)
+	}
+
+	response, _, err := sendRequest(http.MethodGet, fmt.Sprintf(peerStatusEndpoint, peer.Host), jsonMediaType, nil)
 	if err != nil {
 		s.ev("[STATE][RequestPeerStatus][Got request err: %s]", err)
 		return network.PeerStatus{}, err
@@ -37,14 +51,20 @@ func (s *State) RequestPeerMempool(peer network.Peer) ([]database.BlockTx, error
 	s.ev("[STATE][RequestPeerTxs][Started new request to: %s]", peer.Host)
 	defer s.ev("[STATE][RequestPeerTxs][Request to: %s finished]", peer.Host)
 
-	response, err := sendRequest(http.MethodGet, fmt.Sprintf(peerMempoolEndpoint, peer.Host), nil)
+	response, mediaType, err := sendRequest(http.MethodGet, fmt.Sprintf(peerMempoolEndpoint, peer.Host), database.BinaryMediaType, nil)
 	if err != nil {
 		s.ev("[STATE][RequestPeerTxs][Got request err: %s]", err)
 		return nil, err
 	}
 
+	// Peers which do not support the binary codec respond with JSON.
 	var txs []database.BlockTx
-	err = json.Unmarshal(response, &txs)
+	switch mediaType {
+	case database.BinaryMediaType:
+		txs, err = database.UnmarshalTxsBinary(response)
+	default:
+		err = json.Unmarshal(response, &txs)
+	}
 	if err != nil {
 		s.ev("[STATE][RequestPeerTxs][Got request err: %s]", err)
 		return nil, err
@@ -64,14 +84,20 @@ func (s *State) RequestPeerBlocks(peer network.Peer) ([]database.Block, error) {
 		from += 1
 	}
 
-	response, err := sendRequest(http.MethodGet, fmt.Sprintf(peerBlocksEndpoint, peer.Host, from), nil)
+	response, mediaType, err := sendRequest(http.MethodGet, fmt.Sprintf(peerBlocksEndpoint, peer.Host, from), database.BinaryMediaType, nil)
 	if err != nil {
 		s.ev("[STATE][RequestPeerBlocks][Got request err: %s]", err)
 		return nil, err
 	}
 
+	// Peers which do not support the binary codec respond with JSON.
 	var blocksData []database.BlockData
-	err = json.Unmarshal(response, &blocksData)
+	switch mediaType {
+	case database.BinaryMediaType:
+		blocksData, err = database.UnmarshalBlocksBinary(response)
+	default:
+		err = json.Unmarshal(response, &blocksData)
+	}
 	if err != nil {
 		s.ev("[STATE][RequestPeerBlocks][Got request err: %s]", err)
 		return nil, err
@@ -89,12 +115,13 @@ func (s *State) RequestPeerBlocks(peer network.Peer) ([]database.Block, error) {
 	return blocks, err
 }
 
-// SendBlockToPeers sends HTTP request to all known peers with given block.
+// SendBlockToPeers sends given block to all known peers.
 func (s *State) SendBlockToPeers(block database.Block) error {
 	s.ev("[STATE][SendBlockToPeers][Sending started]")
 	defer s.ev("[STATE][SendBlockToPeers][Sending finished]")
 
-	data, err := json.Marshal(block.ToBlockData())
+	blockData := block.ToBlockData()
+	data, err := blockData.MarshalBinary()
 	if err != nil {
 		s.ev("[STATE][SendBlockToPeers][Preparing block data to send failed: %s]", err)
 		return err
@@ -103,7 +130,9 @@ func (s *State) SendBlockToPeers(block database.Block) error {
 	for _, peer := range s.ExternalPeers() {
 		s.ev("[STATE][SendBlockToPeers][Started new request to: %s]", peer.Host)
 		{
-			_, err = sendRequest(http.MethodPost, fmt.Sprintf(submitBlockEndpoint, peer.Host), data)
+			err = s.sendToPeer(peer, network.Message{Type: network.MsgBlock, Payload: data}, func() error {
+				return submitToPeer(fmt.Sprintf(submitBlockEndpoint, peer.Host), data, blockData)
+			})
 			if err != nil {
 				s.ev("[STATE][SendBlockToPeers][Got request err: %s]", err)
 				continue
@@ -115,12 +144,12 @@ func (s *State) SendBlockToPeers(block database.Block) error {
 	return nil
 }
 
-// SendTxToPeers sends HTTP request to all known peers with given transaction.
+// SendTxToPeers sends given transaction to all known peers.
 func (s *State) SendTxToPeers(tx database.BlockTx) error {
 	s.ev("[STATE][SendTxToPeers][Sending started]")
 	defer s.ev("[STATE][SendTxToPeers][Sending finished]")
 
-	data, err := json.Marshal(tx)
+	data, err := tx.MarshalBinary()
 	if err != nil {
 		s.ev("[STATE][SendTxToPeers][Preparing tx to send failed: %s]", err)
 		return err
@@ -129,7 +158,9 @@ func (s *State) SendTxToPeers(tx database.BlockTx) error {
 	for _, peer := range s.ExternalPeers() {
 		s.ev("[STATE][SendTxToPeers][Started new request to: %s]", peer.Host)
 		{
-			_, err = sendRequest(http.MethodPost, fmt.Sprintf(submitTxEndpoint, peer.Host), data)
+			err = s.sendToPeer(peer, network.Message{Type: network.MsgTx, Payload: data}, func() error {
+				return submitToPeer(fmt.Sprintf(submitTxEndpoint, peer.Host), data, tx)
+			})
 			if err != nil {
 				s.ev("[STATE][SendTxToPeers][Got request err: %s]", err)
 				continue
@@ -141,7 +172,7 @@ func (s *State) SendTxToPeers(tx database.BlockTx) error {
 	return nil
 }
 
-// SendNodeReady sends HTTP request to all knows peers with info about node readiness.
+// SendNodeReady sends info about node readiness to all known peers.
 func (s *State) SendNodeReady() {
 	s.ev("[STATE][SendNodeReady][Sending started]")
 	defer s.ev("[STATE][SendNodeReady][Sending finished]")
@@ -152,11 +183,19 @@ func (s *State) SendNodeReady() {
 		s.ev("[STATE][SendNodeReady][Failed to encode host: %s]", err)
 		return
 	}
+	peers, err := json.Marshal([]network.Peer{host})
+	if err != nil {
+		s.ev("[STATE][SendNodeReady][Failed to encode host: %s]", err)
+		return
+	}
 
 	for _, peer := range s.ExternalPeers() {
 		s.ev("[STATE][SendNodeReady][Started new request to: %s]", peer.Host)
 		{
-			_, err = sendRequest(http.MethodPost, fmt.Sprintf(submitPeerEndpoint, peer.Host), data)
+			err = s.sendToPeer(peer, network.Message{Type: network.MsgPeers, Payload: peers}, func() error {
+				_, _, err := sendRequest(http.MethodPost, fmt.Sprintf(submitPeerEndpoint, peer.Host), jsonMediaType, data)
+				return err
+			})
 			if err != nil {
 				s.ev("[STATE][SendNodeReady][Got request err: %s]", err)
 			}
@@ -176,16 +215,45 @@ const (
 	submitTxEndpoint    = "http://%s/v1/node/tx"
 )
 
-func sendRequest(method string, url string, body []byte) ([]byte, error) {
+// jsonMediaType is the media type of the requests which are not encoded with the binary codec.
+const jsonMediaType = "application/json"
+
+// errMediaTypeRejected is returned when the peer rejects the request body encoded
+// with the binary codec as unsupported, e.g. because of the unknown codec version.
+var errMediaTypeRejected = errors.New("binary media type rejected")
+
+// submitToPeer posts the data encoded with the binary codec to the peer. Whenever the
+// peer rejects it, the value is posted once again encoded with JSON.
+func submitToPeer(url string, data []byte, value any) error {
+	_, _, err := sendRequest(http.MethodPost, url, database.BinaryMediaType, data)
+	if !errors.Is(err, errMediaTypeRejected) {
+		return err
+	}
+
+	data, err = json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, _, err = sendRequest(http.MethodPost, url, jsonMediaType, data)
+	return err
+}
+
+// sendRequest sends the body encoded with given media type and asks for the response
+// encoded the same way. It returns the response body together with its media type.
+func sendRequest(method string, url string, mediaType string, body []byte) ([]byte, string, error) {
 
 	req, err := http.NewRequest(method, url, bytes.NewReader(body))
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	req.Header.Set("Accept", mediaType)
+	if body != nil {
+		req.Header.Set("Content-Type", mediaType)
 	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer func() {
 		_ = resp.Body.Close()
@@ -193,8 +261,22 @@ func sendRequest(method string, url string, body []byte) ([]byte, error) {
 
 	bs, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	// Only the blocks are gone on the peer side, which means they have been pruned.
+	if resp.StatusCode == http.StatusGone {
+		return nil, "", fmt.Errorf("%w: %s", database.ErrBlockPruned, bs)
 	}
+	// Peers which do not support the binary codec reject the binary body as unsupported.
+	if resp.StatusCode == http.StatusUnsupportedMediaType && body != nil && mediaType == database.BinaryMediaType {
+		return nil, "", fmt.Errorf("%w: status: %d: %s", errMediaTypeRejected, resp.StatusCode, bs)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, "", fmt.Errorf("request failed with status: %d: %s", resp.StatusCode, bs)
+	}
+
+	respMediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
 
-	return bs, nil
+	return bs, respMediaType, nil
 }
@@ -0,0 +1,90 @@
+package state
+
+import (
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+)
+
+// Simulation describes the outcome of the transaction applied to a copy of the state.
+type Simulation struct {
+	Status   string
+	Error    string
+	GasUsed  uint64
+	GasFee   uint64
+	Tip      uint64
+	Accounts []AccountChange
+}
+
+// AccountChange describes the account touched by the simulated transaction.
+type AccountChange struct {
+	Before database.Account
+	After  database.Account
+}
+
+// SimulateTx runs the wallet transaction through the same rules the mined transactions
+// are applied with, against a copy of the current state including the effects of the
+// pending mempool transactions. Neither the state nor the mempool is changed.
+func (s *State) SimulateTx(signedTx database.SignedTx) Simulation {
+	tx := database.NewBlockTx(signedTx, s.genesis.GasPrice, oneUnitOfGas)
+
+	accounts := s.db.Accounts()
+
+	// Apply pending transactions in the order of their nonces, except the ones the
+	// simulated transaction replaces or precedes. Once the transaction of an account
+	// fails, none of its following transactions can be executed either.
+	failed := make(map[database.AccountID]bool)
+	for _, pending := range s.mempool.Pending() {
+		if failed[pending.From] {
+			continue
+		}
+		if pending.From == tx.From && pending.Nonce >= tx.Nonce {
+			continue
+		}
+		if err := accounts.ApplyTransaction(s.beneficiaryID, pending); err != nil {
+			failed[pending.From] = true
+		}
+	}
+
+	// Keep the touched accounts before the transaction is applied.
+	ids := []database.AccountID{tx.From, tx.To, s.beneficiaryID}
+	var changes []AccountChange
+	seen := make(map[database.AccountID]bool)
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		changes = append(changes, AccountChange{Before: accountOf(accounts, id)})
+	}
+
+	sim := Simulation{Status: database.ReceiptStatusSuccess}
+
+	err := tx.Verify(s.genesis.ChainID)
+	if err == nil {
+		err = accounts.ApplyTransaction(s.beneficiaryID, tx)
+	}
+	if err != nil {
+		sim.Status = database.ReceiptStatusFailed
+		sim.Error = err.Error()
+	} else {
+		sim.GasUsed = tx.GasUnits
+		sim.GasFee = tx.GasPrice * tx.GasUnits
+		sim.Tip = tx.Tip
+	}
+
+	for i := range changes {
+		changes[i].After = accountOf(accounts, changes[i].Before.ID)
+	}
+	sim.Accounts = changes
+
+	return sim
+}
+
+// accountOf returns the account by given ID from the accounts, or an empty account
+// if it does not exist yet.
+func accountOf(accounts database.Accounts, accountID database.AccountID) database.Account {
+	acc, ok := accounts[accountID]
+	if !ok {
+		return database.Account{ID: accountID}
+	}
+	return acc
+}
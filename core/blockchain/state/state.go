@@ -2,7 +2,10 @@ package state
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
 	"github.com/tchorzewski1991/fitbit/core/blockchain/genesis"
@@ -30,6 +33,31 @@ type Config struct {
 	Storage       database.Storage
 	EventHandler  EventHandler
 	KnownPeers    *network.PeerSet
+
+	// SnapshotInterval, FullReplay, Repair and PruneDepth control how the database
+	// state is persisted and restored, see database.Config for details.
+	SnapshotInterval uint64
+	FullReplay       bool
+	Repair           bool
+	PruneDepth       uint64
+
+	// PriceBump is the minimum tip increase, in percent, required to replace
+	// the uncommitted transaction with the same nonce.
+	PriceBump uint64
+
+	// MempoolCapacity, MempoolAccountLimit and MempoolTTL bound the number of
+	// uncommitted transactions and their lifetime, see mempool.Config for details.
+	MempoolCapacity     int
+	MempoolAccountLimit int
+	MempoolTTL          time.Duration
+
+	// MempoolJournal is the path of the file uncommitted transactions are persisted in,
+	// so they are restored after the restart. Empty path disables the journal.
+	MempoolJournal string
+
+	// PeerTransport enables the long-lived connections to the peers, which carry blocks,
+	// transactions, status and peers. The HTTP private API stays as a fallback.
+	PeerTransport bool
 }
 
 // State holds all blockchain dependencies and provides core API.
@@ -41,8 +69,12 @@ type State struct {
 
 	genesis    genesis.Genesis
 	mempool    *mempool.Mempool
+	journal    *mempool.Journal
 	db         *database.Database
+	forks      *forkSet
+	works      *workCache
 	knownPeers *network.PeerSet
+	transport  *network.Transport
 	ev         EventHandler
 
 	worker Worker
@@ -51,25 +83,64 @@ type State struct {
 // New constructs a new State.
 func New(cfg Config) (*State, error) {
 
-	db, err := database.New(cfg.Genesis, cfg.Storage)
-	if err != nil {
-		return nil, err
-	}
-
 	if cfg.EventHandler == nil {
 		// Set no-op event handler if event handler has not been set.
 		cfg.EventHandler = func(s string, args ...any) {}
 	}
 
-	return &State{
+	db, err := database.New(database.Config{
+		Genesis:          cfg.Genesis,
+		Storage:          cfg.Storage,
+		EventHandler:     cfg.EventHandler,
+		SnapshotInterval: cfg.SnapshotInterval,
+		FullReplay:       cfg.FullReplay,
+		Repair:           cfg.Repair,
+		PruneDepth:       cfg.PruneDepth,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ev := cfg.EventHandler
+	mp := mempool.New(mempool.Config{
+		PriceBump:    cfg.PriceBump,
+		Capacity:     cfg.MempoolCapacity,
+		AccountLimit: cfg.MempoolAccountLimit,
+		TTL:          cfg.MempoolTTL,
+		EvictHandler: func(e mempool.Eviction) {
+			ev("[STATE][mempool][Evicted tx from: %s nonce: %d reason: %s]", e.Tx.From, e.Tx.Nonce, e.Reason)
+		},
+	})
+
+	s := State{
 		beneficiaryID: cfg.BeneficiaryID,
 		host:          cfg.Host,
 		genesis:       cfg.Genesis,
-		mempool:       mempool.New(),
+		mempool:       mp,
 		db:            db,
+		forks:         newForkSet(),
+		works:         newWorkCache(),
 		knownPeers:    cfg.KnownPeers,
 		ev:            cfg.EventHandler,
-	}, nil
+	}
+
+	if cfg.PeerTransport {
+		s.transport = network.NewTransport(network.TransportConfig{
+			Host:         cfg.Host,
+			Endpoint:     peerTransportEndpoint,
+			Handler:      s.handlePeerMessage,
+			EventHandler: network.EventHandler(ev),
+		})
+	}
+
+	if cfg.MempoolJournal != "" {
+		if err = s.loadJournal(cfg.MempoolJournal); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("load mempool journal err: %w", err)
+		}
+	}
+
+	return &s, nil
 }
 
 func (s *State) RegisterWorker(worker Worker) {
@@ -87,6 +158,19 @@ func (s *State) Shutdown() error {
 	// Make sure all blockchain activity is properly stopped.
 	s.worker.Shutdown()
 
+	// Make sure connections to the peers are closed.
+	if s.transport != nil {
+		s.transport.Close()
+	}
+
+	// Make sure the journal keeps only the transactions still waiting to be mined.
+	if s.journal != nil {
+		if err := s.RotateJournal(); err != nil {
+			return err
+		}
+		return s.journal.Close()
+	}
+
 	return nil
 }
 
@@ -105,26 +189,54 @@ func (s *State) Account(accountID database.AccountID) (database.Account, error)
 	return s.db.Account(accountID)
 }
 
+// AccountsAt returns a copy of all database accounts as they were at given height.
+func (s *State) AccountsAt(height uint64) (database.Accounts, error) {
+	return s.db.AccountsAt(height)
+}
+
+// AccountAt returns a copy of an account requested by given account ID as it was at given height.
+func (s *State) AccountAt(accountID database.AccountID, height uint64) (database.Account, error) {
+	return s.db.AccountAt(accountID, height)
+}
+
+// AccountProof returns a copy of an account requested by given account ID
+// together with the proof of its inclusion in the state root of the last block.
+func (s *State) AccountProof(accountID database.AccountID) (database.AccountProof, error) {
+	return s.db.AccountProof(accountID)
+}
+
+// AccountTxs returns a page of the committed transactions touching the account by given account ID.
+func (s *State) AccountTxs(accountID database.AccountID, query database.AccountTxQuery) ([]database.AccountTx, string, error) {
+	return s.db.AccountTxs(accountID, query)
+}
+
+// Tx returns a copy of the committed transaction by given hash together with its receipt.
+func (s *State) Tx(hash string) (database.BlockTx, database.Receipt, error) {
+	return s.db.Tx(hash)
+}
+
+// Receipt returns the receipt of the committed transaction by given hash.
+func (s *State) Receipt(hash string) (database.Receipt, error) {
+	return s.db.Receipt(hash)
+}
+
 // QueryBlocksByHeight returns a copy of blocks by given height range.
 func (s *State) QueryBlocksByHeight(from, to uint64) ([]database.Block, error) {
 	s.ev("[STATE][QueryBlocksByHeight][Start querying blocks from %d, to: %d]", from, to)
 	defer s.ev("[STATE][QueryBlocksByHeight][Querying blocks finished]")
 
-	var blocks []database.Block
-
-	for i := from; i <= to; i++ {
-		block, err := s.db.ReadBlock(i)
-		if err != nil {
-			return nil, err
-		}
-		blocks = append(blocks, block)
-	}
+	return s.db.ReadBlocks(from, to)
+}
 
-	return blocks, nil
+// QueryBlockByHash returns a copy of the block by given hash.
+func (s *State) QueryBlockByHash(hash string) (database.Block, error) {
+	return s.db.ReadBlockByHash(hash)
 }
 
-// UpsertWalletTx adds a new wallet transaction to the mempool.
-func (s *State) UpsertWalletTx(signedTx database.SignedTx) error {
+// UpsertWalletTx adds a new wallet transaction to the mempool. It returns the hash of
+// the transaction, which allows for tracking its status, together with the outcome
+// telling whether the transaction has been added or has replaced the one with the same nonce.
+func (s *State) UpsertWalletTx(signedTx database.SignedTx) (Submission, error) {
 
 	// Convert signed tx to proper format.
 	tx := database.NewBlockTx(signedTx, s.genesis.GasPrice, oneUnitOfGas)
@@ -132,13 +244,19 @@ func (s *State) UpsertWalletTx(signedTx database.SignedTx) error {
 	// Verify whether tx has a proper signature and data.
 	err := tx.Verify(s.genesis.ChainID)
 	if err != nil {
-		return err
+		return Submission{Outcome: mempool.Rejected}, err
 	}
 
-	// Upsert tx to the mempool.
-	err = s.mempool.Upsert(tx)
+	// Upsert tx to the mempool, validating it against the sender account.
+	outcome, err := s.upsertTx(tx)
 	if err != nil {
-		return err
+		return Submission{Outcome: outcome}, err
+	}
+
+	// Known tx has been already shared and scheduled for mining.
+	if outcome == mempool.Known {
+		hash, _ := s.mempool.HashOf(tx)
+		return Submission{Hash: hash, Outcome: outcome}, nil
 	}
 
 	// Share tx with other peers to let them have a chance to mine a new block.
@@ -147,7 +265,7 @@ func (s *State) UpsertWalletTx(signedTx database.SignedTx) error {
 	// Start mining of the new block on local node.
 	s.worker.StartMining()
 
-	return nil
+	return Submission{Hash: tx.HexHash(), Outcome: outcome}, nil
 }
 
 // UpsertNodeTx adds a new node transaction to the mempool.
@@ -159,8 +277,8 @@ func (s *State) UpsertNodeTx(tx database.BlockTx) error {
 		return err
 	}
 
-	// Upsert tx to the mempool.
-	err = s.mempool.Upsert(tx)
+	// Upsert tx to the mempool, validating it against the sender account.
+	_, err = s.upsertTx(tx)
 	if err != nil {
 		return err
 	}
@@ -186,23 +304,48 @@ func (s *State) MempoolSize() int {
 	return s.mempool.Size()
 }
 
+// MempoolStats returns the current number of pending and queued transactions in the mempool.
+func (s *State) MempoolStats() mempool.Stats {
+	return s.mempool.Stats()
+}
+
+// EvictedTx returns the most recent evictions of uncommitted transactions from the mempool.
+func (s *State) EvictedTx() []mempool.Eviction {
+	return s.mempool.Evictions()
+}
+
+// ExpireTx drops uncommitted transactions which have not been mined within the time-to-live.
+func (s *State) ExpireTx() int {
+	return s.mempool.Expire(time.Now())
+}
+
 // MineBlock attempts to create a new block using POW consensus algorithm.
 func (s *State) MineBlock(ctx context.Context) (database.Block, error) {
 	s.ev("[STATE][MineBlock][Started new mining]")
 	defer s.ev("[STATE][MineBlock][Mining finished]")
 
 	// Prepare all data necessary for the next block to be mined.
-	txs := s.mempool.Select(mempool.SelectAll())
 	prevBlock := s.db.LastBlock()
-	prevStateRoot := s.db.StateRoot()
+	accounts, txs := s.selectTxs()
+	if len(txs) == 0 {
+		return database.Block{}, errors.New("no valid transactions to mine")
+	}
+
+	// The header commits to the accounts state after the block is applied.
+	accounts.ApplyMiningReward(s.beneficiaryID, s.genesis.MiningReward)
+
+	target, err := s.db.NextTarget()
+	if err != nil {
+		return database.Block{}, err
+	}
 
 	// Mine the block by using Proof of Work consensus algorithm.
 	block, err := database.POW(ctx, database.POWArgs{
 		BeneficiaryID: s.beneficiaryID,
-		Difficulty:    s.genesis.Difficulty,
+		Target:        target,
 		Reward:        s.genesis.MiningReward,
 		PrevBlock:     prevBlock,
-		StateRoot:     prevStateRoot,
+		StateRoot:     s.db.CalcStateRoot(accounts),
 		Txs:           txs,
 		Ev:            s.ev,
 	})
@@ -210,77 +353,109 @@ func (s *State) MineBlock(ctx context.Context) (database.Block, error) {
 		return database.Block{}, err
 	}
 
-	// Validate and write the block to the underlying storage.
 	s.mu.Lock()
-	{
-		if err = block.Validate(prevBlock, prevStateRoot); err != nil {
-			return database.Block{}, err
-		}
-
-		if err = s.db.WriteBlock(block); err != nil {
-			return database.Block{}, err
-		}
-
-		s.db.UpdateLastBlock(block)
+	defer s.mu.Unlock()
 
-		// Apply mining reward to the node's beneficiary account.
-		s.db.ApplyMiningReward(block)
-
-		// Process the transactions and apply balance changes to accounts.
-		for _, tx := range txs {
-			if err = s.mempool.Remove(tx); err != nil {
-				continue
-			}
+	// The tip of the chain might have changed while we were mining,
+	// in which case the mined block is not valid anymore.
+	if s.db.LastBlock().Hash() != prevBlock.Hash() {
+		return database.Block{}, errors.New("chain tip changed during mining")
+	}
 
-			if err = s.db.ApplyTransaction(block, tx); err != nil {
-				continue
-			}
-		}
+	// Validate, write and apply the block to the database.
+	if err = s.applyBlock(block); err != nil {
+		return database.Block{}, err
 	}
-	s.mu.Unlock()
+
+	s.removeFromMempool([]database.Block{block})
+	s.forks.prune(block.Height())
+	s.works.prune(block.Height())
 
 	return block, nil
 }
 
-// ProcessBlock attempts to create a new block after receiving it from other peers.
+// ProcessBlock attempts to add a new block after receiving it from other peers.
+// Block either extends the main chain, is kept as part of a side branch or
+// makes its branch the main chain when that branch has more cumulative work.
 func (s *State) ProcessBlock(block database.Block) error {
 	s.ev("[STATE][ProcessBlock][Processing new block]")
 	defer s.ev("[STATE][ProcessBlock][Block processing finished]")
 
-	prevBlock := s.db.LastBlock()
-	prevStateRoot := s.db.StateRoot()
-
 	s.mu.Lock()
-	{
-		if err := block.Validate(prevBlock, prevStateRoot); err != nil {
-			return err
-		}
+	tipChanged, err := s.processBlock(block)
+	s.mu.Unlock()
 
-		if err := s.db.WriteBlock(block); err != nil {
-			return err
-		}
+	if err != nil {
+		return err
+	}
 
-		s.db.UpdateLastBlock(block)
+	// Current mining is based on the old tip, so it needs to be restarted.
+	if tipChanged {
+		s.worker.StopMining()
+	}
 
-		// Apply mining reward to the node's beneficiary account.
-		s.db.ApplyMiningReward(block)
+	return nil
+}
 
-		// Process the transactions and apply balance changes to accounts.
-		for _, tx := range block.Tree.Values() {
-			if err := s.mempool.Remove(tx); err != nil {
-				continue
-			}
+// selectTxs picks the best transactions from the mempool and applies them to
+// a copy of the accounts, so the mined block is not rejected because of a single
+// invalid transaction. Invalid transactions are discarded from the mempool, while
+// the following transactions of the same account are moved back to the queue.
+// Accounts after applying selected transactions are returned as well.
+func (s *State) selectTxs() (database.Accounts, []database.BlockTx) {
+	accounts := s.db.Accounts()
 
-			if err := s.db.ApplyTransaction(block, tx); err != nil {
-				continue
-			}
+	var txs []database.BlockTx
+	failed := make(map[database.AccountID]bool)
+
+	for _, tx := range s.mempool.PickBest(s.genesis.TxPerBlock) {
+		if failed[tx.From] {
+			continue
+		}
+		err := tx.Verify(s.genesis.ChainID)
+		if err == nil {
+			err = accounts.ApplyTransaction(s.beneficiaryID, tx)
+		}
+		if err != nil {
+			s.ev("[STATE][selectTxs][Dropping invalid tx from: %s nonce: %d: %s]", tx.From, tx.Nonce, err)
+			s.mempool.Discard(tx, err)
+			failed[tx.From] = true
+			continue
 		}
+		txs = append(txs, tx)
 	}
-	s.mu.Unlock()
 
-	s.worker.StopMining()
+	if len(failed) > 0 {
+		s.mempool.Update(s.db.Accounts())
+	}
 
-	return nil
+	return accounts, txs
+}
+
+// upsertTx adds the transaction to the mempool, validating it against the sender
+// account, and appends the accepted transaction to the journal.
+func (s *State) upsertTx(tx database.BlockTx) (mempool.Outcome, error) {
+	arrival := time.Now()
+	outcome, err := s.mempool.UpsertAt(tx, s.account(tx.From), arrival)
+	if err != nil || outcome == mempool.Known || s.journal == nil {
+		return outcome, err
+	}
+
+	if err = s.journal.Append(mempool.JournalEntry{Tx: tx, Arrival: arrival}); err != nil {
+		s.ev("[STATE][upsertTx][Journaling tx from: %s nonce: %d failed: %s]", tx.From, tx.Nonce, err)
+	}
+
+	return outcome, nil
+}
+
+// account returns a copy of the account by given account ID, or an empty
+// account if it does not exist yet.
+func (s *State) account(accountID database.AccountID) database.Account {
+	account, err := s.db.Account(accountID)
+	if err != nil {
+		return database.Account{ID: accountID}
+	}
+	return account
 }
 
 // KnownPeers returns a copy of all known peers.
@@ -298,8 +473,11 @@ func (s *State) AddPeer(peer network.Peer) bool {
 	return s.knownPeers.Add(peer)
 }
 
-// DeletePeer removes given peer from the list of known peers.
+// DeletePeer removes given peer from the list of known peers and closes the connection to it.
 func (s *State) DeletePeer(peer network.Peer) bool {
+	if s.transport != nil {
+		s.transport.Disconnect(peer)
+	}
 	return s.knownPeers.Delete(peer)
 }
 
@@ -0,0 +1,608 @@
+package state_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/genesis"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/mempool"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/network"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/state"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/storage/memory"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/testdata"
+)
+
+func TestState_ProcessBlockReorganization(t *testing.T) {
+	alice := testdata.LoadPrivateKey(t)
+	bob := generatePrivateKey(t)
+	gen := mockGenesis(t, alice, bob)
+
+	s := mockState(t, gen)
+
+	// Two independent miners build their own branches
+	minerA := mockDatabase(t, gen)
+	minerB := mockDatabase(t, gen)
+
+	// Miner A finds the first block, which extends the main chain
+	a1 := mineBlock(t, minerA, "0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0", mockBlockTx(t, alice, 1))
+	err := s.ProcessBlock(a1)
+	assert.Nil(t, err)
+	assert.Equal(t, a1.Hash(), s.LastBlock().Hash())
+
+	// Processing the same block again is reported as known
+	err = s.ProcessBlock(a1)
+	assert.ErrorIs(t, err, state.ErrBlockKnown)
+
+	// Miner B finds the competing block at the same height, which is kept aside
+	b1 := mineBlock(t, minerB, "0x0ee5ba68586c85880B0900D0dEe0eEcBB37010e0", mockBlockTx(t, bob, 1))
+	err = s.ProcessBlock(b1)
+	assert.Nil(t, err)
+	assert.Equal(t, a1.Hash(), s.LastBlock().Hash())
+
+	// Miner B extends its branch, which now has more work than the main chain
+	b2 := mineBlock(t, minerB, "0x0ee5ba68586c85880B0900D0dEe0eEcBB37010e0", mockBlockTx(t, bob, 2))
+	err = s.ProcessBlock(b2)
+	assert.Nil(t, err)
+	assert.Equal(t, b2.Hash(), s.LastBlock().Hash())
+	assert.Equal(t, minerB.Accounts(), s.Accounts())
+
+	// Transaction from the detached block goes back to the mempool
+	txs := s.UncommittedTx()
+	assert.Equal(t, 1, len(txs))
+	assert.True(t, txs[0].Equals(a1.Tree.Values()[0]))
+
+	// Miner A catches up, but its branch has the same work, so the first seen branch wins
+	a2 := mineBlock(t, minerA, "0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0", mockBlockTx(t, alice, 2))
+	err = s.ProcessBlock(a2)
+	assert.Nil(t, err)
+	assert.Equal(t, b2.Hash(), s.LastBlock().Hash())
+
+	// Miner A takes the lead with one more block and the chain reorganizes back
+	a3 := mineBlock(t, minerA, "0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0", mockBlockTx(t, alice, 3))
+	err = s.ProcessBlock(a3)
+	assert.Nil(t, err)
+	assert.Equal(t, a3.Hash(), s.LastBlock().Hash())
+	assert.Equal(t, minerA.Accounts(), s.Accounts())
+}
+
+func TestState_ProcessBlockUnknownParent(t *testing.T) {
+	alice := testdata.LoadPrivateKey(t)
+	gen := mockGenesis(t, alice, generatePrivateKey(t))
+
+	s := mockState(t, gen)
+	miner := mockDatabase(t, gen)
+
+	// Miner finds two blocks, but only the second one reaches the node
+	_ = mineBlock(t, miner, "0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0", mockBlockTx(t, alice, 1))
+	block := mineBlock(t, miner, "0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0", mockBlockTx(t, alice, 2))
+
+	err := s.ProcessBlock(block)
+	assert.NotNil(t, err)
+	assert.Equal(t, uint64(0), s.LastBlock().Height())
+}
+
+func TestState_UpsertWalletTx(t *testing.T) {
+	alice := testdata.LoadPrivateKey(t)
+	gen := mockGenesis(t, alice)
+
+	s := mockState(t, gen)
+
+	// Submit the new tx
+	tx := mockBlockTx(t, alice, 1)
+	sub, err := s.UpsertWalletTx(tx.SignedTx)
+	assert.Nil(t, err)
+	assert.Equal(t, mempool.Added, sub.Outcome)
+	hash := sub.Hash
+
+	// Submit the same tx again
+	sub, err = s.UpsertWalletTx(tx.SignedTx)
+	assert.Nil(t, err)
+	assert.Equal(t, mempool.Known, sub.Outcome)
+	assert.Equal(t, hash, sub.Hash)
+
+	// Submit the tx with the same nonce and the same tip
+	replacement := database.Tx{
+		ChainID: 1,
+		Nonce:   1,
+		From:    accountID(t, alice),
+		To:      "0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0",
+		Value:   20,
+		Tip:     1,
+	}
+	signedTx, err := replacement.Sign(alice)
+	assert.Nil(t, err)
+	sub, err = s.UpsertWalletTx(signedTx)
+	assert.ErrorIs(t, err, mempool.ErrUnderpriced)
+	assert.Equal(t, mempool.Rejected, sub.Outcome)
+
+	// Submit the tx with the same nonce and the higher tip
+	replacement.Tip = 2
+	signedTx, err = replacement.Sign(alice)
+	assert.Nil(t, err)
+	sub, err = s.UpsertWalletTx(signedTx)
+	assert.Nil(t, err)
+	assert.Equal(t, mempool.Replaced, sub.Outcome)
+
+	txs := s.UncommittedTx()
+	assert.Equal(t, 1, len(txs))
+	assert.Equal(t, uint64(20), txs[0].Value)
+}
+
+func TestState_UpsertWalletTxAdmission(t *testing.T) {
+	alice := testdata.LoadPrivateKey(t)
+	bob := generatePrivateKey(t)
+	gen := mockGenesis(t, alice)
+
+	s := mockState(t, gen)
+
+	// Submit the tx following the nonce gap, which is queued
+	_, err := s.UpsertWalletTx(mockBlockTx(t, alice, 2).SignedTx)
+	assert.Nil(t, err)
+	assert.Equal(t, mempool.Stats{Pending: 0, Queued: 1}, s.MempoolStats())
+
+	// Fill the gap and ensure both txs are pending
+	_, err = s.UpsertWalletTx(mockBlockTx(t, alice, 1).SignedTx)
+	assert.Nil(t, err)
+	assert.Equal(t, mempool.Stats{Pending: 2, Queued: 0}, s.MempoolStats())
+
+	// Submit the tx of the account without funds and assert err
+	_, err = s.UpsertWalletTx(mockBlockTx(t, bob, 1).SignedTx)
+	assert.ErrorIs(t, err, mempool.ErrInsufficientFunds)
+
+	// Mine the block and ensure used nonces cannot be submitted again
+	block, err := s.MineBlock(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(block.Tree.Values()))
+	assert.Equal(t, 0, s.MempoolSize())
+
+	_, err = s.UpsertWalletTx(mockBlockTx(t, alice, 2).SignedTx)
+	assert.ErrorIs(t, err, mempool.ErrNonceTooLow)
+}
+
+func TestState_MempoolJournal(t *testing.T) {
+	alice := testdata.LoadPrivateKey(t)
+	gen := mockGenesis(t, alice)
+
+	storage, err := memory.New()
+	assert.Nil(t, err)
+	cfg := state.Config{
+		BeneficiaryID:  "0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0",
+		Host:           "0.0.0.0:4000",
+		Genesis:        gen,
+		Storage:        storage,
+		KnownPeers:     network.NewPeerSet(),
+		MempoolJournal: path.Join(t.TempDir(), "mempool.journal"),
+	}
+
+	s, err := state.New(cfg)
+	assert.Nil(t, err)
+	s.RegisterWorker(noopWorker{})
+
+	// Submit txs, the first one is mined before the restart
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		_, err = s.UpsertWalletTx(mockBlockTx(t, alice, nonce).SignedTx)
+		assert.Nil(t, err)
+	}
+	miner := mockDatabase(t, gen)
+	block := mineBlock(t, miner, "0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0", mockBlockTx(t, alice, 1))
+	err = s.ProcessBlock(block)
+	assert.Nil(t, err)
+	err = s.Shutdown()
+	assert.Nil(t, err)
+
+	// Restart the node and ensure txs waiting to be mined are restored
+	s, err = state.New(cfg)
+	assert.Nil(t, err)
+	s.RegisterWorker(noopWorker{})
+
+	txs := s.UncommittedTx()
+	assert.Equal(t, 2, len(txs))
+	assert.Equal(t, uint64(2), txs[0].Nonce)
+	assert.Equal(t, uint64(3), txs[1].Nonce)
+	assert.Equal(t, mempool.Stats{Pending: 2, Queued: 0}, s.MempoolStats())
+	err = s.Shutdown()
+	assert.Nil(t, err)
+
+	// Restart the node with the shorter TTL and ensure expired txs are dropped
+	cfg.MempoolTTL = time.Nanosecond
+	s, err = state.New(cfg)
+	assert.Nil(t, err)
+	s.RegisterWorker(noopWorker{})
+	assert.Equal(t, 0, s.MempoolSize())
+}
+
+func TestState_NextNonceAndEstimateTip(t *testing.T) {
+	alice := testdata.LoadPrivateKey(t)
+	gen := mockGenesis(t, alice)
+	gen.TxPerBlock = 2
+
+	s := mockState(t, gen)
+	aliceID := accountID(t, alice)
+
+	// Empty chain and mempool give the minimum tip and the first nonce
+	assert.Equal(t, uint64(1), s.NextNonce(aliceID))
+	estimate := s.EstimateTip()
+	assert.Equal(t, uint64(1), estimate.Tip)
+	assert.Equal(t, 0, estimate.Blocks)
+
+	// Next nonce follows uncommitted txs, but stops at the nonce gap
+	for _, nonce := range []uint64{1, 2, 4} {
+		_, err := s.UpsertWalletTx(mockBlockTx(t, alice, nonce).SignedTx)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, uint64(3), s.NextNonce(aliceID))
+
+	// Pending txs fill the next block, so the tip has to outbid them
+	estimate = s.EstimateTip()
+	assert.Equal(t, 2, estimate.Pending)
+	assert.Equal(t, uint64(2), estimate.MempoolTip)
+	assert.Equal(t, uint64(2), estimate.Tip)
+
+	// Mined block provides the tips of the recent txs
+	_, err := s.MineBlock(context.Background())
+	assert.Nil(t, err)
+	estimate = s.EstimateTip()
+	assert.Equal(t, 1, estimate.Blocks)
+	assert.Equal(t, uint64(1), estimate.BlockTip)
+	assert.Equal(t, uint64(0), estimate.MempoolTip)
+	assert.Equal(t, uint64(3), s.NextNonce(aliceID))
+}
+
+func TestState_SimulateTx(t *testing.T) {
+	alice := testdata.LoadPrivateKey(t)
+	gen := mockGenesis(t, alice)
+
+	s := mockState(t, gen)
+	aliceID := accountID(t, alice)
+
+	_, err := s.UpsertWalletTx(mockBlockTx(t, alice, 1).SignedTx)
+	assert.Nil(t, err)
+
+	// Simulate the tx following the uncommitted one
+	sim := s.SimulateTx(mockBlockTx(t, alice, 2).SignedTx)
+	assert.Equal(t, database.ReceiptStatusSuccess, sim.Status)
+	assert.Equal(t, uint64(1), sim.GasUsed)
+	assert.Equal(t, uint64(1), sim.GasFee)
+	assert.Equal(t, aliceID, sim.Accounts[0].Before.ID)
+	assert.Equal(t, uint64(988), sim.Accounts[0].Before.Balance)
+	assert.Equal(t, uint64(976), sim.Accounts[0].After.Balance)
+	assert.Equal(t, uint64(2), sim.Accounts[0].After.Nonce)
+
+	// Simulate the tx replacing the uncommitted one
+	sim = s.SimulateTx(mockBlockTx(t, alice, 1).SignedTx)
+	assert.Equal(t, database.ReceiptStatusSuccess, sim.Status)
+	assert.Equal(t, uint64(1000), sim.Accounts[0].Before.Balance)
+
+	// Simulate the tx with the nonce gap and assert failure
+	sim = s.SimulateTx(mockBlockTx(t, alice, 5).SignedTx)
+	assert.Equal(t, database.ReceiptStatusFailed, sim.Status)
+	assert.Equal(t, "tx invalid, wrong nonce, got: 5, expected: 2", sim.Error)
+	assert.Equal(t, uint64(0), sim.GasFee)
+	assert.Equal(t, sim.Accounts[0].Before, sim.Accounts[0].After)
+
+	// Simulate the tx exceeding the balance and assert failure
+	tx := database.Tx{ChainID: 1, Nonce: 2, From: aliceID, To: "0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0", Value: 5000}
+	signedTx, err := tx.Sign(alice)
+	assert.Nil(t, err)
+	sim = s.SimulateTx(signedTx)
+	assert.Equal(t, database.ReceiptStatusFailed, sim.Status)
+	assert.Equal(t, "tx invalid, insufficient funds, got: 988, expected: 5001", sim.Error)
+
+	// Ensure neither the state nor the mempool has changed
+	account, err := s.Account(aliceID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000), account.Balance)
+	assert.Equal(t, 1, s.MempoolSize())
+}
+
+func TestState_TxStatus(t *testing.T) {
+	alice := testdata.LoadPrivateKey(t)
+	gen := mockGenesis(t, alice)
+
+	s := mockState(t, gen)
+
+	// Submit the pending and the queued tx
+	first, err := s.UpsertWalletTx(mockBlockTx(t, alice, 1).SignedTx)
+	assert.Nil(t, err)
+	queued, err := s.UpsertWalletTx(mockBlockTx(t, alice, 3).SignedTx)
+	assert.Nil(t, err)
+
+	status, err := s.TxStatus(first.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, state.TxStatusPending, status.Status)
+	status, err = s.TxStatus(queued.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, state.TxStatusQueued, status.Status)
+
+	// Replace the pending tx and assert the replaced one is dropped
+	replacement := database.Tx{ChainID: 1, Nonce: 1, From: accountID(t, alice), To: "0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0", Value: 10, Tip: 2}
+	signedTx, err := replacement.Sign(alice)
+	assert.Nil(t, err)
+	replaced, err := s.UpsertWalletTx(signedTx)
+	assert.Nil(t, err)
+
+	status, err = s.TxStatus(first.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, state.TxStatusDropped, status.Status)
+	assert.Equal(t, string(mempool.EvictReplaced), status.Reason)
+
+	// Mine the replacement and assert it is confirmed
+	block, err := s.MineBlock(context.Background())
+	assert.Nil(t, err)
+
+	status, err = s.TxStatus(replaced.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, state.TxStatusMined, status.Status)
+	assert.Equal(t, uint64(1), status.BlockHeight)
+	assert.Equal(t, block.Hash(), status.BlockHash)
+	assert.Equal(t, uint64(1), status.Confirmations)
+
+	status, err = s.TxStatus(queued.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, state.TxStatusQueued, status.Status)
+
+	// Query the unknown tx
+	_, err = s.TxStatus("0x00")
+	assert.ErrorIs(t, err, database.ErrTxNotFound)
+}
+
+func TestState_PeerTransport(t *testing.T) {
+	alice := testdata.LoadPrivateKey(t)
+	gen := mockGenesis(t, alice)
+
+	storage, err := memory.New()
+	assert.Nil(t, err)
+
+	s, err := state.New(state.Config{
+		BeneficiaryID: "0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0",
+		Host:          "0.0.0.0:4000",
+		Genesis:       gen,
+		Storage:       storage,
+		KnownPeers:    network.NewPeerSet(),
+		PeerTransport: true,
+	})
+	assert.Nil(t, err)
+	s.RegisterWorker(noopWorker{})
+	defer func() {
+		_ = s.Shutdown()
+	}()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = s.AcceptPeerConn(w, r)
+	}))
+	defer srv.Close()
+
+	peer := network.NewPeer(strings.TrimPrefix(srv.URL, "http://"))
+	client := network.NewTransport(network.TransportConfig{
+		Host:     "0.0.0.0:5000",
+		Endpoint: "ws://%s",
+		Handler: func(from network.Peer, msg network.Message) (network.Message, error) {
+			return network.Message{}, nil
+		},
+	})
+	defer client.Close()
+
+	// Send the tx and the peers to the node
+	data, err := mockBlockTx(t, alice, 1).MarshalBinary()
+	assert.Nil(t, err)
+	err = client.Send(peer, network.Message{Type: network.MsgTx, Payload: data})
+	assert.Nil(t, err)
+
+	data, err = json.Marshal([]network.Peer{network.NewPeer("0.0.0.0:5000")})
+	assert.Nil(t, err)
+	err = client.Send(peer, network.Message{Type: network.MsgPeers, Payload: data})
+	assert.Nil(t, err)
+
+	// Messages are handled in order, so the status reflects both of them
+	resp, err := client.Request(peer, network.Message{Type: network.MsgStatusRequest})
+	assert.Nil(t, err)
+	assert.Equal(t, network.MsgStatus, resp.Type)
+
+	var status network.PeerStatus
+	err = json.Unmarshal(resp.Payload, &status)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), status.LatestBlockNumber)
+	assert.Equal(t, []network.Peer{network.NewPeer("0.0.0.0:5000")}, status.KnownPeers)
+	assert.Equal(t, 1, s.MempoolSize())
+
+	// Unsupported messages are rejected
+	_, err = client.Request(peer, network.Message{Type: network.MsgStatus})
+	assert.ErrorContains(t, err, "unsupported message")
+
+	// Invalid block is rejected back to the sender
+	err = client.Send(peer, network.Message{Type: network.MsgBlock, Payload: []byte("block")})
+	assert.ErrorIs(t, err, network.ErrPeerRejected)
+	assert.ErrorContains(t, err, "failed to decode block data")
+}
+
+func TestState_SendToJSONPeer(t *testing.T) {
+	alice := testdata.LoadPrivateKey(t)
+	gen := mockGenesis(t, alice)
+	s := mockState(t, gen)
+
+	// Setup peer which accepts the JSON encoded blocks and transactions only
+	txs := make(chan database.BlockTx, 1)
+	blocks := make(chan database.BlockData, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/node/tx", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		var tx database.BlockTx
+		if err := json.NewDecoder(r.Body).Decode(&tx); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		txs <- tx
+	})
+	mux.HandleFunc("/v1/node/block", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		var blockData database.BlockData
+		if err := json.NewDecoder(r.Body).Decode(&blockData); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		blocks <- blockData
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	s.AddPeer(network.NewPeer(strings.TrimPrefix(srv.URL, "http://")))
+
+	// Send the tx and assert the peer receives it encoded with JSON
+	tx := mockBlockTx(t, alice, 1)
+	err := s.SendTxToPeers(tx)
+	assert.Nil(t, err)
+	assert.Len(t, txs, 1)
+	assert.Equal(t, tx, <-txs)
+
+	// Send the block and assert the peer receives it encoded with JSON
+	block := mineBlock(t, mockDatabase(t, gen), accountID(t, alice), tx)
+	err = s.SendBlockToPeers(block)
+	assert.Nil(t, err)
+	assert.Len(t, blocks, 1)
+	assert.Equal(t, block.Hash(), (<-blocks).Hash)
+}
+
+func TestState_SendInvalidToPeer(t *testing.T) {
+	alice := testdata.LoadPrivateKey(t)
+	s := mockState(t, mockGenesis(t, alice))
+
+	// Setup peer which rejects every tx as invalid
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	s.AddPeer(network.NewPeer(strings.TrimPrefix(srv.URL, "http://")))
+
+	// Send the tx and ensure it is not sent once again with JSON
+	err := s.SendTxToPeers(mockBlockTx(t, alice, 1))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, requests)
+}
+
+// Helper functions
+
+type noopWorker struct{}
+
+func (noopWorker) Shutdown()                  {}
+func (noopWorker) StartMining()               {}
+func (noopWorker) ShareTx(_ database.BlockTx) {}
+func (noopWorker) StopMining()                {}
+
+func mockGenesis(t *testing.T, privs ...*ecdsa.PrivateKey) genesis.Genesis {
+	balances := make(map[string]uint64)
+	for _, priv := range privs {
+		balances[accountID(t, priv).String()] = 1000
+	}
+
+	return genesis.Genesis{
+		Date:         time.Time{},
+		ChainID:      1,
+		TxPerBlock:   10,
+		Difficulty:   1,
+		MiningReward: 10,
+		GasPrice:     1,
+		Balances:     balances,
+	}
+}
+
+func mockState(t *testing.T, gen genesis.Genesis) *state.State {
+	storage, err := memory.New()
+	assert.Nil(t, err)
+
+	s, err := state.New(state.Config{
+		BeneficiaryID: "0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0",
+		Host:          "0.0.0.0:4000",
+		Genesis:       gen,
+		Storage:       storage,
+		KnownPeers:    network.NewPeerSet(),
+	})
+	assert.Nil(t, err)
+
+	s.RegisterWorker(noopWorker{})
+
+	return s
+}
+
+func mockDatabase(t *testing.T, gen genesis.Genesis) *database.Database {
+	storage, err := memory.New()
+	assert.Nil(t, err)
+
+	db, err := database.New(database.Config{Genesis: gen, Storage: storage})
+	assert.Nil(t, err)
+
+	return db
+}
+
+func mineBlock(t *testing.T, db *database.Database, beneficiaryID database.AccountID, txs ...database.BlockTx) database.Block {
+	target, err := db.NextTarget()
+	assert.Nil(t, err)
+
+	accounts := db.Accounts()
+	for _, tx := range txs {
+		err = accounts.ApplyTransaction(beneficiaryID, tx)
+		assert.Nil(t, err)
+	}
+	accounts.ApplyMiningReward(beneficiaryID, 10)
+
+	block, err := database.POW(context.Background(), database.POWArgs{
+		BeneficiaryID: beneficiaryID,
+		Target:        target,
+		Reward:        10,
+		PrevBlock:     db.LastBlock(),
+		StateRoot:     db.CalcStateRoot(accounts),
+		Txs:           txs,
+		Ev:            func(s string, args ...any) {},
+	})
+	assert.Nil(t, err)
+
+	err = db.ApplyBlock(block)
+	assert.Nil(t, err)
+
+	return block
+}
+
+func mockBlockTx(t *testing.T, priv *ecdsa.PrivateKey, nonce uint64) database.BlockTx {
+	tx := database.Tx{
+		ChainID: 1,
+		Nonce:   nonce,
+		From:    accountID(t, priv),
+		To:      "0x0ce5ba68586c85880B0900D0dEe0eEcBB33040e0",
+		Value:   10,
+		Tip:     1,
+	}
+	signedTx, err := tx.Sign(priv)
+	assert.Nil(t, err)
+
+	return database.NewBlockTx(signedTx, 1, 1)
+}
+
+func generatePrivateKey(t *testing.T) *ecdsa.PrivateKey {
+	priv, err := crypto.GenerateKey()
+	assert.Nil(t, err)
+	return priv
+}
+
+func accountID(t *testing.T, priv *ecdsa.PrivateKey) database.AccountID {
+	id, err := database.PubToAccountID(priv.PublicKey)
+	assert.Nil(t, err)
+	return id
+}
@@ -0,0 +1,82 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/mempool"
+)
+
+// Set of transaction lifecycle statuses.
+const (
+	TxStatusPending = "pending"
+	TxStatusQueued  = "queued"
+	TxStatusMined   = "mined"
+	TxStatusDropped = "dropped"
+	TxStatusFailed  = "failed"
+)
+
+// Submission describes the outcome of the wallet transaction submission.
+type Submission struct {
+	Hash    string
+	Outcome mempool.Outcome
+}
+
+// TxStatus describes the stage of the transaction lifecycle. Block details are only
+// set for mined transactions, while the reason is only set for dropped and failed ones.
+type TxStatus struct {
+	Hash          string
+	Status        string
+	BlockHeight   uint64
+	BlockHash     string
+	Confirmations uint64
+	Reason        string
+	Error         string
+}
+
+// TxStatus returns the lifecycle status of the transaction by given hash. The mempool
+// is checked first, then the committed transactions and finally the eviction log,
+// so the transaction mined after being dropped is reported as mined.
+func (s *State) TxStatus(hash string) (TxStatus, error) {
+	if tx, pool, ok := s.mempool.Find(hash); ok {
+		status := TxStatusPending
+		if pool == mempool.PoolQueued {
+			status = TxStatusQueued
+		}
+		return TxStatus{Hash: tx.HexHash(), Status: status}, nil
+	}
+
+	receipt, err := s.db.Receipt(hash)
+	switch {
+	case err == nil:
+		var confirmations uint64
+		if last := s.db.LastBlock().Height(); last >= receipt.BlockHeight {
+			confirmations = last - receipt.BlockHeight + 1
+		}
+		return TxStatus{
+			Hash:          receipt.TxHash,
+			Status:        TxStatusMined,
+			BlockHeight:   receipt.BlockHeight,
+			BlockHash:     receipt.BlockHash,
+			Confirmations: confirmations,
+		}, nil
+	case !errors.Is(err, database.ErrTxNotFound) && !errors.Is(err, database.ErrBlockPruned):
+		return TxStatus{}, err
+	}
+
+	if e, ok := s.mempool.FindEviction(hash); ok {
+		status := TxStatusDropped
+		if e.Reason == mempool.EvictInvalid {
+			status = TxStatusFailed
+		}
+		return TxStatus{Hash: e.Hash, Status: status, Reason: string(e.Reason), Error: e.Error}, nil
+	}
+
+	// The transaction might have been mined in one of the pruned blocks.
+	if errors.Is(err, database.ErrBlockPruned) {
+		return TxStatus{}, err
+	}
+
+	return TxStatus{}, fmt.Errorf("%w: %s", database.ErrTxNotFound, hash)
+}
@@ -0,0 +1,105 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/goccy/go-json"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/network"
+)
+
+// ErrTransportDisabled is returned when the peer connection is requested, while
+// the node exchanges the messages with the peers over the HTTP private API only.
+var ErrTransportDisabled = errors.New("peer transport disabled")
+
+// AcceptPeerConn upgrades the HTTP request of the peer to the long-lived connection
+// used to exchange the messages with that peer.
+func (s *State) AcceptPeerConn(w http.ResponseWriter, r *http.Request) error {
+	if s.transport == nil {
+		return ErrTransportDisabled
+	}
+	return s.transport.Accept(w, r)
+}
+
+// PeerStatus returns the status of the current node shared with the peers.
+func (s *State) PeerStatus() network.PeerStatus {
+	lastBlock := s.LastBlock()
+
+	return network.PeerStatus{
+		LatestBlockHash:   lastBlock.Hash(),
+		LatestBlockNumber: lastBlock.Height(),
+		KnownPeers:        s.ExternalPeers(),
+	}
+}
+
+// private API
+
+// peerTransportEndpoint is the websocket url the peers accept connections at.
+const peerTransportEndpoint = "ws://%s/v1/node/ws"
+
+// sendToPeer sends the message to the peer over the transport. The HTTP request of
+// the private API is used as a fallback, whenever the transport is disabled or the peer
+// cannot be reached with it, e.g. because it does not support the transport yet. The
+// message rejected by the peer is not sent again.
+func (s *State) sendToPeer(peer network.Peer, msg network.Message, fallback func() error) error {
+	if s.transport != nil {
+		err := s.transport.Send(peer, msg)
+		if err == nil || errors.Is(err, network.ErrPeerRejected) {
+			return err
+		}
+		s.ev("[STATE][sendToPeer][Sending %s to: %s over transport failed, falling back to HTTP: %s]", msg.Type, peer.Host, err)
+	}
+	return fallback()
+}
+
+// handlePeerMessage handles the message received from the peer over the transport,
+// the same way the private API handles the corresponding HTTP requests.
+func (s *State) handlePeerMessage(from network.Peer, msg network.Message) (network.Message, error) {
+	switch msg.Type {
+	case network.MsgBlock:
+		var blockData database.BlockData
+		if err := blockData.UnmarshalBinary(msg.Payload); err != nil {
+			return network.Message{}, fmt.Errorf("failed to decode block data: %w", err)
+		}
+		block, err := blockData.ToBlock()
+		if err != nil {
+			return network.Message{}, fmt.Errorf("failed to prepare block: %w", err)
+		}
+		if err = s.ProcessBlock(block); err != nil && !errors.Is(err, ErrBlockKnown) {
+			return network.Message{}, fmt.Errorf("failed to process block: %w", err)
+		}
+		return network.Message{}, nil
+
+	case network.MsgTx:
+		var tx database.BlockTx
+		if err := tx.UnmarshalBinary(msg.Payload); err != nil {
+			return network.Message{}, fmt.Errorf("failed to decode tx data: %w", err)
+		}
+		if err := s.UpsertNodeTx(tx); err != nil {
+			return network.Message{}, fmt.Errorf("failed to upsert tx: %w", err)
+		}
+		return network.Message{}, nil
+
+	case network.MsgPeers:
+		var peers []network.Peer
+		if err := json.Unmarshal(msg.Payload, &peers); err != nil {
+			return network.Message{}, fmt.Errorf("failed to decode peers: %w", err)
+		}
+		for _, peer := range peers {
+			s.AddPeer(peer)
+		}
+		return network.Message{}, nil
+
+	case network.MsgStatusRequest:
+		payload, err := json.Marshal(s.PeerStatus())
+		if err != nil {
+			return network.Message{}, fmt.Errorf("failed to encode status: %w", err)
+		}
+		return network.Message{Type: network.MsgStatus, Payload: payload}, nil
+
+	default:
+		return network.Message{}, fmt.Errorf("unsupported message: %s from: %s", msg.Type, from.Host)
+	}
+}
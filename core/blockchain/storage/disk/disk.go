@@ -1,86 +1,349 @@
 package disk
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"os"
 	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/goccy/go-json"
 	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
 )
 
+const (
+	// snapshotsDir is the subdirectory of the data path keeping state snapshots.
+	snapshotsDir = "snapshots"
+
+	// hashIndexFile is the file of the data path keeping the block hash index.
+	// Every line maps the height to the block hash and the latest line wins.
+	hashIndexFile = "hashes.idx"
+
+	// blockExt is the extension of block files.
+	blockExt = ".blk"
+
+	// legacyBlockExt is the extension of JSON block files written by the previous versions.
+	legacyBlockExt = ".json"
+
+	// prunedFile is the file of the data path keeping the height up to which
+	// transactions of blocks have been dropped.
+	prunedFile = "pruned"
+)
+
+// ErrUnsupportedFormat is returned for the block files written before blocks were
+// checksummed. Such blocks commit to the difficulty instead of the target and cannot
+// be converted without changing their hashes, so the chain data has to be removed.
+var ErrUnsupportedFormat = errors.New("block file format is not supported, chain data has to be removed and synced again")
+
 // Disk represents the database.Storage implementation we can use
 // for storing and reading blocks of the disk from their own separate files.
+// Blocks are encoded with the database binary codec.
+// It implements database.SnapshotStorage and database.PrunableStorage as well.
 type Disk struct {
 	dataPath string
+
+	mu     sync.RWMutex
+	hashes map[string]uint64
+	head   uint64
+	pruned uint64
 }
 
 // New constructs a new Disk.
 // This function takes care about building any subdirectories structure
 // along the given path.
 func New(dataPath string) (*Disk, error) {
-	if err := os.MkdirAll(dataPath, 0755); err != nil {
+	if err := os.MkdirAll(path.Join(dataPath, snapshotsDir), 0755); err != nil {
 		return nil, err
 	}
-	return &Disk{dataPath: dataPath}, nil
+
+	d := Disk{
+		dataPath: dataPath,
+		hashes:   make(map[string]uint64),
+	}
+	if err := d.loadHead(); err != nil {
+		return nil, fmt.Errorf("load head err: %w", err)
+	}
+	if err := d.loadHashIndex(); err != nil {
+		return nil, fmt.Errorf("load hash index err: %w", err)
+	}
+	if err := d.loadPruned(); err != nil {
+		return nil, fmt.Errorf("load pruned height err: %w", err)
+	}
+
+	return &d, nil
 }
 
-// Write writes the database.BlockData on the disk in a JSON file named by given height.
-// JSON file format is the subject to change in the future, and it is the only supported
-// data serialization strategy atm.
+// Write writes the database.BlockData on the disk in a block file named by given height.
+// Block is encoded with the database binary codec and stored together with its checksum.
+// The file is replaced atomically, so a crash never leaves a partially written block.
 func (d *Disk) Write(height uint64, data database.BlockData) error {
-
-	// Marshall data into JSON.
-	bs, err := json.MarshalIndent(data, "", " ")
+	err := d.writeBlock(height, data)
 	if err != nil {
 		return err
 	}
 
-	// Open or create a new JSON file named by given block height.
-	f, err := os.OpenFile(d.filePath(height), os.O_CREATE|os.O_RDWR, 0600)
-	if err != nil {
+	if err = d.indexHash(height, data.Hash); err != nil {
 		return err
 	}
-	defer func() { _ = f.Close() }()
 
-	// Write JSON bytes to the file.
-	_, err = f.Write(bs)
-	if err != nil {
-		return err
+	d.mu.Lock()
+	if height > d.head {
+		d.head = height
 	}
+	d.mu.Unlock()
 
 	return nil
 }
 
-// Read reads the JSON file named by given height and decodes it into database.BlockData.
-// JSON file format is the subject to change in the future, and it is the only supported
-// data serialization strategy atm.
+// Read reads the block file named by given height and decodes it into database.BlockData.
+// JSON files written by the previous versions are reported with ErrUnsupportedFormat. File which
+// cannot be decoded or does not match its checksum is reported with *database.CorruptedBlockError.
 func (d *Disk) Read(height uint64) (*database.BlockData, error) {
 
-	// Open a JSON file named by given height.
-	f, err := os.OpenFile(d.filePath(height), os.O_RDONLY, 0600)
+	// Read a block file named by given height.
+	bs, err := os.ReadFile(d.filePath(height))
+	if errors.Is(err, os.ErrNotExist) {
+		return d.readLegacy(height)
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer func() { _ = f.Close() }()
 
-	// Decode JSON file into database.BlockData.
+	// Verify the checksum.
+	if len(bs) < sha256.Size {
+		return nil, &database.CorruptedBlockError{Height: height, Err: errors.New("file is too short")}
+	}
+	sum := sha256.Sum256(bs[sha256.Size:])
+	if !bytes.Equal(sum[:], bs[:sha256.Size]) {
+		return nil, &database.CorruptedBlockError{Height: height, Err: errors.New("checksum mismatch")}
+	}
+
+	// Decode checksummed data into database.BlockData.
 	var data database.BlockData
-	err = json.NewDecoder(f).Decode(&data)
+	if err = data.UnmarshalBinary(bs[sha256.Size:]); err != nil {
+		return nil, &database.CorruptedBlockError{Height: height, Err: err}
+	}
+
+	return &data, nil
+}
+
+// readLegacy checks for the JSON file named by given height, which is how blocks
+// were stored by the versions before blocks were checksummed.
+func (d *Disk) readLegacy(height uint64) (*database.BlockData, error) {
+	if _, err := os.Stat(d.legacyFilePath(height)); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("block: %d: %w", height, ErrUnsupportedFormat)
+}
+
+// ReadByHash reads the database.BlockData by given block hash using the hash index.
+func (d *Disk) ReadByHash(hash string) (*database.BlockData, error) {
+	d.mu.RLock()
+	height, ok := d.hashes[strings.ToLower(hash)]
+	d.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("cannot find block with hash: %s", hash)
+	}
+
+	data, err := d.Read(height)
 	if err != nil {
 		return nil, err
 	}
 
-	return &data, nil
+	// Ensure the block has not been replaced since it was indexed.
+	if !strings.EqualFold(data.Hash, hash) {
+		return nil, fmt.Errorf("cannot find block with hash: %s", hash)
+	}
+
+	return data, nil
+}
+
+// Head returns the height of the last block of the chain stored on the disk.
+func (d *Disk) Head() (uint64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.head, nil
+}
+
+// Range reads the blocks with heights from the given range in ascending order and passes
+// them to the function. Range is limited to the blocks of the chain stored on the disk
+// and stops on the first error returned by the function.
+func (d *Disk) Range(from, to uint64, fn func(height uint64, data database.BlockData) error) error {
+	head, err := d.Head()
+	if err != nil {
+		return err
+	}
+
+	if from == 0 {
+		from = 1
+	}
+	if to > head {
+		to = head
+	}
+
+	for height := from; height <= to; height++ {
+		data, err := d.Read(height)
+		if err != nil {
+			return err
+		}
+		if err = fn(height, *data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TruncateFrom removes the block with given height and all the following blocks from the disk.
+// Blocks are removed starting with the last one, so a crash in the middle never leaves a gap.
+func (d *Disk) TruncateFrom(height uint64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if height == 0 {
+		height = 1
+	}
+
+	for h := d.head; h >= height; h-- {
+		for _, name := range []string{d.filePath(h), d.legacyFilePath(h)} {
+			if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		d.head = h - 1
+	}
+
+	if d.pruned >= height {
+		if err := d.setPruned(height - 1); err != nil {
+			return err
+		}
+	}
+
+	// Rewrite the hash index, so it does not point to the removed blocks.
+	var index bytes.Buffer
+	for hash, h := range d.hashes {
+		if h >= height {
+			delete(d.hashes, hash)
+			continue
+		}
+		fmt.Fprintf(&index, "%d %s\n", h, hash)
+	}
+
+	return WriteFileAtomic(path.Join(d.dataPath, hashIndexFile), index.Bytes())
+}
+
+// Prune drops transactions of all blocks up to and including given height.
+// Block file is replaced with the one keeping only the block header.
+func (d *Disk) Prune(height uint64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if height > d.head {
+		height = d.head
+	}
+	if height <= d.pruned {
+		return nil
+	}
+
+	for h := d.pruned + 1; h <= height; h++ {
+		data, err := d.Read(h)
+		if err != nil {
+			return err
+		}
+		if data.Pruned {
+			continue
+		}
+
+		pruned := database.BlockData{Hash: data.Hash, Header: data.Header, Pruned: true}
+		if err = d.writeBlock(h, pruned); err != nil {
+			return err
+		}
+	}
+
+	return d.setPruned(height)
+}
+
+// Pruned returns the height up to which transactions of blocks have been dropped.
+func (d *Disk) Pruned() (uint64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.pruned, nil
 }
 
 // Reset removes all the blocks from the disk and recreates the subdirectory structure
 // defined by the path given during initialization.
 func (d *Disk) Reset() error {
+	d.mu.Lock()
+	d.hashes = make(map[string]uint64)
+	d.head = 0
+	d.pruned = 0
+	d.mu.Unlock()
+
 	if err := os.RemoveAll(d.dataPath); err != nil {
 		return err
 	}
-	return os.MkdirAll(d.dataPath, 0755)
+	return os.MkdirAll(path.Join(d.dataPath, snapshotsDir), 0755)
+}
+
+// WriteSnapshot writes the database.Snapshot on the disk in a JSON file named by its height.
+func (d *Disk) WriteSnapshot(snapshot database.Snapshot) error {
+	bs, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(d.snapshotPath(snapshot.Height), bs)
+}
+
+// ReadSnapshot reads the JSON file named by given height and decodes it into database.Snapshot.
+func (d *Disk) ReadSnapshot(height uint64) (*database.Snapshot, error) {
+	bs, err := os.ReadFile(d.snapshotPath(height))
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot database.Snapshot
+	if err = json.Unmarshal(bs, &snapshot); err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+// RemoveSnapshot removes the snapshot with given height from the disk.
+func (d *Disk) RemoveSnapshot(height uint64) error {
+	return os.Remove(d.snapshotPath(height))
+}
+
+// Snapshots returns the heights of all snapshots stored on the disk in ascending order.
+func (d *Disk) Snapshots() ([]uint64, error) {
+	entries, err := os.ReadDir(path.Join(d.dataPath, snapshotsDir))
+	if err != nil {
+		return nil, err
+	}
+
+	var heights []uint64
+	for _, entry := range entries {
+		height, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		heights = append(heights, height)
+	}
+
+	sort.Slice(heights, func(i, j int) bool {
+		return heights[i] < heights[j]
+	})
+
+	return heights, nil
 }
 
 // Close is a no-op method required by database.Storage interface.
@@ -89,5 +352,191 @@ func (d *Disk) Close() error {
 }
 
 func (d *Disk) filePath(blockHeight uint64) string {
-	return path.Join(d.dataPath, fmt.Sprintf("%d.json", blockHeight))
+	return path.Join(d.dataPath, fmt.Sprintf("%d%s", blockHeight, blockExt))
+}
+
+func (d *Disk) legacyFilePath(blockHeight uint64) string {
+	return path.Join(d.dataPath, fmt.Sprintf("%d%s", blockHeight, legacyBlockExt))
+}
+
+// writeBlock encodes the block with its checksum and replaces the block file atomically.
+func (d *Disk) writeBlock(height uint64, data database.BlockData) error {
+
+	// Encode data with the binary codec.
+	bs, err := data.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	// Prefix data with its checksum.
+	sum := sha256.Sum256(bs)
+	bs = append(sum[:], bs...)
+
+	if err = WriteFileAtomic(d.filePath(height), bs); err != nil {
+		return err
+	}
+
+	// Drop the legacy JSON file, so it cannot shadow the block after the rewrite.
+	if err = os.Remove(d.legacyFilePath(height)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// setPruned persists the height up to which transactions of blocks have been dropped.
+// It has to be called while holding the lock.
+func (d *Disk) setPruned(height uint64) error {
+	if err := WriteFileAtomic(path.Join(d.dataPath, prunedFile), []byte(strconv.FormatUint(height, 10))); err != nil {
+		return err
+	}
+	d.pruned = height
+	return nil
+}
+
+// loadPruned reads the height up to which transactions of blocks have been dropped.
+func (d *Disk) loadPruned() error {
+	bs, err := os.ReadFile(path.Join(d.dataPath, prunedFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	d.pruned, err = strconv.ParseUint(strings.TrimSpace(string(bs)), 10, 64)
+	return err
+}
+
+// loadHead finds the height of the last block, which is preceded by all the blocks
+// of the chain. Files following the gap are not part of the chain.
+func (d *Disk) loadHead() error {
+	entries, err := os.ReadDir(d.dataPath)
+	if err != nil {
+		return err
+	}
+
+	heights := make(map[uint64]bool)
+	for _, entry := range entries {
+		ext := path.Ext(entry.Name())
+		if ext != blockExt && ext != legacyBlockExt {
+			continue
+		}
+		height, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), ext), 10, 64)
+		if err != nil {
+			continue
+		}
+		heights[height] = true
+	}
+
+	for heights[d.head+1] {
+		d.head++
+	}
+
+	return nil
+}
+
+// indexHash adds the block hash to the index kept in memory and appends it to the index file.
+func (d *Disk) indexHash(height uint64, hash string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f, err := os.OpenFile(path.Join(d.dataPath, hashIndexFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err = fmt.Fprintf(f, "%d %s\n", height, hash); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+
+	d.hashes[strings.ToLower(hash)] = height
+
+	return nil
+}
+
+// loadHashIndex reads the index file into memory. The index is rebuilt from
+// the blocks when the index file does not exist yet.
+func (d *Disk) loadHashIndex() error {
+	f, err := os.Open(path.Join(d.dataPath, hashIndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return d.rebuildHashIndex()
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var height uint64
+		var hash string
+
+		// Line torn by a crash is skipped, the block is still readable by its height.
+		if _, err = fmt.Sscanf(scanner.Text(), "%d %s", &height, &hash); err != nil {
+			continue
+		}
+		d.hashes[strings.ToLower(hash)] = height
+	}
+
+	return scanner.Err()
+}
+
+// rebuildHashIndex indexes all blocks stored on the disk up to the first corrupted
+// one, which is reported later on while loading the chain.
+func (d *Disk) rebuildHashIndex() error {
+	err := d.Range(1, d.head, func(height uint64, data database.BlockData) error {
+		return d.indexHash(height, data.Hash)
+	})
+
+	var corrupted *database.CorruptedBlockError
+	if errors.As(err, &corrupted) {
+		return nil
+	}
+	return err
+}
+
+func (d *Disk) snapshotPath(height uint64) string {
+	return path.Join(d.dataPath, snapshotsDir, fmt.Sprintf("%d.json", height))
+}
+
+// WriteFileAtomic writes the data to a temporary file, flushes it to the disk and
+// renames it to the target name. Rename is atomic, so the target file contains
+// either the previous or the new data, even if the process crashes in between.
+func WriteFileAtomic(name string, data []byte) error {
+	tmp := name + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp, name); err != nil {
+		return err
+	}
+
+	// Ensure the rename itself survives the crash.
+	dir, err := os.Open(path.Dir(name))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dir.Close() }()
+
+	return dir.Sync()
 }
@@ -1,6 +1,9 @@
 package disk_test
 
 import (
+	"errors"
+	"fmt"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -29,3 +32,212 @@ func TestDisk(t *testing.T) {
 	_, err = d.Read(1)
 	assert.NotNil(t, err)
 }
+
+func TestDisk_Snapshots(t *testing.T) {
+	d, err := disk.New("testdata")
+	assert.Nil(t, err)
+
+	// Write snapshots out of order
+	err = d.WriteSnapshot(database.Snapshot{Height: 10})
+	assert.Nil(t, err)
+	err = d.WriteSnapshot(database.Snapshot{Height: 2})
+	assert.Nil(t, err)
+
+	// List snapshots in ascending order
+	heights, err := d.Snapshots()
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{2, 10}, heights)
+
+	// Read and remove snapshot
+	snapshot, err := d.ReadSnapshot(10)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10), snapshot.Height)
+
+	err = d.RemoveSnapshot(10)
+	assert.Nil(t, err)
+	_, err = d.ReadSnapshot(10)
+	assert.NotNil(t, err)
+
+	// Reset removes snapshots as well
+	err = d.Reset()
+	assert.Nil(t, err)
+	heights, err = d.Snapshots()
+	assert.Nil(t, err)
+	assert.Empty(t, heights)
+}
+
+func TestDisk_ReadByHash(t *testing.T) {
+	d, err := disk.New("testdata")
+	assert.Nil(t, err)
+
+	// Write blocks with distinct hashes
+	err = d.Write(1, database.BlockData{Hash: "0xabc"})
+	assert.Nil(t, err)
+	err = d.Write(2, database.BlockData{Hash: "0xdef"})
+	assert.Nil(t, err)
+
+	// Read block by hash regardless of its case
+	data, err := d.ReadByHash("0xABC")
+	assert.Nil(t, err)
+	assert.Equal(t, "0xabc", data.Hash)
+
+	// Read block by unknown hash and assert err
+	_, err = d.ReadByHash("0x123")
+	assert.NotNil(t, err)
+
+	// Ensure the index is loaded from the disk by a new instance
+	d, err = disk.New("testdata")
+	assert.Nil(t, err)
+	data, err = d.ReadByHash("0xabc")
+	assert.Nil(t, err)
+	assert.Equal(t, "0xabc", data.Hash)
+
+	// Reset removes the index as well
+	err = d.Reset()
+	assert.Nil(t, err)
+	_, err = d.ReadByHash("0xabc")
+	assert.NotNil(t, err)
+}
+
+func TestDisk_Corrupted(t *testing.T) {
+	d, err := disk.New("testdata")
+	assert.Nil(t, err)
+	defer func() { _ = d.Reset() }()
+
+	err = d.Write(1, database.BlockData{Hash: "0xabc"})
+	assert.Nil(t, err)
+
+	// Rewriting the block with the shorter one leaves a valid file
+	err = d.Write(1, database.BlockData{})
+	assert.Nil(t, err)
+	_, err = d.Read(1)
+	assert.Nil(t, err)
+
+	// Modify the block file behind the storage back
+	bs, err := os.ReadFile("testdata/1.blk")
+	assert.Nil(t, err)
+	modified := append([]byte{}, bs...)
+	modified[len(modified)-1] ^= 0xff
+	err = os.WriteFile("testdata/1.blk", modified, 0600)
+	assert.Nil(t, err)
+
+	// Read the block and assert the checksum mismatch is reported with its height
+	var corrupted *database.CorruptedBlockError
+	_, err = d.Read(1)
+	assert.ErrorAs(t, err, &corrupted)
+	assert.Equal(t, uint64(1), corrupted.Height)
+
+	// Truncate the block file and assert it is reported as corrupted
+	err = os.WriteFile("testdata/1.blk", bs[:len(bs)/2], 0600)
+	assert.Nil(t, err)
+	_, err = d.Read(1)
+	assert.ErrorAs(t, err, &corrupted)
+}
+
+func TestDisk_UnsupportedFormat(t *testing.T) {
+	d, err := disk.New("testdata")
+	assert.Nil(t, err)
+	defer func() { _ = d.Reset() }()
+
+	// Store the block as the JSON file written before blocks were checksummed
+	data := []byte(`{"hash":"0xabc","header":{"height":1,"difficulty":6},"txs":null}`)
+	err = os.WriteFile("testdata/1.json", data, 0600)
+	assert.Nil(t, err)
+
+	// Read the block and assert it is not reported as corrupted
+	_, err = d.Read(1)
+	assert.ErrorIs(t, err, disk.ErrUnsupportedFormat)
+	var corrupted *database.CorruptedBlockError
+	assert.False(t, errors.As(err, &corrupted))
+}
+
+func TestDisk_RangeAndTruncate(t *testing.T) {
+	d, err := disk.New("testdata")
+	assert.Nil(t, err)
+	defer func() { _ = d.Reset() }()
+
+	for height, hash := range []string{"0x01", "0x02", "0x03"} {
+		err = d.Write(uint64(height+1), database.BlockData{Hash: hash})
+		assert.Nil(t, err)
+	}
+
+	// Ensure the head is loaded from the disk by a new instance
+	d, err = disk.New("testdata")
+	assert.Nil(t, err)
+	head, err := d.Head()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), head)
+
+	// Range over blocks, the range is limited to the stored blocks
+	var hashes []string
+	err = d.Range(2, 10, func(height uint64, data database.BlockData) error {
+		hashes = append(hashes, data.Hash)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"0x02", "0x03"}, hashes)
+
+	// Truncate the last two blocks
+	err = d.TruncateFrom(2)
+	assert.Nil(t, err)
+	head, err = d.Head()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), head)
+	_, err = d.Read(2)
+	assert.NotNil(t, err)
+
+	// Ensure the truncated blocks are not indexed by a new instance
+	d, err = disk.New("testdata")
+	assert.Nil(t, err)
+	_, err = d.ReadByHash("0x02")
+	assert.NotNil(t, err)
+	data, err := d.ReadByHash("0x01")
+	assert.Nil(t, err)
+	assert.Equal(t, "0x01", data.Hash)
+}
+
+func TestDisk_Prune(t *testing.T) {
+	d, err := disk.New("testdata")
+	assert.Nil(t, err)
+	defer func() { _ = d.Reset() }()
+
+	for height := uint64(1); height <= 3; height++ {
+		data := database.BlockData{
+			Hash:   fmt.Sprintf("0x0%d", height),
+			Header: database.BlockHeader{Height: height},
+			Txs:    []database.BlockTx{{}},
+		}
+		err = d.Write(height, data)
+		assert.Nil(t, err)
+	}
+
+	// Prune the first two blocks
+	err = d.Prune(2)
+	assert.Nil(t, err)
+
+	// Ensure the pruned height and pruned blocks are loaded by a new instance
+	d, err = disk.New("testdata")
+	assert.Nil(t, err)
+	pruned, err := d.Pruned()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), pruned)
+
+	data, err := d.Read(2)
+	assert.Nil(t, err)
+	assert.True(t, data.Pruned)
+	assert.Nil(t, data.Txs)
+	assert.Equal(t, uint64(2), data.Header.Height)
+	assert.Equal(t, "0x02", data.Hash)
+
+	data, err = d.Read(3)
+	assert.Nil(t, err)
+	assert.False(t, data.Pruned)
+	assert.Equal(t, 1, len(data.Txs))
+
+	// Truncate the pruned blocks and ensure the pruned height follows
+	err = d.TruncateFrom(2)
+	assert.Nil(t, err)
+	pruned, err = d.Pruned()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), pruned)
+}
@@ -2,6 +2,8 @@ package memory
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
@@ -9,14 +11,21 @@ import (
 
 // Memory represents the database.Storage implementation we can use
 // for storing and reading blocks from memory using a slice.
+// It implements database.SnapshotStorage and database.PrunableStorage as well.
 type Memory struct {
-	mu     sync.RWMutex
-	blocks []database.BlockData
+	mu        sync.RWMutex
+	blocks    []database.BlockData
+	hashes    map[string]uint64
+	snapshots map[uint64]database.Snapshot
+	pruned    uint64
 }
 
 // New constructs a new Memory.
 func New() (*Memory, error) {
-	return &Memory{}, nil
+	return &Memory{
+		hashes:    make(map[string]uint64),
+		snapshots: make(map[uint64]database.Snapshot),
+	}, nil
 }
 
 // Write writes the database.BlockData to memory protecting the order of blocks based on given height.
@@ -29,6 +38,7 @@ func (m *Memory) Write(height uint64, data database.BlockData) error {
 	}
 
 	m.blocks = append(m.blocks, data)
+	m.hashes[strings.ToLower(data.Hash)] = height
 
 	return nil
 }
@@ -42,7 +52,111 @@ func (m *Memory) Read(height uint64) (*database.BlockData, error) {
 		return nil, fmt.Errorf("cannot read block with height: %d from the chain of len: %d", height, len(m.blocks))
 	}
 
-	return &m.blocks[height-1], nil
+	// Return a copy, as the block can be pruned in the meantime.
+	data := m.blocks[height-1]
+	return &data, nil
+}
+
+// ReadByHash reads the database.BlockData from memory by given block hash.
+func (m *Memory) ReadByHash(hash string) (*database.BlockData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	height, ok := m.hashes[strings.ToLower(hash)]
+	if !ok {
+		return nil, fmt.Errorf("cannot find block with hash: %s", hash)
+	}
+
+	// Return a copy, as the block can be pruned in the meantime.
+	data := m.blocks[height-1]
+	return &data, nil
+}
+
+// Head returns the height of the last block kept in memory.
+func (m *Memory) Head() (uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return uint64(len(m.blocks)), nil
+}
+
+// Range passes the blocks with heights from the given range to the function in ascending order.
+// Range is limited to the blocks kept in memory and stops on the first error returned by the function.
+func (m *Memory) Range(from, to uint64, fn func(height uint64, data database.BlockData) error) error {
+	m.mu.RLock()
+	if from == 0 {
+		from = 1
+	}
+	if to > uint64(len(m.blocks)) {
+		to = uint64(len(m.blocks))
+	}
+	var blocks []database.BlockData
+	if from <= to {
+		blocks = append(blocks, m.blocks[from-1:to]...)
+	}
+	m.mu.RUnlock()
+
+	// Function is called without holding the lock, so it can use the storage as well.
+	for idx, data := range blocks {
+		if err := fn(from+uint64(idx), data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TruncateFrom removes the block with given height and all the following blocks from memory.
+func (m *Memory) TruncateFrom(height uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if height == 0 {
+		height = 1
+	}
+	if height > uint64(len(m.blocks)) {
+		return nil
+	}
+
+	for hash, h := range m.hashes {
+		if h >= height {
+			delete(m.hashes, hash)
+		}
+	}
+	m.blocks = m.blocks[:height-1]
+	if m.pruned >= height {
+		m.pruned = height - 1
+	}
+
+	return nil
+}
+
+// Prune drops transactions of all blocks up to and including given height.
+func (m *Memory) Prune(height uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if height > uint64(len(m.blocks)) {
+		height = uint64(len(m.blocks))
+	}
+
+	for h := m.pruned + 1; h <= height; h++ {
+		data := m.blocks[h-1]
+		m.blocks[h-1] = database.BlockData{Hash: data.Hash, Header: data.Header, Pruned: true}
+	}
+	if height > m.pruned {
+		m.pruned = height
+	}
+
+	return nil
+}
+
+// Pruned returns the height up to which transactions of blocks have been dropped.
+func (m *Memory) Pruned() (uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.pruned, nil
 }
 
 // Reset removes all the blocks from memory.
@@ -50,9 +164,58 @@ func (m *Memory) Reset() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.blocks = nil
+	m.hashes = make(map[string]uint64)
+	m.snapshots = make(map[uint64]database.Snapshot)
+	m.pruned = 0
 	return nil
 }
 
+// WriteSnapshot writes the database.Snapshot to memory.
+func (m *Memory) WriteSnapshot(snapshot database.Snapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.snapshots[snapshot.Height] = snapshot
+	return nil
+}
+
+// ReadSnapshot reads the database.Snapshot from memory by given height.
+func (m *Memory) ReadSnapshot(height uint64) (*database.Snapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	snapshot, ok := m.snapshots[height]
+	if !ok {
+		return nil, fmt.Errorf("cannot read snapshot with height: %d", height)
+	}
+	return &snapshot, nil
+}
+
+// RemoveSnapshot removes the database.Snapshot from memory by given height.
+func (m *Memory) RemoveSnapshot(height uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.snapshots, height)
+	return nil
+}
+
+// Snapshots returns the heights of all snapshots kept in memory in ascending order.
+func (m *Memory) Snapshots() ([]uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	heights := make([]uint64, 0, len(m.snapshots))
+	for height := range m.snapshots {
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i, j int) bool {
+		return heights[i] < heights[j]
+	})
+
+	return heights, nil
+}
+
 // Close is a no-op method required by database.Storage interface.
 func (m *Memory) Close() error {
 	return nil
@@ -45,3 +45,141 @@ func TestMemory(t *testing.T) {
 	_, err = m.Read(1)
 	assert.NotNil(t, err)
 }
+
+func TestMemory_Snapshots(t *testing.T) {
+	m, err := memory.New()
+	assert.Nil(t, err)
+
+	// Write snapshots out of order
+	err = m.WriteSnapshot(database.Snapshot{Height: 4})
+	assert.Nil(t, err)
+	err = m.WriteSnapshot(database.Snapshot{Height: 2})
+	assert.Nil(t, err)
+
+	// List snapshots in ascending order
+	heights, err := m.Snapshots()
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{2, 4}, heights)
+
+	// Read and remove snapshot
+	snapshot, err := m.ReadSnapshot(2)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), snapshot.Height)
+
+	err = m.RemoveSnapshot(2)
+	assert.Nil(t, err)
+	_, err = m.ReadSnapshot(2)
+	assert.NotNil(t, err)
+
+	// Reset removes snapshots as well
+	err = m.Reset()
+	assert.Nil(t, err)
+	heights, err = m.Snapshots()
+	assert.Nil(t, err)
+	assert.Empty(t, heights)
+}
+
+func TestMemory_ReadByHash(t *testing.T) {
+	m, err := memory.New()
+	assert.Nil(t, err)
+
+	// Write blocks with distinct hashes
+	err = m.Write(1, database.BlockData{Hash: "0xabc"})
+	assert.Nil(t, err)
+	err = m.Write(2, database.BlockData{Hash: "0xdef"})
+	assert.Nil(t, err)
+
+	// Read block by hash regardless of its case
+	data, err := m.ReadByHash("0xABC")
+	assert.Nil(t, err)
+	assert.Equal(t, "0xabc", data.Hash)
+
+	// Read block by unknown hash and assert err
+	_, err = m.ReadByHash("0x123")
+	assert.NotNil(t, err)
+
+	// Reset removes the index as well
+	err = m.Reset()
+	assert.Nil(t, err)
+	_, err = m.ReadByHash("0xabc")
+	assert.NotNil(t, err)
+}
+
+func TestMemory_RangeAndTruncate(t *testing.T) {
+	m, err := memory.New()
+	assert.Nil(t, err)
+
+	// Head of the empty memory is zero
+	head, err := m.Head()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), head)
+
+	for _, hash := range []string{"0x01", "0x02", "0x03"} {
+		err = m.Write(head+1, database.BlockData{Hash: hash})
+		assert.Nil(t, err)
+		head++
+	}
+
+	// Range over blocks, the range is limited to the stored blocks
+	var hashes []string
+	err = m.Range(2, 10, func(height uint64, data database.BlockData) error {
+		hashes = append(hashes, data.Hash)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"0x02", "0x03"}, hashes)
+
+	// Truncate the last two blocks
+	err = m.TruncateFrom(2)
+	assert.Nil(t, err)
+	head, err = m.Head()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), head)
+	_, err = m.ReadByHash("0x02")
+	assert.NotNil(t, err)
+
+	// Write the block on top of the truncated chain
+	err = m.Write(2, database.BlockData{Hash: "0x12"})
+	assert.Nil(t, err)
+	data, err := m.ReadByHash("0x12")
+	assert.Nil(t, err)
+	assert.Equal(t, "0x12", data.Hash)
+}
+
+func TestMemory_Prune(t *testing.T) {
+	m, err := memory.New()
+	assert.Nil(t, err)
+
+	for height := uint64(1); height <= 3; height++ {
+		data := database.BlockData{
+			Header: database.BlockHeader{Height: height},
+			Txs:    []database.BlockTx{{}},
+		}
+		err = m.Write(height, data)
+		assert.Nil(t, err)
+	}
+
+	// Prune the first two blocks, headers are kept
+	err = m.Prune(2)
+	assert.Nil(t, err)
+	pruned, err := m.Pruned()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), pruned)
+
+	data, err := m.Read(2)
+	assert.Nil(t, err)
+	assert.True(t, data.Pruned)
+	assert.Nil(t, data.Txs)
+	assert.Equal(t, uint64(2), data.Header.Height)
+
+	data, err = m.Read(3)
+	assert.Nil(t, err)
+	assert.False(t, data.Pruned)
+
+	// Pruning below the pruned height is a no-op
+	err = m.Prune(1)
+	assert.Nil(t, err)
+	pruned, err = m.Pruned()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), pruned)
+}
@@ -0,0 +1,866 @@
+// Package segment provides the database.Storage implementation which appends
+// blocks to size-capped segment files instead of keeping a file per block.
+package segment
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/goccy/go-json"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/storage/disk"
+)
+
+const (
+	// DefaultMaxSize is the default size cap of a single segment file.
+	DefaultMaxSize = 64 << 20
+
+	// headerSize is the size of the record header: height (8 bytes),
+	// hash length (2 bytes), data length (4 bytes) and CRC-32 (4 bytes).
+	headerSize = 18
+
+	// maxRecordSize bounds the hash and data lengths declared by the record header.
+	// Blocks are encoded far below it, so a larger record is reported as damaged
+	// instead of allocating the buffer for it.
+	maxRecordSize = 1 << 28
+
+	// segmentExt is the extension of segment files.
+	segmentExt = ".seg"
+
+	// indexExt is the extension of the index files of sealed segments.
+	indexExt = ".idx"
+
+	// indexHeaderSize is the size of the index file header: segment size (8 bytes),
+	// height of the first record (8 bytes) and number of records (4 bytes).
+	indexHeaderSize = 20
+
+	// snapshotsDir is the subdirectory of the data path keeping state snapshots.
+	snapshotsDir = "snapshots"
+
+	// prunedFile is the file of the data path keeping the height up to which
+	// transactions of blocks have been dropped.
+	prunedFile = "pruned"
+)
+
+// location points to the record of the block within the segment files.
+type location struct {
+	segment int
+	offset  int64
+	size    int64
+}
+
+// damage points to the damaged record found while opening the storage. The records
+// following it are not indexed, until the storage is truncated at the damaged block.
+type damage struct {
+	location
+	err error
+}
+
+// Segment represents the database.Storage implementation which appends blocks
+// to the segment files. Every record keeps the block height, hash and data encoded
+// with the database binary codec, protected with the checksum. Offsets of all records are indexed in memory while
+// opening the storage. Index of every sealed segment is persisted next to it, so only the last
+// segment has to be scanned. It implements database.SnapshotStorage and database.PrunableStorage as well.
+type Segment struct {
+	dataPath string
+	maxSize  int64
+
+	mu       sync.RWMutex
+	segments []*os.File
+	size     int64
+	index    []location
+	hashes   map[string]uint64
+	damaged  *damage
+	pruned   uint64
+}
+
+// New constructs a new Segment. Segment files found in the data path are scanned
+// to build the index. Record torn by a crash at the end of the last segment is
+// truncated. Any other damaged record is reported as database.CorruptedBlockError
+// by Read and Range, so the database decides whether the chain is truncated there.
+func New(dataPath string, maxSize int64) (*Segment, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	if err := os.MkdirAll(path.Join(dataPath, snapshotsDir), 0755); err != nil {
+		return nil, err
+	}
+
+	s := Segment{
+		dataPath: dataPath,
+		maxSize:  maxSize,
+		hashes:   make(map[string]uint64),
+	}
+	if err := s.open(); err != nil {
+		s.closeSegments()
+		return nil, err
+	}
+	if err := s.loadPruned(); err != nil {
+		s.closeSegments()
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// Write appends the database.BlockData to the last segment. Blocks can be written
+// only in ascending order of their heights.
+func (s *Segment) Write(height uint64, data database.BlockData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.damaged != nil {
+		return s.corrupted()
+	}
+	if int(height) != len(s.index)+1 {
+		return fmt.Errorf("cannot write block with height: %d to the chain of len: %d", height, len(s.index))
+	}
+
+	bs, err := data.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	record := encodeRecord(height, data.Hash, bs)
+
+	// Start a new segment when the record does not fit the current one.
+	if len(s.segments) == 0 || (s.size > 0 && s.size+int64(len(record)) > s.maxSize) {
+		if len(s.segments) > 0 {
+			if err = s.writeIndex(len(s.segments) - 1); err != nil {
+				return err
+			}
+		}
+		if err = s.addSegment(); err != nil {
+			return err
+		}
+	}
+
+	f := s.segments[len(s.segments)-1]
+	if _, err = f.WriteAt(record, s.size); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+
+	s.index = append(s.index, location{segment: len(s.segments) - 1, offset: s.size, size: int64(len(record))})
+	s.hashes[strings.ToLower(data.Hash)] = height
+	s.size += int64(len(record))
+
+	return nil
+}
+
+// Read reads the database.BlockData by given height using the offset index.
+func (s *Segment) Read(height uint64) (*database.BlockData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.read(height)
+}
+
+// ReadByHash reads the database.BlockData by given block hash.
+func (s *Segment) ReadByHash(hash string) (*database.BlockData, error) {
+	s.mu.RLock()
+	height, ok := s.hashes[strings.ToLower(hash)]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("cannot find block with hash: %s", hash)
+	}
+
+	return s.Read(height)
+}
+
+// Head returns the height of the last block appended to the segments.
+func (s *Segment) Head() (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.head(), nil
+}
+
+// Range reads the blocks with heights from the given range sequentially and passes them
+// to the function. It is much faster than reading blocks one by one while replaying
+// the chain, as every segment is read with a single buffered pass. Range is limited to
+// the stored blocks and stops on the first error returned by the function.
+func (s *Segment) Range(from, to uint64, fn func(height uint64, data database.BlockData) error) error {
+	s.mu.RLock()
+	if from == 0 {
+		from = 1
+	}
+	if to > s.head() {
+		to = s.head()
+	}
+	if from > to {
+		s.mu.RUnlock()
+		return nil
+	}
+
+	// Damaged record is reported once all the preceding blocks are passed to the function.
+	var damaged error
+	if to > uint64(len(s.index)) {
+		to = uint64(len(s.index))
+		damaged = s.corrupted()
+	}
+	locs := append([]location{}, s.index[from-1:to]...)
+	segments := append([]*os.File{}, s.segments...)
+	s.mu.RUnlock()
+
+	// Function is called without holding the lock, so it can use the storage as well.
+	var r *bufio.Reader
+	for idx, loc := range locs {
+		height := from + uint64(idx)
+
+		// Start a buffered pass whenever the next segment is reached.
+		if idx == 0 || loc.segment != locs[idx-1].segment {
+			r = bufio.NewReader(io.NewSectionReader(segments[loc.segment], loc.offset, 1<<62))
+		}
+
+		h, _, bs, err := readRecord(r)
+		if err == nil && h != height {
+			err = fmt.Errorf("unexpected height: %d", h)
+		}
+		if err != nil {
+			return &database.CorruptedBlockError{Height: height, Err: err}
+		}
+
+		var data database.BlockData
+		if err = data.UnmarshalBinary(bs); err != nil {
+			return &database.CorruptedBlockError{Height: height, Err: err}
+		}
+		if err = fn(height, data); err != nil {
+			return err
+		}
+	}
+
+	return damaged
+}
+
+// TruncateFrom removes the block with given height and all the following blocks.
+// The segment keeping the block is truncated and all the following segments are removed.
+func (s *Segment) TruncateFrom(height uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if height == 0 {
+		height = 1
+	}
+	if height > s.head() {
+		return nil
+	}
+
+	var loc location
+	if height <= uint64(len(s.index)) {
+		loc = s.index[height-1]
+	} else {
+		loc = s.damaged.location
+	}
+
+	// Segment starting with the block is removed as a whole.
+	keep := loc.segment + 1
+	if loc.offset == 0 && loc.segment > 0 && height > 1 {
+		keep = loc.segment
+		prev := s.index[height-2]
+		loc = location{segment: prev.segment, offset: prev.offset + prev.size}
+	}
+
+	// Remove the following segments starting with the last one, so a crash
+	// in the middle never leaves a gap between the segment files.
+	for id := len(s.segments); id > keep; id-- {
+		_ = s.segments[id-1].Close()
+		if err := removeFile(s.indexPath(id)); err != nil {
+			return err
+		}
+		if err := os.Remove(s.segmentPath(id)); err != nil {
+			return err
+		}
+		s.segments = s.segments[:id-1]
+	}
+
+	// Truncated segment becomes the last one, which is always scanned.
+	if err := removeFile(s.indexPath(loc.segment + 1)); err != nil {
+		return err
+	}
+	f := s.segments[loc.segment]
+	if err := f.Truncate(loc.offset); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	for hash, h := range s.hashes {
+		if h >= height {
+			delete(s.hashes, hash)
+		}
+	}
+	s.index = s.index[:height-1]
+	s.size = loc.offset
+	s.damaged = nil
+
+	if s.pruned >= height {
+		return s.setPruned(height - 1)
+	}
+
+	return nil
+}
+
+// Prune drops transactions of the blocks up to given height. Segments are rewritten
+// as a whole, so only the sealed segments keeping no blocks above the height are pruned,
+// while the blocks of the other segments are pruned once their segments are.
+func (s *Segment) Prune(height uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.damaged != nil {
+		return s.corrupted()
+	}
+
+	for segment := 0; segment < len(s.segments)-1; segment++ {
+		first, locs := s.segmentRecords(segment)
+		last := uint64(first + len(locs))
+		if last > height {
+			break
+		}
+		if last <= s.pruned {
+			continue
+		}
+
+		if err := s.pruneSegment(segment); err != nil {
+			return err
+		}
+		if err := s.setPruned(last); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Pruned returns the height up to which transactions of blocks have been dropped.
+func (s *Segment) Pruned() (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.pruned, nil
+}
+
+// Reset removes all the segments and snapshots and starts with an empty storage.
+func (s *Segment) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closeSegments()
+	s.segments = nil
+	s.size = 0
+	s.index = nil
+	s.hashes = make(map[string]uint64)
+	s.damaged = nil
+	s.pruned = 0
+
+	if err := os.RemoveAll(s.dataPath); err != nil {
+		return err
+	}
+	return os.MkdirAll(path.Join(s.dataPath, snapshotsDir), 0755)
+}
+
+// Close closes all segment files.
+func (s *Segment) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closeSegments()
+	s.segments = nil
+
+	return nil
+}
+
+// WriteSnapshot writes the database.Snapshot in a JSON file named by its height.
+func (s *Segment) WriteSnapshot(snapshot database.Snapshot) error {
+	bs, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	return disk.WriteFileAtomic(s.snapshotPath(snapshot.Height), bs)
+}
+
+// ReadSnapshot reads the JSON file named by given height and decodes it into database.Snapshot.
+func (s *Segment) ReadSnapshot(height uint64) (*database.Snapshot, error) {
+	bs, err := os.ReadFile(s.snapshotPath(height))
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot database.Snapshot
+	if err = json.Unmarshal(bs, &snapshot); err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+// RemoveSnapshot removes the snapshot with given height.
+func (s *Segment) RemoveSnapshot(height uint64) error {
+	return os.Remove(s.snapshotPath(height))
+}
+
+// Snapshots returns the heights of all stored snapshots in ascending order.
+func (s *Segment) Snapshots() ([]uint64, error) {
+	entries, err := os.ReadDir(path.Join(s.dataPath, snapshotsDir))
+	if err != nil {
+		return nil, err
+	}
+
+	var heights []uint64
+	for _, entry := range entries {
+		height, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		heights = append(heights, height)
+	}
+
+	sort.Slice(heights, func(i, j int) bool {
+		return heights[i] < heights[j]
+	})
+
+	return heights, nil
+}
+
+// private API
+
+// open opens all segment files in order and indexes their records.
+func (s *Segment) open() error {
+	entries, err := os.ReadDir(s.dataPath)
+	if err != nil {
+		return err
+	}
+
+	var ids []int
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), segmentExt) {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), segmentExt))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for i, id := range ids {
+		if id != i+1 {
+			return fmt.Errorf("segment: %d is missing", i+1)
+		}
+
+		f, err := os.OpenFile(s.segmentPath(id), os.O_RDWR, 0600)
+		if err != nil {
+			return err
+		}
+		s.segments = append(s.segments, f)
+
+		// Segments following the damaged record are kept open, so they can be truncated.
+		if s.damaged != nil {
+			continue
+		}
+
+		last := i == len(ids)-1
+		if !last && s.loadIndex(i) {
+			continue
+		}
+		if err = s.indexSegment(i, last); err != nil {
+			return err
+		}
+
+		// Index of the sealed segment written before the crash is persisted once again.
+		if !last && s.damaged == nil {
+			if err = s.writeIndex(i); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// indexSegment scans the segment sequentially and indexes all its records. Record
+// torn at the end of the last segment is truncated, as it is the result of a crash
+// in the middle of the write, which has never been acknowledged. Any other damaged
+// record stops the indexing and is remembered to be reported as corrupted.
+func (s *Segment) indexSegment(segment int, last bool) error {
+	f := s.segments[segment]
+
+	r := bufio.NewReader(f)
+	var offset int64
+
+	for {
+		height, hash, bs, err := readRecord(r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err == nil && int(height) != len(s.index)+1 {
+			err = fmt.Errorf("unexpected height: %d", height)
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) && last {
+			if err = f.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			s.damaged = &damage{location: location{segment: segment, offset: offset}, err: err}
+			break
+		}
+
+		size := int64(headerSize + len(hash) + len(bs))
+		s.index = append(s.index, location{segment: segment, offset: offset, size: size})
+		s.hashes[strings.ToLower(hash)] = height
+		offset += size
+	}
+
+	s.size = offset
+
+	return nil
+}
+
+// segmentRecords returns the locations of all the records of the segment, together
+// with the number of the records of the preceding segments.
+func (s *Segment) segmentRecords(segment int) (int, []location) {
+	first := sort.Search(len(s.index), func(i int) bool {
+		return s.index[i].segment >= segment
+	})
+	locs := s.index[first:]
+	for i, loc := range locs {
+		if loc.segment != segment {
+			locs = locs[:i]
+			break
+		}
+	}
+	return first, locs
+}
+
+// pruneSegment rewrites the sealed segment with the blocks keeping only their headers.
+// The segment is replaced atomically, so a crash leaves either the previous or the
+// pruned segment, and its index is written once again.
+func (s *Segment) pruneSegment(segment int) error {
+	first, locs := s.segmentRecords(segment)
+
+	name := s.segmentPath(segment + 1)
+	f, err := os.OpenFile(name+".tmp", os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		_ = f.Close()
+		_ = os.Remove(name + ".tmp")
+		return err
+	}
+
+	pruned := make([]location, 0, len(locs))
+	w := bufio.NewWriter(f)
+	var offset int64
+	for idx := range locs {
+		height := uint64(first + idx + 1)
+
+		data, err := s.read(height)
+		if err != nil {
+			return fail(err)
+		}
+		bs, err := database.BlockData{Hash: data.Hash, Header: data.Header, Pruned: true}.MarshalBinary()
+		if err != nil {
+			return fail(err)
+		}
+		record := encodeRecord(height, data.Hash, bs)
+		if _, err = w.Write(record); err != nil {
+			return fail(err)
+		}
+
+		pruned = append(pruned, location{segment: segment, offset: offset, size: int64(len(record))})
+		offset += int64(len(record))
+	}
+	if err = w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err = f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err = os.Rename(name+".tmp", name); err != nil {
+		return fail(err)
+	}
+	if err = syncDir(s.dataPath); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	_ = s.segments[segment].Close()
+	s.segments[segment] = f
+	copy(s.index[first:], pruned)
+
+	return s.writeIndex(segment)
+}
+
+// setPruned persists the height up to which transactions of blocks have been dropped.
+func (s *Segment) setPruned(height uint64) error {
+	if err := disk.WriteFileAtomic(path.Join(s.dataPath, prunedFile), []byte(strconv.FormatUint(height, 10))); err != nil {
+		return err
+	}
+	s.pruned = height
+	return nil
+}
+
+// loadPruned reads the height up to which transactions of blocks have been dropped.
+func (s *Segment) loadPruned() error {
+	bs, err := os.ReadFile(path.Join(s.dataPath, prunedFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.pruned, err = strconv.ParseUint(strings.TrimSpace(string(bs)), 10, 64)
+	return err
+}
+
+// read reads the database.BlockData by given height. It has to be called while holding the lock.
+func (s *Segment) read(height uint64) (*database.BlockData, error) {
+	if s.damaged != nil && int(height) == len(s.index)+1 {
+		return nil, s.corrupted()
+	}
+	if height == 0 || int(height) > len(s.index) {
+		return nil, fmt.Errorf("cannot read block with height: %d from the chain of len: %d", height, len(s.index))
+	}
+
+	loc := s.index[height-1]
+
+	record := make([]byte, loc.size)
+	if _, err := s.segments[loc.segment].ReadAt(record, loc.offset); err != nil {
+		return nil, &database.CorruptedBlockError{Height: height, Err: err}
+	}
+
+	_, _, bs, err := decodeRecord(record)
+	if err != nil {
+		return nil, &database.CorruptedBlockError{Height: height, Err: err}
+	}
+
+	var data database.BlockData
+	if err = data.UnmarshalBinary(bs); err != nil {
+		return nil, &database.CorruptedBlockError{Height: height, Err: err}
+	}
+
+	return &data, nil
+}
+
+// writeIndex persists the sizes and hashes of all the records of the sealed segment.
+// The index file ends with the checksum of its content.
+func (s *Segment) writeIndex(segment int) error {
+	first, locs := s.segmentRecords(segment)
+
+	hashes := make(map[uint64]string, len(locs))
+	for hash, height := range s.hashes {
+		if height > uint64(first) && height <= uint64(first+len(locs)) {
+			hashes[height] = hash
+		}
+	}
+
+	var size int64
+	bs := make([]byte, indexHeaderSize)
+	for idx, loc := range locs {
+		hash := hashes[uint64(first+idx+1)]
+		bs = binary.BigEndian.AppendUint32(bs, uint32(loc.size))
+		bs = binary.BigEndian.AppendUint16(bs, uint16(len(hash)))
+		bs = append(bs, hash...)
+		size += loc.size
+	}
+	binary.BigEndian.PutUint64(bs[0:8], uint64(size))
+	binary.BigEndian.PutUint64(bs[8:16], uint64(first+1))
+	binary.BigEndian.PutUint32(bs[16:20], uint32(len(locs)))
+	bs = binary.BigEndian.AppendUint32(bs, crc32.ChecksumIEEE(bs))
+
+	return disk.WriteFileAtomic(s.indexPath(segment+1), bs)
+}
+
+// loadIndex indexes the records of the sealed segment using its index file. It reports
+// false whenever the index file is missing or does not match the segment, in which
+// case the segment has to be scanned.
+func (s *Segment) loadIndex(segment int) bool {
+	bs, err := os.ReadFile(s.indexPath(segment + 1))
+	if err != nil || len(bs) < indexHeaderSize+4 {
+		return false
+	}
+	content, sum := bs[:len(bs)-4], binary.BigEndian.Uint32(bs[len(bs)-4:])
+	if crc32.ChecksumIEEE(content) != sum {
+		return false
+	}
+
+	info, err := s.segments[segment].Stat()
+	if err != nil || int64(binary.BigEndian.Uint64(content[0:8])) != info.Size() {
+		return false
+	}
+	if binary.BigEndian.Uint64(content[8:16]) != uint64(len(s.index)+1) {
+		return false
+	}
+
+	count := int(binary.BigEndian.Uint32(content[16:20]))
+	locs := make([]location, 0, count)
+	hashes := make([]string, 0, count)
+
+	var offset int64
+	r := content[indexHeaderSize:]
+	for i := 0; i < count; i++ {
+		if len(r) < 6 {
+			return false
+		}
+		size := int64(binary.BigEndian.Uint32(r[0:4]))
+		hashLen := int(binary.BigEndian.Uint16(r[4:6]))
+		if len(r) < 6+hashLen {
+			return false
+		}
+		locs = append(locs, location{segment: segment, offset: offset, size: size})
+		hashes = append(hashes, string(r[6:6+hashLen]))
+		offset += size
+		r = r[6+hashLen:]
+	}
+	if len(r) != 0 || offset != info.Size() {
+		return false
+	}
+
+	for i, hash := range hashes {
+		s.hashes[strings.ToLower(hash)] = uint64(len(s.index) + i + 1)
+	}
+	s.index = append(s.index, locs...)
+	s.size = offset
+
+	return true
+}
+
+// addSegment creates a new segment file and makes it the one blocks are appended to.
+func (s *Segment) addSegment() error {
+	f, err := os.OpenFile(s.segmentPath(len(s.segments)+1), os.O_CREATE|os.O_EXCL|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	s.segments = append(s.segments, f)
+	s.size = 0
+	return nil
+}
+
+// head returns the height of the last block, including the damaged one.
+func (s *Segment) head() uint64 {
+	if s.damaged != nil {
+		return uint64(len(s.index) + 1)
+	}
+	return uint64(len(s.index))
+}
+
+// corrupted returns the error describing the damaged record.
+func (s *Segment) corrupted() error {
+	return &database.CorruptedBlockError{Height: uint64(len(s.index) + 1), Err: s.damaged.err}
+}
+
+func (s *Segment) closeSegments() {
+	for _, f := range s.segments {
+		_ = f.Close()
+	}
+}
+
+func (s *Segment) segmentPath(id int) string {
+	return path.Join(s.dataPath, fmt.Sprintf("%08d%s", id, segmentExt))
+}
+
+func (s *Segment) indexPath(id int) string {
+	return path.Join(s.dataPath, fmt.Sprintf("%08d%s", id, indexExt))
+}
+
+func (s *Segment) snapshotPath(height uint64) string {
+	return path.Join(s.dataPath, snapshotsDir, fmt.Sprintf("%d.json", height))
+}
+
+// syncDir flushes the directory, so the renames within it survive the crash.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	return f.Sync()
+}
+
+// removeFile removes the file, which does not have to exist.
+func removeFile(name string) error {
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// encodeRecord builds the record out of the block height, hash and data.
+func encodeRecord(height uint64, hash string, data []byte) []byte {
+	record := make([]byte, headerSize, headerSize+len(hash)+len(data))
+	binary.BigEndian.PutUint64(record[0:8], height)
+	binary.BigEndian.PutUint16(record[8:10], uint16(len(hash)))
+	binary.BigEndian.PutUint32(record[10:14], uint32(len(data)))
+
+	record = append(record, hash...)
+	record = append(record, data...)
+	binary.BigEndian.PutUint32(record[14:18], crc32.ChecksumIEEE(record[headerSize:]))
+
+	return record
+}
+
+// decodeRecord splits the record into the block height, hash and data.
+func decodeRecord(record []byte) (uint64, string, []byte, error) {
+	if len(record) < headerSize {
+		return 0, "", nil, io.ErrUnexpectedEOF
+	}
+
+	height := binary.BigEndian.Uint64(record[0:8])
+	hashLen := int(binary.BigEndian.Uint16(record[8:10]))
+	dataLen := int(binary.BigEndian.Uint32(record[10:14]))
+
+	if len(record) != headerSize+hashLen+dataLen {
+		return height, "", nil, io.ErrUnexpectedEOF
+	}
+	if binary.BigEndian.Uint32(record[14:18]) != crc32.ChecksumIEEE(record[headerSize:]) {
+		return height, "", nil, errors.New("checksum mismatch")
+	}
+
+	return height, string(record[headerSize : headerSize+hashLen]), record[headerSize+hashLen:], nil
+}
+
+// readRecord reads the next record from the reader. It returns io.EOF only when
+// there are no more records, a partially written record is reported with
+// io.ErrUnexpectedEOF.
+func readRecord(r io.Reader) (uint64, string, []byte, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, "", nil, err
+	}
+
+	hashLen := int(binary.BigEndian.Uint16(header[8:10]))
+	dataLen := int(binary.BigEndian.Uint32(header[10:14]))
+	if hashLen+dataLen > maxRecordSize {
+		return binary.BigEndian.Uint64(header[0:8]), "", nil, errors.New("record too large")
+	}
+
+	record := make([]byte, headerSize+hashLen+dataLen)
+	copy(record, header)
+	if _, err := io.ReadFull(r, record[headerSize:]); err != nil {
+		return binary.BigEndian.Uint64(header[0:8]), "", nil, io.ErrUnexpectedEOF
+	}
+
+	return decodeRecord(record)
+}
@@ -0,0 +1,282 @@
+package segment_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/storage/segment"
+)
+
+func TestSegment(t *testing.T) {
+	// Initialize new segment storage with tiny segments
+	dataPath := t.TempDir()
+	s, err := segment.New(dataPath, 64)
+	assert.Nil(t, err)
+
+	// Read block data and assert err (block does not exist yet)
+	_, err = s.Read(1)
+	assert.NotNil(t, err)
+
+	// Write enough blocks to fill more than a single segment
+	for height := uint64(1); height <= 5; height++ {
+		err = s.Write(height, database.BlockData{Hash: "0x0" + string(rune('0'+height))})
+		assert.Nil(t, err)
+	}
+
+	// Ensure blocks are spread over multiple segments
+	matches, err := filepath.Glob(filepath.Join(dataPath, "*.seg"))
+	assert.Nil(t, err)
+	assert.Greater(t, len(matches), 1)
+
+	// Write block data with unexpected height
+	err = s.Write(7, database.BlockData{})
+	assert.NotNil(t, err)
+
+	// Read block data by height and hash
+	data, err := s.Read(3)
+	assert.Nil(t, err)
+	assert.Equal(t, "0x03", data.Hash)
+
+	data, err = s.ReadByHash("0x05")
+	assert.Nil(t, err)
+	assert.Equal(t, "0x05", data.Hash)
+
+	// Range over blocks sequentially, the range is limited to the stored blocks
+	var heights []uint64
+	err = s.Range(2, 10, func(height uint64, data database.BlockData) error {
+		heights = append(heights, height)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{2, 3, 4, 5}, heights)
+
+	// Truncate blocks spread over multiple segments
+	err = s.TruncateFrom(2)
+	assert.Nil(t, err)
+	head, err := s.Head()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), head)
+	_, err = s.ReadByHash("0x03")
+	assert.NotNil(t, err)
+	matches, err = filepath.Glob(filepath.Join(dataPath, "*.seg"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(matches))
+
+	// Append blocks after the truncation
+	err = s.Write(2, database.BlockData{Hash: "0x12"})
+	assert.Nil(t, err)
+	data, err = s.Read(2)
+	assert.Nil(t, err)
+	assert.Equal(t, "0x12", data.Hash)
+
+	// Reset segment storage and read block data one more time
+	err = s.Reset()
+	assert.Nil(t, err)
+	_, err = s.Read(1)
+	assert.NotNil(t, err)
+	_, err = s.ReadByHash("0x01")
+	assert.NotNil(t, err)
+}
+
+func TestSegment_Reopen(t *testing.T) {
+	dataPath := t.TempDir()
+
+	s, err := segment.New(dataPath, 64)
+	assert.Nil(t, err)
+
+	for height := uint64(1); height <= 3; height++ {
+		err = s.Write(height, database.BlockData{Hash: "0x0" + string(rune('0'+height))})
+		assert.Nil(t, err)
+	}
+	err = s.Close()
+	assert.Nil(t, err)
+
+	// Simulate the crash in the middle of writing the next block to the last segment
+	f, err := os.OpenFile(lastSegment(t, dataPath), os.O_APPEND|os.O_WRONLY, 0600)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 4, 0})
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	// Ensure blocks are indexed once again and the torn record is dropped
+	s, err = segment.New(dataPath, 256)
+	assert.Nil(t, err)
+
+	data, err := s.Read(3)
+	assert.Nil(t, err)
+	assert.Equal(t, "0x03", data.Hash)
+
+	err = s.Write(4, database.BlockData{Hash: "0x04"})
+	assert.Nil(t, err)
+
+	data, err = s.ReadByHash("0x04")
+	assert.Nil(t, err)
+	assert.Equal(t, "0x04", data.Hash)
+}
+
+func TestSegment_Index(t *testing.T) {
+	dataPath := t.TempDir()
+
+	s, err := segment.New(dataPath, 64)
+	assert.Nil(t, err)
+
+	for height := uint64(1); height <= 5; height++ {
+		err = s.Write(height, database.BlockData{Hash: "0x0" + string(rune('0'+height))})
+		assert.Nil(t, err)
+	}
+	err = s.Close()
+	assert.Nil(t, err)
+
+	// Ensure every sealed segment has its index persisted
+	segments, err := filepath.Glob(filepath.Join(dataPath, "*.seg"))
+	assert.Nil(t, err)
+	indexes, err := filepath.Glob(filepath.Join(dataPath, "*.idx"))
+	assert.Nil(t, err)
+	assert.Equal(t, len(segments)-1, len(indexes))
+
+	// Damage one of the indexes, so its segment has to be scanned once again
+	err = os.WriteFile(indexes[0], []byte("damaged"), 0600)
+	assert.Nil(t, err)
+
+	s, err = segment.New(dataPath, 64)
+	assert.Nil(t, err)
+
+	head, err := s.Head()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), head)
+
+	for height := uint64(1); height <= 5; height++ {
+		data, err := s.ReadByHash("0x0" + string(rune('0'+height)))
+		assert.Nil(t, err)
+		assert.Equal(t, "0x0"+string(rune('0'+height)), data.Hash)
+	}
+
+	// Ensure the damaged index is written once again
+	bs, err := os.ReadFile(indexes[0])
+	assert.Nil(t, err)
+	assert.NotEqual(t, []byte("damaged"), bs)
+
+	// Truncate blocks and ensure the indexes of the removed segments are removed too
+	err = s.TruncateFrom(2)
+	assert.Nil(t, err)
+	indexes, err = filepath.Glob(filepath.Join(dataPath, "*.idx"))
+	assert.Nil(t, err)
+	assert.Empty(t, indexes)
+}
+
+func TestSegment_Prune(t *testing.T) {
+	dataPath := t.TempDir()
+
+	s, err := segment.New(dataPath, 64)
+	assert.Nil(t, err)
+
+	for height := uint64(1); height <= 5; height++ {
+		err = s.Write(height, database.BlockData{Hash: "0x0" + string(rune('0'+height))})
+		assert.Nil(t, err)
+	}
+
+	// Prune blocks and ensure the sealed segments are pruned only
+	err = s.Prune(10)
+	assert.Nil(t, err)
+	pruned, err := s.Pruned()
+	assert.Nil(t, err)
+	assert.Greater(t, pruned, uint64(0))
+	assert.Less(t, pruned, uint64(5))
+
+	data, err := s.Read(1)
+	assert.Nil(t, err)
+	assert.True(t, data.Pruned)
+	data, err = s.Read(5)
+	assert.Nil(t, err)
+	assert.False(t, data.Pruned)
+
+	// Reopen the storage and ensure pruned blocks are still found
+	err = s.Close()
+	assert.Nil(t, err)
+	s, err = segment.New(dataPath, 64)
+	assert.Nil(t, err)
+
+	restored, err := s.Pruned()
+	assert.Nil(t, err)
+	assert.Equal(t, pruned, restored)
+	data, err = s.ReadByHash("0x01")
+	assert.Nil(t, err)
+	assert.True(t, data.Pruned)
+
+	// Truncate the pruned blocks and ensure the pruned height follows
+	err = s.TruncateFrom(1)
+	assert.Nil(t, err)
+	pruned, err = s.Pruned()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), pruned)
+}
+
+func TestSegment_Corrupted(t *testing.T) {
+	dataPath := t.TempDir()
+
+	s, err := segment.New(dataPath, 256)
+	assert.Nil(t, err)
+
+	for height := uint64(1); height <= 3; height++ {
+		err = s.Write(height, database.BlockData{Hash: "0x0" + string(rune('0'+height))})
+		assert.Nil(t, err)
+	}
+	err = s.Close()
+	assert.Nil(t, err)
+
+	// Damage the last record without shortening the segment
+	bs, err := os.ReadFile(lastSegment(t, dataPath))
+	assert.Nil(t, err)
+	bs[len(bs)-1] ^= 0xff
+	err = os.WriteFile(lastSegment(t, dataPath), bs, 0600)
+	assert.Nil(t, err)
+
+	// Ensure the damaged record is kept and reported as corrupted
+	s, err = segment.New(dataPath, 256)
+	assert.Nil(t, err)
+
+	head, err := s.Head()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), head)
+
+	var corrupted *database.CorruptedBlockError
+	_, err = s.Read(3)
+	assert.ErrorAs(t, err, &corrupted)
+	assert.Equal(t, uint64(3), corrupted.Height)
+
+	var heights []uint64
+	err = s.Range(1, 3, func(height uint64, data database.BlockData) error {
+		heights = append(heights, height)
+		return nil
+	})
+	assert.ErrorAs(t, err, &corrupted)
+	assert.Equal(t, []uint64{1, 2}, heights)
+
+	err = s.Write(4, database.BlockData{Hash: "0x04"})
+	assert.ErrorAs(t, err, &corrupted)
+
+	// Truncate the damaged block and append blocks once again
+	err = s.TruncateFrom(3)
+	assert.Nil(t, err)
+	head, err = s.Head()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), head)
+
+	err = s.Write(3, database.BlockData{Hash: "0x13"})
+	assert.Nil(t, err)
+	data, err := s.Read(3)
+	assert.Nil(t, err)
+	assert.Equal(t, "0x13", data.Hash)
+}
+
+// Helper functions
+
+func lastSegment(t *testing.T, dataPath string) string {
+	matches, err := filepath.Glob(filepath.Join(dataPath, "*.seg"))
+	assert.Nil(t, err)
+	assert.NotEmpty(t, matches)
+	return matches[len(matches)-1]
+}
@@ -0,0 +1,39 @@
+// Package storage constructs the database.Storage implementation selected by its name.
+package storage
+
+import (
+	"fmt"
+
+	"github.com/tchorzewski1991/fitbit/core/blockchain/database"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/storage/disk"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/storage/memory"
+	"github.com/tchorzewski1991/fitbit/core/blockchain/storage/segment"
+)
+
+// Names of the supported storage engines.
+const (
+	Disk    = "disk"
+	Segment = "segment"
+	Memory  = "memory"
+)
+
+// Config keeps the settings of the storage engines.
+type Config struct {
+	Engine      string
+	DataPath    string
+	SegmentSize int64
+}
+
+// New constructs the database.Storage implementation selected by the configuration.
+func New(cfg Config) (database.Storage, error) {
+	switch cfg.Engine {
+	case Disk:
+		return disk.New(cfg.DataPath)
+	case Segment:
+		return segment.New(cfg.DataPath, cfg.SegmentSize)
+	case Memory:
+		return memory.New()
+	default:
+		return nil, fmt.Errorf("unknown storage: %s", cfg.Engine)
+	}
+}
@@ -13,6 +13,8 @@ import (
 type Worker struct {
 	state       *state.State
 	ticker      *time.Ticker
+	expiry      *time.Ticker
+	rejournal   *time.Ticker
 	ev          EventHandler
 	wg          sync.WaitGroup
 	shutdown    chan struct{}
@@ -25,10 +27,16 @@ type EventHandler func(s string, args ...any)
 
 var peerSyncInterval = 90 * time.Second
 
+var mempoolExpiryInterval = time.Minute
+
+var mempoolRejournalInterval = 10 * time.Minute
+
 func Run(s *state.State, ev EventHandler) {
 	w := Worker{
 		state:       s,
 		ticker:      time.NewTicker(peerSyncInterval),
+		expiry:      time.NewTicker(mempoolExpiryInterval),
+		rejournal:   time.NewTicker(mempoolRejournalInterval),
 		ev:          ev,
 		shutdown:    make(chan struct{}),
 		startMining: make(chan bool, 1),
@@ -48,6 +56,8 @@ func Run(s *state.State, ev EventHandler) {
 		w.peerSyncer,
 		w.txSyncer,
 		w.miningListener,
+		w.mempoolExpirer,
+		w.mempoolJournaler,
 	}
 
 	w.wg.Add(len(operations))
@@ -79,6 +89,8 @@ func (w *Worker) Shutdown() {
 	w.StopMining()
 
 	w.ticker.Stop()
+	w.expiry.Stop()
+	w.rejournal.Stop()
 
 	close(w.shutdown)
 	w.wg.Wait()
@@ -152,13 +164,16 @@ func (w *Worker) Sync() {
 
 		w.ev("[WORKER][Sync][Requesting blocks from peer: %s]", peer.Host)
 		blocks, err := w.state.RequestPeerBlocks(peer)
-		if err != nil {
+		switch {
+		case errors.Is(err, database.ErrBlockPruned):
+			w.ev("[WORKER][Sync][Peer: %s has pruned blocks, falling back to other peers]", peer.Host)
+		case err != nil:
 			w.ev("[WORKER][Sync][Blocks request for peer: %s failed: %s]", peer.Host, err)
 		}
 
 		w.ev("[WORKER][Sync][Processing blocks from peer: %s]", peer.Host)
 		for _, block := range blocks {
-			if err = w.state.ProcessBlock(block); err != nil {
+			if err = w.state.ProcessBlock(block); err != nil && !errors.Is(err, state.ErrBlockKnown) {
 				w.ev("[WORKER][Sync][Block process for peer: %s failed: %s]", peer.Host, err)
 			}
 		}
@@ -207,6 +222,44 @@ func (w *Worker) peerSyncer() {
 	}
 }
 
+func (w *Worker) mempoolExpirer() {
+	w.ev("[WORKER][mempoolExpirer][Started]")
+	defer w.ev("[WORKER][mempoolExpirer][Stopped]")
+
+	for {
+		select {
+		case <-w.expiry.C:
+			if !w.isShutdown() {
+				if n := w.state.ExpireTx(); n > 0 {
+					w.ev("[WORKER][mempoolExpirer][Expired %d tx]", n)
+				}
+			}
+		case <-w.shutdown:
+			w.ev("[WORKER][mempoolExpirer][Received shutdown signal]")
+			return
+		}
+	}
+}
+
+func (w *Worker) mempoolJournaler() {
+	w.ev("[WORKER][mempoolJournaler][Started]")
+	defer w.ev("[WORKER][mempoolJournaler][Stopped]")
+
+	for {
+		select {
+		case <-w.rejournal.C:
+			if !w.isShutdown() {
+				if err := w.state.RotateJournal(); err != nil {
+					w.ev("[WORKER][mempoolJournaler][Rotating journal failed: %s]", err)
+				}
+			}
+		case <-w.shutdown:
+			w.ev("[WORKER][mempoolJournaler][Received shutdown signal]")
+			return
+		}
+	}
+}
+
 func (w *Worker) txSyncer() {
 	w.ev("[WORKER][txSyncer][Started]")
 	defer w.ev("[WORKER][txSyncer][Stopped]")
@@ -230,9 +283,9 @@ func (w *Worker) runMining() {
 	w.ev("[WORKER][runMining][Started new mining]")
 	defer w.ev("[WORKER][runMining][Mining finished]")
 
-	// Ensure there are transactions in the mempool.
-	if size := w.state.MempoolSize(); size == 0 {
-		w.ev("[WORKER][runMining][Mempool is empty]")
+	// Ensure there are executable transactions in the mempool.
+	if stats := w.state.MempoolStats(); stats.Pending == 0 {
+		w.ev("[WORKER][runMining][Mempool has no pending transactions]")
 		return
 	}
 
@@ -240,7 +293,7 @@ func (w *Worker) runMining() {
 
 	// Verify whether new mining should be started at the end of current mining.
 	defer func() {
-		if size := w.state.MempoolSize(); size > 0 {
+		if stats := w.state.MempoolStats(); stats.Pending > 0 {
 			w.ev("[WORKER][runMining][Signaling new mining]")
 			w.StartMining()
 		}